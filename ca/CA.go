@@ -1,17 +1,17 @@
 package ca
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 
 	bitset "github.com/bits-and-blooms/bitset"
 	def "github.com/jik18001/CTngV3/def"
-	rs "github.com/klauspost/reedsolomon"
+	transport "github.com/jik18001/CTngV3/transport"
 	merkletree "github.com/txaty/go-merkletree"
 )
 
@@ -19,30 +19,58 @@ type CA struct {
 	CTngID      def.CTngID                        `json:"CTngID"`
 	Crypto      *def.GlobalCrypto                 `json:"Crypto,omitempty"`
 	Settings    *def.Settings                     `json:"Settings,omitempty"`
-	Client      *http.Client                      `json:"Client,omitempty"`
+	Transport   transport.Transport               `json:"-"`
+	Clock       def.Clock                         `json:"-"`
 	Updates     map[def.CTngID]*def.Update_CA     `json:"Updates,omitempty"`
 	Updates_EEA map[def.CTngID]*def.Update_CA_EEA `json:"Updates_EEA,omitempty"`
 	PeriodNum   int                               `json:"PeriodNum"`
 	NumMonitors int                               `json:"NumMonitors"`
 	Mal         int                               `json:"Mal"`
+	Erasure     def.ErasureParams                 `json:"Erasure"`
+	CRV         *bitset.BitSet                    // Every revocation so far, the union of the DCRVs sent
+	lock        sync.Mutex                        // Serializes the per-period tasks
+	serials     map[string]int                    // CRV index of every issued certificate, keyed by serial number
+	nextIndex   int                               // CRV index of the next issued certificate
+	pending     *bitset.BitSet                    // Revocations waiting for the next DCRV
+	revPeriod   int                               // Period the pending revocations will be covered in
+	loadCRV     *bitset.BitSet                    // Random revocations of Simulated_load, never mixed into CRV
+	revLock     sync.Mutex                        // Guards CRV and the revocation state, kept apart so requests never wait on an update
 }
 
 func NewCA(CTngID def.CTngID, cryptofile string, settingfile string) *CA {
-	// Initialize a new StoredCrypto object.
-	restoredcrypto := new(def.StoredCrypto)
-
-	// Load the configuration from the file.
-	def.LoadData(&restoredcrypto, cryptofile)
-	crypto, err := def.DecodeCrypto(restoredcrypto)
+	// Load the public configuration and the keys of this entity only.
+	crypto, err := def.LoadCrypto(cryptofile, CTngID)
 	if err != nil {
-		def.HandleError(err, "DecodeCrypto")
+		log.Fatalf("Failed to load the keys of %s: %v", CTngID, err)
 	}
 
 	// Initalize a new Setting object
 	restoredsetting := new(def.Settings)
 	def.LoadData(&restoredsetting, settingfile)
+	CAContext := InitCA(CTngID, crypto, restoredsetting, def.RealClock{})
+	tr := &http.Transport{}
+	t := transport.NewHTTP(&http.Client{
+		Transport: tr,
+	})
+	if restoredsetting.TLS {
+		config, err := crypto.TLSConfig(CTngID)
+		if err != nil {
+			log.Fatalf("Failed to load the TLS certificate: %v", err)
+		}
+		t.EnableTLS(config)
+	}
+	CAContext.Transport = t
+	return CAContext
+}
+
+// InitCA builds a CA from a loaded configuration, the caller sets its Transport
+func InitCA(CTngID def.CTngID, crypto *def.GlobalCrypto, settings *def.Settings, clock def.Clock) *CA {
 	numMonitors := crypto.Total
 	numMal := crypto.Threshold - 1
+	erasure, err := def.GetErasureParams(*settings, CTngID)
+	if err != nil {
+		log.Fatalf("Invalid erasure coding parameters: %v", err)
+	}
 	Updates_EEA := make(map[def.CTngID]*def.Update_CA_EEA, numMonitors)
 	Updates := make(map[def.CTngID]*def.Update_CA, numMonitors)
 	for i := 0; i < numMonitors; i++ {
@@ -52,21 +80,22 @@ func NewCA(CTngID def.CTngID, cryptofile string, settingfile string) *CA {
 		}
 	}
 
-	CAContext := CA{
+	return &CA{
 		CTngID:      CTngID,
 		Crypto:      crypto,
-		Settings:    restoredsetting,
+		Settings:    settings,
+		Clock:       clock,
 		Updates:     Updates,
 		Updates_EEA: Updates_EEA,
 		PeriodNum:   1,
 		NumMonitors: numMonitors,
 		Mal:         numMal,
+		Erasure:     erasure,
+		CRV:         bitset.New(uint(settings.CRV_size)),
+		serials:     make(map[string]int),
+		pending:     bitset.New(uint(settings.CRV_size)),
+		revPeriod:   1,
 	}
-	tr := &http.Transport{}
-	CAContext.Client = &http.Client{
-		Transport: tr,
-	}
-	return &CAContext
 }
 
 func (ca *CA) Sign(msg []byte) (def.RSASig, error) {
@@ -87,7 +116,7 @@ func gcd(a, b int) int {
 	return a
 }
 
-func GenerateRandomCompressedDCRV(totalBits int, density float64) []byte {
+func GenerateRandomDCRV(totalBits int, density float64) *bitset.BitSet {
 	numOnes := int(float64(totalBits) * density)
 	positions := make(map[int]bool)
 	var result []int
@@ -103,15 +132,17 @@ func GenerateRandomCompressedDCRV(totalBits int, density float64) []byte {
 	for _, position := range result {
 		dcrv.Set(uint(position))
 	}
-	dcrv_bytes, _ := dcrv.MarshalBinary()
-	compressed, _ := def.CompressData(dcrv_bytes)
+	return dcrv
+}
+
+func GenerateRandomCompressedDCRV(totalBits int, density float64) []byte {
+	compressed, _ := def.CompressDCRV(GenerateRandomDCRV(totalBits, density))
 	return compressed
 }
 
-func (ca *CA) GenerateSRH(crvbytes []byte, dcrvbytes []byte) *def.SRH {
+func (ca *CA) GenerateSRH(hcrv []byte, dcrvbytes []byte) *def.SRH {
 	// Get the current timestamp in UTC RFC3339 format
-	timestamp := time.Now().UTC().Format(time.RFC3339)
-	hcrv, _ := def.GenerateSHA256(crvbytes)
+	timestamp := ca.Clock.Now().UTC().Format(time.RFC3339)
 	hdcrv, _ := def.GenerateSHA256(dcrvbytes)
 	// Create the SRH
 	srh := &def.SRH{
@@ -134,19 +165,21 @@ func (ca *CA) GenerateSRH(crvbytes []byte, dcrvbytes []byte) *def.SRH {
 	return srh
 }
 
-func (ca *CA) GenerateSRHEEA(crvbytes []byte, dcrvbytes []byte, rootHash []byte) *def.SRH {
+func (ca *CA) GenerateSRHEEA(hcrv []byte, dcrvbytes []byte, rootHash []byte) *def.SRH {
 	// Get the current timestamp in UTC RFC3339 format
-	timestamp := time.Now().UTC().Format(time.RFC3339)
-	hcrv, _ := def.GenerateSHA256(crvbytes)
+	timestamp := ca.Clock.Now().UTC().Format(time.RFC3339)
 	hdcrv, _ := def.GenerateSHA256(dcrvbytes)
-	combine1 := append(hcrv, hdcrv...)
+	combine1 := append(append([]byte{}, hcrv...), hdcrv...)
 	combine2 := append(combine1, rootHash...)
+	// Monitors decode the DCRV with the parameters signed here
+	erasure := ca.Erasure
 	// Create the SRH
 	srh := &def.SRH{
 		CAID:      ca.CTngID.String(),
 		Head:      combine2,
 		PeriodNum: ca.PeriodNum,
 		Timestamp: timestamp,
+		Erasure:   &erasure,
 		Signature: def.RSASig{}, // Placeholder for the signature
 	}
 	// Serialize the SRH for signing
@@ -160,29 +193,29 @@ func (ca *CA) GenerateSRHEEA(crvbytes []byte, dcrvbytes []byte, rootHash []byte)
 }
 
 func (ca *CA) GenerateUpdateEEA() []byte {
-	totalBits := ca.Settings.CRV_size
-	density := ca.Settings.Revocation_ratio
 	mode := ca.Settings.Distribution_Mode
 
-	// Instead of "ca.NumMonitors - ca.Mal" for data, define:
-	// k = Mal+1, m = NumMonitors - k
-	k := ca.Mal + 1         // CHANGED: data shards
-	m := ca.NumMonitors - k // CHANGED: parity shards
+	// k data shards and m parity shards, f+1 and n-(f+1) by default
+	k := ca.Erasure.K
+	m := ca.Erasure.M
 	fmt.Println("Number of monitors:", ca.NumMonitors)
 	fmt.Println("Number of data shares (k):", k)
 	fmt.Println("Number of parity shares (m):", m)
 
-	// Initialize Reed-Solomon with (k, m)
-	enc, err := rs.New(k, m) // CHANGED: use (k, m)
+	// Initialize the erasure encoder with (k, m), Reed-Solomon unless the settings pick another code
+	enc, err := ca.Erasure.Coder()
 	if err != nil {
-		log.Fatalf("Error initializing Reed-Solomon encoder: %v", err)
+		log.Fatalf("Error initializing erasure encoder: %v", err)
 	}
 
-	// Generate a random compressed DCRV
-	dcrv := GenerateRandomCompressedDCRV(totalBits, density)
+	// Take the revocations of the period, the SRH commits to both the DCRV and the CRV
+	dcrvSet, hcrv := ca.takeDCRV()
+	dcrv, err := def.CompressDCRV(dcrvSet)
+	def.HandleError(err, "DCRV compression")
 
-	// We'll split dcrv among the k data shards. Each shard has dataSize = len(dcrv)/k (plus padding if needed).
-	dataSize := len(dcrv) / k // CHANGED
+	// We'll split dcrv among the k data shards. Each shard has dataSize = ceil(len(dcrv)/k) (plus padding if needed).
+	// Rounding up keeps the tail of dcrv, otherwise honest CAs fail the data check of the monitors.
+	dataSize := (len(dcrv) + k - 1) / k // CHANGED
 	if dataSize == 0 {
 		dataSize = 1 // handle edge case if dcrv is very small
 	}
@@ -195,6 +228,9 @@ func (ca *CA) GenerateUpdateEEA() []byte {
 	for i := 0; i < k; i++ {
 		start := i * dataSize
 		end := start + dataSize
+		if start > len(dcrv) {
+			start = len(dcrv)
+		}
 		if end > len(dcrv) {
 			end = len(dcrv)
 		}
@@ -213,7 +249,8 @@ func (ca *CA) GenerateUpdateEEA() []byte {
 	// If EEA is used, do the RS encoding
 	if mode == def.EEA {
 		err := enc.Encode(data) // data[0..k-1] = data shards, data[k..k+m-1] = parity
-		def.HandleError(err, "RS Encoding error")
+		def.HandleError(err, "Erasure encoding error")
+		ca.corruptEncoding(data)
 
 		// Create a slice to hold the data blocks for Merkle generation
 		var RSdataBlocks []merkletree.DataBlock
@@ -227,25 +264,31 @@ func (ca *CA) GenerateUpdateEEA() []byte {
 		rootHashRS := def.GenerateRootHash(RStree)
 
 		// SRHEEA creation
-		SRHEEA := ca.GenerateSRHEEA(dcrv, dcrv, rootHashRS)
+		SRHEEA := ca.GenerateSRHEEA(hcrv, dcrv, rootHashRS)
 		originalLen := len(dcrv)
 
 		// Assign each shard to the corresponding monitor
-		for id, update := range ca.Updates_EEA {
+		// A fresh map is built every period so updates still in flight are never modified.
+		updates := make(map[def.CTngID]*def.Update_CA_EEA, len(ca.Updates_EEA))
+		for id := range ca.Updates_EEA {
 			index := def.GetIndex(id)
-			update.SRH = *SRHEEA
-			update.FileShare = data[index]
 			poi, _ := def.GeneratePOI(RStree, RSdataBlocks, index)
-			update.Head_rs = rootHashRS
-			update.PoI = poi
-			update.OriginalLen = originalLen
-			ca.Updates_EEA[id] = update
+			updates[id] = &def.Update_CA_EEA{
+				MonitorID:   id,
+				SRH:         *SRHEEA,
+				FileShare:   data[index],
+				Head_rs:     rootHashRS,
+				PoI:         poi,
+				OriginalLen: originalLen,
+			}
 		}
+		ca.injectFaults(updates, hcrv, dcrv)
+		ca.Updates_EEA = updates
 		return dcrv
 	}
 
 	// If not in EEA mode, we do not use Reed-Solomon
-	SRH := ca.GenerateSRH(dcrv, dcrv)
+	SRH := ca.GenerateSRH(hcrv, dcrv)
 	for _, update := range ca.Updates {
 		update.SRH = *SRH
 		// update.File = update.File // not changed
@@ -256,8 +299,7 @@ func (ca *CA) GenerateUpdateEEA() []byte {
 func broadcast(ca *CA, endpoint string, data []byte) {
 	monitors := def.GetMonitorURL(*ca.Settings)
 	for _, monitor := range monitors {
-		url := "http://" + monitor + endpoint
-		_, err := ca.Client.Post(url, "application/json", bytes.NewBuffer(data))
+		err := ca.Transport.Send(monitor, endpoint, data)
 		if err != nil {
 			fmt.Println("Failed to send update: ", err)
 		}
@@ -267,13 +309,16 @@ func broadcast(ca *CA, endpoint string, data []byte) {
 func (ca *CA) Send_Update_EEA() {
 	monitors := def.GetMonitorURL(*ca.Settings)
 	for id, monitor := range monitors {
-		url := "http://" + monitor + "/monitor/ca_update_EEA"
-		update := ca.Updates_EEA[id]
+		update, ok := ca.Updates_EEA[id]
+		if !ok {
+			// withheld by a faulty CA
+			continue
+		}
 		update_json, err := json.Marshal(update)
 		if err != nil {
 			log.Fatalf("Failed to marshal update: %v", err)
 		}
-		_, err = ca.Client.Post(url, "application/json", bytes.NewBuffer(update_json))
+		err = ca.Transport.Send(monitor, "/monitor/ca_update_EEA", update_json)
 		if err != nil {
 			fmt.Println("Failed to send update to: ", update.MonitorID)
 			fmt.Println(err)
@@ -286,18 +331,41 @@ func (ca *CA) Send_Update_EEA() {
 	}
 }
 
+// PeriodicTasks generates and sends the update for the current period, then moves on to the next period every MUD.
+func (ca *CA) PeriodicTasks() {
+	// Immediately queue up the next task to run at next MUD.
+	// Doing this first means: no matter how long the rest of the function takes,
+	// the next call will always occur after the correct amount of time.
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+	if ca.Settings.Num_Periods == 0 || ca.PeriodNum < ca.Settings.Num_Periods {
+		ca.Clock.AfterFunc(time.Duration(ca.Settings.MUD)*time.Second, func() {
+			ca.lock.Lock()
+			ca.PeriodNum++
+			ca.lock.Unlock()
+			ca.PeriodicTasks()
+		})
+	}
+	fmt.Println(def.BLUE+"CA", ca.CTngID, "entering period", ca.PeriodNum, def.RESET)
+	ca.GenerateUpdateEEA()
+	ca.Send_Update_EEA()
+}
+
 func StartCA(id def.CTngID, cryptofile string, settingfile string) {
 	newca := NewCA(id, cryptofile, settingfile)
 	fmt.Println(newca.CTngID)
-	newca.GenerateUpdateEEA()
-	//fmt.Println(newca.Updates_EEA[def.CTngID("M1")].Head_rs)
-	//fmt.Println(newca.Updates_EEA[def.CTngID("M1")].SRH)
-	newca.Send_Update_EEA()
+	go StartCAServer(newca)
+	newca.PeriodicTasks()
+	// The periodic tasks run on their own timers from here on.
+	select {}
 }
 
 func StartCADeter(cryptofile string, settingfile string) {
 	for i := 1; i <= 100; i++ {
 		id := def.CTngID(fmt.Sprintf("C%d", i))
-		StartCA(id, cryptofile, settingfile)
+		newca := NewCA(id, cryptofile, settingfile)
+		fmt.Println(newca.CTngID)
+		newca.PeriodicTasks()
 	}
+	select {}
 }
@@ -0,0 +1,123 @@
+package ca
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+type IssueRequest struct {
+	Serial string `json:"serial"`
+}
+
+type IssueResponse struct {
+	CAID  string `json:"caid"`
+	Index int    `json:"index"` // CRV index assigned to the certificate
+}
+
+// RevokeRequest names the certificate by serial number, or by CRV index when the serial is empty
+type RevokeRequest struct {
+	Serial string `json:"serial,omitempty"`
+	Index  *int   `json:"index,omitempty"`
+}
+
+type RevokeResponse struct {
+	CAID   string `json:"caid"`
+	Index  int    `json:"index"`
+	Period int    `json:"period"` // Period of the SRH that will cover the revocation
+}
+
+func bindContext(context *CA, fn func(context *CA, w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fn(context, w, r)
+	}
+}
+
+func issue_handler(ca *CA, w http.ResponseWriter, r *http.Request) {
+	var req IssueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Serial == "" {
+		http.Error(w, "Failed to decode issue request", http.StatusBadRequest)
+		return
+	}
+	index, err := ca.Issue(req.Serial)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(IssueResponse{CAID: ca.CTngID.String(), Index: index})
+}
+
+func revoke_handler(ca *CA, w http.ResponseWriter, r *http.Request) {
+	var req RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Serial == "" && req.Index == nil) {
+		http.Error(w, "Failed to decode revocation request", http.StatusBadRequest)
+		return
+	}
+	var index, period int
+	var err error
+	if req.Serial != "" {
+		index, period, err = ca.Revoke(req.Serial)
+	} else {
+		index = *req.Index
+		period, err = ca.RevokeIndex(index)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RevokeResponse{CAID: ca.CTngID.String(), Index: index, Period: period})
+}
+
+// requireIssuer refuses, under TLS, the peers other than the CA itself and the issuers the settings list for it
+func requireIssuer(ca *CA, handler http.HandlerFunc) http.HandlerFunc {
+	return transport.RequirePeer(ca.Transport, func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && !ca.isIssuer(def.PeerID(r.TLS)) {
+			http.Error(w, "Not an issuer of "+ca.CTngID.String(), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+func (ca *CA) isIssuer(id def.CTngID) bool {
+	if id == ca.CTngID {
+		return true
+	}
+	for _, issuer := range ca.Settings.Issuers[ca.CTngID] {
+		if id == issuer {
+			return true
+		}
+	}
+	return false
+}
+
+// NewCARouter routes the issuance and revocation endpoints of a CA, only its issuers may call them under TLS
+func NewCARouter(ca *CA) *mux.Router {
+	// MUX which routes HTTP directories to functions.
+	gorillaRouter := mux.NewRouter().StrictSlash(true)
+	gorillaRouter.HandleFunc("/ca/issue", requireIssuer(ca, bindContext(ca, issue_handler))).Methods("POST")
+	gorillaRouter.HandleFunc("/ca/revoke", requireIssuer(ca, bindContext(ca, revoke_handler))).Methods("POST")
+	return gorillaRouter
+}
+
+func StartCAServer(ca *CA) {
+	gorillaRouter := NewCARouter(ca)
+	// Serve over TLS when the transport was authenticated
+	var config *tls.Config
+	if t, ok := ca.Transport.(*transport.HTTP); ok {
+		config = t.TLS
+	}
+	// Start the HTTP server.
+	fmt.Println(def.BLUE+"CA listening on port:", ca.Settings.Portmap[ca.CTngID], def.RESET)
+	err := transport.ListenAndServe(ca.Settings.Portmap[ca.CTngID], gorillaRouter, config)
+	// We wont get here unless there's an error.
+	log.Fatal("ListenAndServe: ", err)
+}
@@ -3,17 +3,43 @@ package ca
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
 	rs "github.com/klauspost/reedsolomon"
 	merkletree "github.com/txaty/go-merkletree"
 )
 
+// testconfig is a crypto config generated for the test settings with its key files, the committed one holds no private keys
+var testconfig string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ctng")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testconfig = filepath.Join(dir, "testconfig.json")
+	settings := new(def.Settings)
+	def.LoadData(settings, "../def/testsettings.json")
+	if err := def.GenerateKeyFiles(*settings, testconfig); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestCryptoFunctionality(t *testing.T) {
-	c1 := NewCA(def.CTngID("L1"), "../def/testconfig.json", "../def/testsettings.json")
+	c1 := NewCA(def.CTngID("L1"), testconfig, "../def/testsettings.json")
 	testdata := []byte("test data")
 	sig, _ := c1.Sign([]byte(testdata))
 	err := c1.Verify([]byte(testdata), sig)
@@ -24,7 +50,7 @@ func TestCryptoFunctionality(t *testing.T) {
 }
 
 func TestCAEEA(t *testing.T) {
-	ca := NewCA(def.CTngID("C1"), "../def/testconfig.json", "../def/testsettings.json")
+	ca := NewCA(def.CTngID("C1"), testconfig, "../def/testsettings.json")
 	if ca.Settings == nil {
 		t.Fatal("Settings are nil")
 	}
@@ -32,6 +58,8 @@ func TestCAEEA(t *testing.T) {
 	if ca.Updates_EEA == nil {
 		t.Fatal("Updates map is nil")
 	}
+	// The def tests rewrite the shared settings file with the default distribution mode
+	ca.Settings.Distribution_Mode = def.EEA
 
 	if ca.Settings.Distribution_Mode != def.EEA {
 		t.Fatal("Erasure Encoding Not Enabled")
@@ -43,8 +71,13 @@ func TestCAEEA(t *testing.T) {
 	}
 
 	// Generate updates and check for monitor coverage
-	crv_sent := ca.GenerateUpdate()
-	monitorIDs := def.GenerateRandomCTngIDs(ca.NumMonitors-ca.Mal, ca.NumMonitors)
+	crv_sent := ca.GenerateUpdateEEA()
+	// The CA encodes with the k data shares of its settings, f+1 by default but at most n-f
+	k := ca.Erasure.K
+	if expected, _ := def.GetErasureParams(*ca.Settings, ca.CTngID); k != expected.K || k > ca.NumMonitors-ca.Mal {
+		t.Fatalf("Erasure coding should use %d data shares, not %d", expected.K, k)
+	}
+	monitorIDs := def.GenerateRandomCTngIDs(k, ca.NumMonitors)
 	fmt.Println(monitorIDs)
 	/*
 		monitorIDs := []def.CTngID{
@@ -102,7 +135,7 @@ func TestCAEEA(t *testing.T) {
 	}
 
 	// Reed-Solomon decode
-	dec, err := rs.New(ca.NumMonitors-ca.Mal, ca.Mal)
+	dec, err := rs.New(k, ca.NumMonitors-k)
 	if err != nil {
 		t.Fatalf("Error initializing Reed-Solomon decoder: %v", err)
 	}
@@ -132,16 +165,143 @@ func TestCAEEA(t *testing.T) {
 	}
 
 	var dcrv []byte
-	for _, share := range fileShares[:ca.NumMonitors-ca.Mal] {
+	for _, share := range fileShares[:k] {
 		if share != nil {
-			dcrv = append(dcrv, share...) // Reconstruct the DCRV from the first 5 data shares only
+			dcrv = append(dcrv, share...) // Reconstruct the DCRV from the first k data shares only
 		}
 	}
-	crv_received := def.RemovePadding(dcrv)
-	crv_received = append(crv_received, 0)
+	crv_received := dcrv[:update.OriginalLen]
 	if !reflect.DeepEqual(crv_received, crv_sent) {
 		fmt.Println(len(crv_received))
 		fmt.Println(len(crv_sent))
 		t.Errorf("DCRV reconstruction failed")
 	}
 }
+
+func TestRevocation(t *testing.T) {
+	ca := NewCA(def.CTngID("C1"), testconfig, "../def/testsettings.json")
+	ca.Settings.Distribution_Mode = def.EEA
+	for i, serial := range []string{"01", "02", "03"} {
+		index, err := ca.Issue(serial)
+		if err != nil || index != i {
+			t.Fatalf("Issue %s: index %d, %v", serial, index, err)
+		}
+	}
+	if _, err := ca.Issue("02"); err == nil {
+		t.Errorf("Serial issued twice")
+	}
+	if _, period, err := ca.Revoke("02"); err != nil || period != 1 {
+		t.Fatalf("Revoke failed: period %d, %v", period, err)
+	}
+	if _, err := ca.RevokeIndex(2); err != nil {
+		t.Fatalf("RevokeIndex failed: %v", err)
+	}
+	if _, err := ca.RevokeIndex(1); err == nil {
+		t.Errorf("Index revoked twice")
+	}
+	if _, _, err := ca.Revoke("04"); err == nil {
+		t.Errorf("Unknown serial revoked")
+	}
+
+	dcrv, err := def.DecompressDCRV(ca.GenerateUpdateEEA())
+	if err != nil {
+		t.Fatalf("Failed to decompress DCRV: %v", err)
+	}
+	if dcrv.Count() != 2 || !dcrv.Test(1) || !dcrv.Test(2) {
+		t.Errorf("Unexpected DCRV: %v", dcrv)
+	}
+	srh := ca.Updates_EEA[def.CTngID("M1")].SRH
+	if !reflect.DeepEqual(srh.Head[:32], def.CRVHash(ca.CRV)) {
+		t.Errorf("SRH does not commit to the CRV")
+	}
+
+	// the CRV keeps the revocations of earlier periods
+	ca.PeriodNum++
+	if _, err := ca.RevokeIndex(0); err != nil {
+		t.Fatalf("RevokeIndex failed: %v", err)
+	}
+	dcrv, _ = def.DecompressDCRV(ca.GenerateUpdateEEA())
+	if dcrv.Count() != 1 || ca.CRV.Count() != 3 {
+		t.Errorf("Unexpected DCRV %v and CRV %v", dcrv, ca.CRV)
+	}
+	if _, err := ca.RevokeIndex(1); err == nil {
+		t.Errorf("Index revoked again in a later period")
+	}
+	// an idle period revokes nothing
+	ca.PeriodNum++
+	dcrv, _ = def.DecompressDCRV(ca.GenerateUpdateEEA())
+	if dcrv.Count() != 0 || ca.CRV.Count() != 3 {
+		t.Errorf("Idle period sent DCRV %v, CRV %v", dcrv, ca.CRV)
+	}
+
+	// simulated revocations never reach the CRV of issued certificates
+	ca.Settings.Simulated_load = true
+	if _, err := ca.Issue("05"); err == nil {
+		t.Errorf("Issued a certificate while simulating load")
+	}
+	ca.PeriodNum++
+	dcrv, _ = def.DecompressDCRV(ca.GenerateUpdateEEA())
+	if dcrv.Count() == 0 || ca.CRV.Count() != 3 {
+		t.Errorf("Simulated DCRV %v, CRV %v", dcrv.Count(), ca.CRV)
+	}
+}
+
+func TestRevokeHandler(t *testing.T) {
+	ca := NewCA(def.CTngID("C1"), testconfig, "../def/testsettings.json")
+	router := NewCARouter(ca)
+	post := func(endpoint, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("POST", endpoint, strings.NewReader(body)))
+		return rec
+	}
+	for _, serial := range []string{"01", "02"} {
+		if rec := post("/ca/issue", `{"serial":"`+serial+`"}`); rec.Code != http.StatusOK {
+			t.Fatalf("Issue failed: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	if rec := post("/ca/revoke", `{}`); rec.Code != http.StatusBadRequest || ca.pending.Count() != 0 {
+		t.Errorf("Empty revocation request answered %d, pending %v", rec.Code, ca.pending)
+	}
+	if rec := post("/ca/revoke", `{"index":0}`); rec.Code != http.StatusOK || !ca.pending.Test(0) {
+		t.Errorf("Revocation of index 0 answered %d, pending %v", rec.Code, ca.pending)
+	}
+	if rec := post("/ca/revoke", `{"serial":"02"}`); rec.Code != http.StatusOK || !ca.pending.Test(1) {
+		t.Errorf("Revocation of serial 02 answered %d, pending %v", rec.Code, ca.pending)
+	}
+}
+
+func TestIssuers(t *testing.T) {
+	ca := NewCA(def.CTngID("C1"), testconfig, "../def/testsettings.json")
+	crypto := def.CTngKeyGen(1, 1, 1, 1)
+	config := func(id def.CTngID) *transport.HTTP {
+		tlsconfig, err := crypto.TLSConfig(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender := transport.NewHTTP(&http.Client{})
+		sender.EnableTLS(tlsconfig)
+		return sender
+	}
+	ca.Transport = config("C1")
+	server := httptest.NewUnstartedServer(NewCARouter(ca))
+	server.TLS = ca.Transport.(*transport.HTTP).TLS
+	server.StartTLS()
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "https://")
+
+	// a monitor holds a CTng certificate, but may not issue certificates
+	monitor := config("M1")
+	if err := monitor.Send(addr, "/ca/issue", []byte(`{"serial":"01"}`)); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("A monitor should be refused, got %v", err)
+	}
+	if err := monitor.Send(addr, "/ca/revoke", []byte(`{"index":0}`)); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("A monitor should be refused, got %v", err)
+	}
+	if err := config("C1").Send(addr, "/ca/issue", []byte(`{"serial":"01"}`)); err != nil {
+		t.Fatal(err)
+	}
+	ca.Settings.Issuers = map[def.CTngID][]def.CTngID{"C1": {"M1"}}
+	if err := monitor.Send(addr, "/ca/issue", []byte(`{"serial":"02"}`)); err != nil {
+		t.Fatalf("A listed issuer should be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package ca
+
+import (
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// corruptEncoding rewrites the shares of the monitors targeted by a bad encoding fault, before they are committed in Head_rs
+func (ca *CA) corruptEncoding(shares [][]byte) {
+	for _, fault := range def.GetFaults(*ca.Settings, ca.CTngID) {
+		if !fault.Active(ca.PeriodNum) || fault.Behavior != def.FAULT_BAD_ENCODING {
+			continue
+		}
+		for id := range ca.Updates_EEA {
+			if index := def.GetIndex(id); fault.Affects(id) && index < len(shares) {
+				shares[index] = def.CorruptShare(shares[index])
+			}
+		}
+	}
+}
+
+// injectFaults rewrites the updates of the monitors targeted by the faults configured for this CA.
+// A withheld update is removed from the map, Send_Update_EEA skips the monitors without one.
+func (ca *CA) injectFaults(updates map[def.CTngID]*def.Update_CA_EEA, hcrv []byte, dcrv []byte) {
+	for _, fault := range def.GetFaults(*ca.Settings, ca.CTngID) {
+		if !fault.Active(ca.PeriodNum) {
+			continue
+		}
+		// the PoIs of the genuine shares, before any of them is rewritten
+		pois := make(map[int]def.PoI, len(updates))
+		for id, update := range updates {
+			pois[def.GetIndex(id)] = update.PoI
+		}
+		var fork *def.SRH
+		for id, update := range updates {
+			if !fault.Affects(id) {
+				continue
+			}
+			switch fault.Behavior {
+			case def.FAULT_EQUIVOCATE:
+				if fork == nil {
+					// another CRV over the same shares, so the targets still accept them
+					fork = ca.GenerateSRHEEA(def.ForkHead(hcrv), dcrv, update.Head_rs)
+				}
+				update.SRH = *fork
+			case def.FAULT_WITHHOLD:
+				delete(updates, id)
+			case def.FAULT_CORRUPT_SHARE:
+				update.FileShare = def.CorruptShare(update.FileShare)
+			case def.FAULT_BAD_POI:
+				if poi, ok := pois[(def.GetIndex(id)+1)%ca.NumMonitors]; ok {
+					update.PoI = poi
+				}
+			}
+		}
+	}
+}
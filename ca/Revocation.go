@@ -0,0 +1,76 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+
+	bitset "github.com/bits-and-blooms/bitset"
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// Issue assigns the next CRV index to a newly issued certificate, identified by its serial number.
+func (ca *CA) Issue(serial string) (int, error) {
+	ca.revLock.Lock()
+	defer ca.revLock.Unlock()
+	if ca.Settings.Simulated_load {
+		return 0, errors.New("CA only simulates revocations")
+	}
+	if _, exists := ca.serials[serial]; exists {
+		return 0, fmt.Errorf("serial %s already issued", serial)
+	}
+	if ca.nextIndex >= ca.Settings.CRV_size {
+		return 0, errors.New("CRV is full")
+	}
+	index := ca.nextIndex
+	ca.serials[serial] = index
+	ca.nextIndex++
+	return index, nil
+}
+
+// Revoke queues the certificate with the given serial number for the next DCRV.
+// Returns its CRV index and the period whose SRH will cover the revocation.
+func (ca *CA) Revoke(serial string) (int, int, error) {
+	ca.revLock.Lock()
+	index, exists := ca.serials[serial]
+	ca.revLock.Unlock()
+	if !exists {
+		return 0, 0, fmt.Errorf("serial %s not issued by %s", serial, ca.CTngID)
+	}
+	period, err := ca.RevokeIndex(index)
+	return index, period, err
+}
+
+// RevokeIndex queues a CRV index for the next DCRV and returns the period whose SRH will cover it.
+func (ca *CA) RevokeIndex(index int) (int, error) {
+	ca.revLock.Lock()
+	defer ca.revLock.Unlock()
+	if index < 0 || index >= ca.nextIndex {
+		return 0, fmt.Errorf("index %d not issued by %s", index, ca.CTngID)
+	}
+	if ca.CRV.Test(uint(index)) || ca.pending.Test(uint(index)) {
+		return 0, fmt.Errorf("index %d already revoked", index)
+	}
+	ca.pending.Set(uint(index))
+	return ca.revPeriod, nil
+}
+
+// takeDCRV empties the pending revocations for the update of the current period,
+// folds them into the CRV and returns them with the hash of the new CRV.
+// With Simulated_load the CA issues nothing and revokes random indices of a CRV kept apart from its own.
+func (ca *CA) takeDCRV() (*bitset.BitSet, []byte) {
+	ca.revLock.Lock()
+	defer ca.revLock.Unlock()
+	if ca.Settings.Simulated_load {
+		if ca.loadCRV == nil {
+			ca.loadCRV = bitset.New(uint(ca.Settings.CRV_size))
+		}
+		dcrv := GenerateRandomDCRV(ca.Settings.CRV_size, ca.Settings.Revocation_ratio)
+		ca.loadCRV.InPlaceUnion(dcrv)
+		return dcrv, def.CRVHash(ca.loadCRV)
+	}
+	dcrv := ca.pending
+	ca.pending = bitset.New(uint(ca.Settings.CRV_size))
+	ca.revPeriod = ca.PeriodNum + 1
+	ca.CRV.InPlaceUnion(dcrv)
+	return dcrv, def.CRVHash(ca.CRV)
+}
@@ -0,0 +1,268 @@
+package client
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// Client is a relying party: it only holds the public half of the crypto config
+// and checks everything the monitors serve before handing it out.
+type Client struct {
+	Crypto   *def.GlobalCrypto
+	Settings *def.Settings
+	HTTP     *http.Client
+}
+
+func NewClient(cryptofile string, settingfile string) *Client {
+	restoredcrypto := new(def.StoredCrypto)
+	def.LoadData(&restoredcrypto, cryptofile)
+	crypto, err := def.DecodeCrypto(restoredcrypto)
+	if err != nil {
+		def.HandleError(err, "DecodeCrypto")
+	}
+	restoredsetting := new(def.Settings)
+	def.LoadData(&restoredsetting, settingfile)
+	client := &http.Client{}
+	if restoredsetting.TLS {
+		// relying parties have no certificate, they only check the monitors have one
+		config, err := crypto.TLSClientConfig()
+		if err != nil {
+			def.HandleError(err, "TLSClientConfig")
+		}
+		client.Transport = &http.Transport{TLSClientConfig: config}
+	}
+	return &Client{
+		Crypto:   def.PublicCrypto(crypto),
+		Settings: restoredsetting,
+		HTTP:     client,
+	}
+}
+
+// VerifySTH checks the Logger's signature on the STH and the threshold signature of the monitors over it.
+func (c *Client) VerifySTH(signed def.SignedSTH) error {
+	sth_fork := signed.STH
+	sth_fork.Signature = def.RSASig{}
+	sthBytes, err := json.Marshal(sth_fork)
+	if err != nil {
+		return err
+	}
+	if signed.STH.Signature.ID != def.CTngID(signed.STH.LID) {
+		return errors.New("STH not signed by its Logger")
+	}
+	if err := c.Crypto.Verify(sthBytes, signed.STH.Signature); err != nil {
+		return fmt.Errorf("Logger signature: %v", err)
+	}
+	return c.verifyThresholdSig(string(sthBytes), signed.Signature)
+}
+
+// VerifySRH checks the CA's signature on the SRH and the threshold signature of the monitors over it.
+func (c *Client) VerifySRH(signed def.SignedSRH) error {
+	srh_fork := signed.SRH
+	srh_fork.Signature = def.RSASig{}
+	srhBytes, err := json.Marshal(srh_fork)
+	if err != nil {
+		return err
+	}
+	if signed.SRH.Signature.ID != def.CTngID(signed.SRH.CAID) {
+		return errors.New("SRH not signed by its CA")
+	}
+	if err := c.Crypto.Verify(srhBytes, signed.SRH.Signature); err != nil {
+		return fmt.Errorf("CA signature: %v", err)
+	}
+	return c.verifyThresholdSig(string(srhBytes), signed.Signature)
+}
+
+func (c *Client) verifyThresholdSig(msg string, sigstring string) error {
+	sig, err := def.ThresholdSigFromString(sigstring)
+	if err != nil {
+		return err
+	}
+	if err := c.Crypto.QuorumVerify(msg, sig); err != nil {
+		return fmt.Errorf("monitor signature: %v", err)
+	}
+	return nil
+}
+
+// VerifyPoMs checks every PoM in the records served for an entity, one invalid proof fails the whole list.
+func (c *Client) VerifyPoMs(id def.CTngID, records []def.PoMRecord) error {
+	for _, record := range records {
+		if record.Entity_Convicted != id {
+			return fmt.Errorf("PoM against %s listed for %s", record.Entity_Convicted, id)
+		}
+		if record.APoM == nil && record.CPoM == nil && record.BPoM == nil {
+			return fmt.Errorf("empty PoM record for period %d", record.Period)
+		}
+		if record.APoM != nil {
+			if record.APoM.Entity_Convicted != id || record.APoM.Period != record.Period {
+				return fmt.Errorf("APoM does not match its record for period %d", record.Period)
+			}
+			if err := c.Crypto.VerifyAPoM(*record.APoM); err != nil {
+				return fmt.Errorf("APoM for period %d: %v", record.Period, err)
+			}
+		}
+		if record.CPoM != nil {
+			if record.CPoM.Entity_Convicted != id {
+				return fmt.Errorf("CPoM does not match its record for period %d", record.Period)
+			}
+			period, err := c.Crypto.VerifyCPoM(*record.CPoM)
+			if err != nil {
+				return fmt.Errorf("CPoM for period %d: %v", record.Period, err)
+			}
+			if period != record.Period {
+				return fmt.Errorf("CPoM does not match its record for period %d", record.Period)
+			}
+		}
+		if record.BPoM != nil {
+			if record.BPoM.Entity_Convicted != id {
+				return fmt.Errorf("BPoM does not match its record for period %d", record.Period)
+			}
+			period, err := c.Crypto.VerifyBPoM(*record.BPoM)
+			if err != nil {
+				return fmt.Errorf("BPoM for period %d: %v", record.Period, err)
+			}
+			if period != record.Period {
+				return fmt.Errorf("BPoM does not match its record for period %d", record.Period)
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyInclusion checks that the certificate is covered by a threshold-signed STH.
+// The proof may come from any monitor or the Logger, only the signed STH is trusted.
+func (c *Client) VerifyInclusion(cert []byte, proof def.InclusionProof, signed def.SignedSTH) error {
+	if !reflect.DeepEqual(proof.STH, signed.STH) {
+		return errors.New("proof is not against the signed STH")
+	}
+	if err := c.VerifySTH(signed); err != nil {
+		return err
+	}
+	return def.VerifyInclusion(cert, proof)
+}
+
+// protocol is https when the settings enable TLS, clients built without settings use http
+func (c *Client) protocol() string {
+	if c.Settings != nil {
+		return def.GetProtocol(*c.Settings)
+	}
+	return def.PROTOCOL
+}
+
+func (c *Client) get(url string, v interface{}) error {
+	resp, err := c.HTTP.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// FetchSTH queries a monitor (ip:port) and only returns a fully verified STH.
+func (c *Client) FetchSTH(monitor string, lid def.CTngID, period int) (def.SignedSTH, error) {
+	var signed def.SignedSTH
+	if err := c.get(fmt.Sprintf("%s%s/monitor/sth/%s/%d", c.protocol(), monitor, lid, period), &signed); err != nil {
+		return def.SignedSTH{}, err
+	}
+	if signed.STH.LID != lid.String() || signed.STH.PeriodNum != period {
+		return def.SignedSTH{}, errors.New("monitor returned a different STH")
+	}
+	if err := c.VerifySTH(signed); err != nil {
+		return def.SignedSTH{}, err
+	}
+	return signed, nil
+}
+
+// FetchSRH queries a monitor (ip:port) and only returns a fully verified SRH.
+func (c *Client) FetchSRH(monitor string, caid def.CTngID, period int) (def.SignedSRH, error) {
+	var signed def.SignedSRH
+	if err := c.get(fmt.Sprintf("%s%s/monitor/srh/%s/%d", c.protocol(), monitor, caid, period), &signed); err != nil {
+		return def.SignedSRH{}, err
+	}
+	if signed.SRH.CAID != caid.String() || signed.SRH.PeriodNum != period {
+		return def.SignedSRH{}, errors.New("monitor returned a different SRH")
+	}
+	if err := c.VerifySRH(signed); err != nil {
+		return def.SignedSRH{}, err
+	}
+	return signed, nil
+}
+
+// FetchPoMs queries a monitor (ip:port) for the PoMs against an entity and verifies all of them.
+func (c *Client) FetchPoMs(monitor string, id def.CTngID) ([]def.PoMRecord, error) {
+	// The CPoM metadata is decoded as an STH or SRH by def.DecodeCPoM
+	var raw []struct {
+		Entity_Convicted def.CTngID      `json:"entity_convicted"`
+		Period           int             `json:"period"`
+		APoM             *def.APoM       `json:"apom,omitempty"`
+		CPoM             json.RawMessage `json:"cpom,omitempty"`
+		BPoM             *def.BPoM       `json:"bpom,omitempty"`
+	}
+	if err := c.get(fmt.Sprintf("%s%s/monitor/pom/%s", c.protocol(), monitor, id), &raw); err != nil {
+		return nil, err
+	}
+	records := make([]def.PoMRecord, len(raw))
+	for i, r := range raw {
+		records[i] = def.PoMRecord{Entity_Convicted: r.Entity_Convicted, Period: r.Period, APoM: r.APoM, BPoM: r.BPoM}
+		if len(r.CPoM) > 0 {
+			cpom, err := def.DecodeCPoM(r.CPoM)
+			if err != nil {
+				return nil, err
+			}
+			records[i].CPoM = &cpom
+		}
+	}
+	if err := c.VerifyPoMs(id, records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// FetchInclusion queries a monitor (ip:port) for the PoI of a certificate and checks it against the signed STH of the period.
+func (c *Client) FetchInclusion(monitor string, lid def.CTngID, period int, cert []byte) (def.InclusionProof, error) {
+	signed, err := c.FetchSTH(monitor, lid, period)
+	if err != nil {
+		return def.InclusionProof{}, err
+	}
+	var proof def.InclusionProof
+	hash := hex.EncodeToString(def.CertificateHash(cert))
+	if err := c.get(fmt.Sprintf("%s%s/monitor/inclusion/%s/%d/%s", c.protocol(), monitor, lid, period, hash), &proof); err != nil {
+		return def.InclusionProof{}, err
+	}
+	if err := c.VerifyInclusion(cert, proof, signed); err != nil {
+		return def.InclusionProof{}, err
+	}
+	return proof, nil
+}
+
+// FetchRevocation queries a monitor (ip:port) for the revocation status of a serial index and verifies the SRH backing it.
+func (c *Client) FetchRevocation(monitor string, caid def.CTngID, period int, index int) (def.RevocationStatus, error) {
+	var status def.RevocationStatus
+	if err := c.get(fmt.Sprintf("%s%s/monitor/revocation/%s/%d/%d", c.protocol(), monitor, caid, period, index), &status); err != nil {
+		return def.RevocationStatus{}, err
+	}
+	if status.SRH.CAID != caid.String() || status.SRH.PeriodNum != period || status.Index != index {
+		return def.RevocationStatus{}, errors.New("monitor returned a different revocation status")
+	}
+	if err := c.VerifySRH(def.SignedSRH{SRH: status.SRH, Signature: status.Signature}); err != nil {
+		return def.RevocationStatus{}, err
+	}
+	// the bit is read from the CRV signed in the SRH, not taken from the monitor
+	crv, err := def.DecompressDCRV(status.CRV)
+	if err != nil {
+		return def.RevocationStatus{}, err
+	}
+	if !status.SRH.CommitsToCRV(crv) {
+		return def.RevocationStatus{}, errors.New("CRV does not match the SRH")
+	}
+	status.Revoked = crv.Test(uint(index))
+	return status, nil
+}
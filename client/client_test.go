@@ -0,0 +1,180 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bits-and-blooms/bitset"
+	def "github.com/jik18001/CTngV3/def"
+)
+
+func signedSTH(t *testing.T, crypto *def.GlobalCrypto, head string, monitors []def.CTngID) def.SignedSTH {
+	sth := def.STH{LID: "L1", PeriodNum: 2, Size: 10, Head: []byte(head)}
+	sthBytes, _ := json.Marshal(sth)
+	sth.Signature, _ = crypto.Sign(sthBytes, def.CTngID("L1"))
+	var siglist []def.SigFragment
+	for _, id := range monitors {
+		sigfrag, err := crypto.ThresholdSign(string(sthBytes), id)
+		if err != nil {
+			t.Fatalf("ThresholdSign failed: %v", err)
+		}
+		siglist = append(siglist, sigfrag)
+	}
+	sig, _ := def.ThresholdAggregate(siglist, len(siglist))
+	sigstring, _ := sig.String()
+	return def.SignedSTH{STH: sth, Signature: sigstring}
+}
+
+func TestVerifySTH(t *testing.T) {
+	crypto := def.CTngKeyGen(2, 2, 4, 3)
+	c := &Client{Crypto: def.PublicCrypto(crypto), HTTP: &http.Client{}}
+	if len(c.Crypto.DSS_private_map) != 0 || len(c.Crypto.TSS_private_map) != 0 {
+		t.Fatal("Client holds private keys")
+	}
+
+	signed := signedSTH(t, crypto, "head", []def.CTngID{"M1", "M2", "M4"})
+	if err := c.VerifySTH(signed); err != nil {
+		t.Errorf("Verification failed: %v", err)
+	}
+
+	// fewer than Threshold monitors
+	if err := c.VerifySTH(signedSTH(t, crypto, "head", []def.CTngID{"M1", "M2"})); err == nil {
+		t.Errorf("STH accepted with too few monitor signatures")
+	}
+
+	// the same monitor counted twice
+	if err := c.VerifySTH(signedSTH(t, crypto, "head", []def.CTngID{"M1", "M2", "M2"})); err == nil {
+		t.Errorf("STH accepted with duplicated monitor signatures")
+	}
+
+	// a modified STH breaks the Logger signature
+	tampered := signed
+	tampered.STH.Size = 11
+	if err := c.VerifySTH(tampered); err == nil {
+		t.Errorf("Tampered STH accepted")
+	}
+}
+
+func TestVerifySRH(t *testing.T) {
+	crypto := def.CTngKeyGen(2, 2, 4, 3)
+	c := &Client{Crypto: def.PublicCrypto(crypto), HTTP: &http.Client{}}
+	srh := def.SRH{CAID: "C2", PeriodNum: 1, Head: []byte("head")}
+	srhBytes, _ := json.Marshal(srh)
+	srh.Signature, _ = crypto.Sign(srhBytes, def.CTngID("C2"))
+	var siglist []def.SigFragment
+	for _, id := range []def.CTngID{"M2", "M3", "M4"} {
+		sigfrag, _ := crypto.ThresholdSign(string(srhBytes), id)
+		siglist = append(siglist, sigfrag)
+	}
+	sig, _ := crypto.ThresholdAggregate(siglist)
+	sigstring, _ := sig.String()
+	if err := c.VerifySRH(def.SignedSRH{SRH: srh, Signature: sigstring}); err != nil {
+		t.Errorf("Verification failed: %v", err)
+	}
+
+	// an SRH signed by another CA
+	srh.Signature, _ = crypto.Sign(srhBytes, def.CTngID("C1"))
+	if err := c.VerifySRH(def.SignedSRH{SRH: srh, Signature: sigstring}); err == nil {
+		t.Errorf("SRH signed by the wrong CA accepted")
+	}
+}
+
+func TestFetchRevocation(t *testing.T) {
+	crypto := def.CTngKeyGen(2, 2, 4, 3)
+	c := &Client{Crypto: def.PublicCrypto(crypto), HTTP: &http.Client{}}
+	crv := bitset.New(64)
+	crv.Set(5)
+	// the head of an SRH starts with the hcrv
+	srh := def.SRH{CAID: "C1", PeriodNum: 2, Head: append(def.CRVHash(crv), []byte("hdcrv and Head_rs")...)}
+	srhBytes, _ := json.Marshal(srh)
+	srh.Signature, _ = crypto.Sign(srhBytes, def.CTngID("C1"))
+	var siglist []def.SigFragment
+	for _, id := range []def.CTngID{"M1", "M2", "M3"} {
+		sigfrag, _ := crypto.ThresholdSign(string(srhBytes), id)
+		siglist = append(siglist, sigfrag)
+	}
+	sig, _ := crypto.ThresholdAggregate(siglist)
+	sigstring, _ := sig.String()
+	// a monitor claiming serial 5 is not revoked
+	served := crv
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, _ := def.CompressDCRV(served)
+		json.NewEncoder(w).Encode(def.RevocationStatus{SRH: srh, Signature: sigstring, Index: 5, Revoked: false, CRV: compressed})
+	}))
+	defer server.Close()
+	monitor := strings.TrimPrefix(server.URL, def.PROTOCOL)
+
+	status, err := c.FetchRevocation(monitor, "C1", 2, 5)
+	if err != nil {
+		t.Fatalf("FetchRevocation failed: %v", err)
+	}
+	if !status.Revoked {
+		t.Errorf("Revoked serial reported valid")
+	}
+	// nor can it serve another CRV
+	served = bitset.New(64)
+	if _, err := c.FetchRevocation(monitor, "C1", 2, 5); err == nil {
+		t.Errorf("CRV not signed in the SRH accepted")
+	}
+}
+
+func TestFetchPoMs(t *testing.T) {
+	crypto := def.CTngKeyGen(2, 2, 4, 3)
+	c := &Client{Crypto: def.PublicCrypto(crypto), HTTP: &http.Client{}}
+	sth1 := signedSTH(t, crypto, "head1", nil).STH
+	sth2 := signedSTH(t, crypto, "head2", nil).STH
+	records := []def.PoMRecord{{
+		Entity_Convicted: "L1",
+		Period:           2,
+		CPoM:             &def.CPoM{Entity_Convicted: "L1", MetaData1: sth1, MetaData2: sth2},
+	}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(records)
+	}))
+	defer server.Close()
+	monitor := strings.TrimPrefix(server.URL, def.PROTOCOL)
+
+	fetched, err := c.FetchPoMs(monitor, def.CTngID("L1"))
+	if err != nil {
+		t.Fatalf("FetchPoMs failed: %v", err)
+	}
+	if len(fetched) != 1 || fetched[0].CPoM == nil {
+		t.Fatalf("Unexpected PoM records: %+v", fetched)
+	}
+
+	// a PoM that does not match its record period
+	records[0].Period = 3
+	if _, err := c.FetchPoMs(monitor, def.CTngID("L1")); err == nil {
+		t.Errorf("PoM with a mismatched period accepted")
+	}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	crypto := def.CTngKeyGen(2, 2, 4, 3)
+	c := &Client{Crypto: def.PublicCrypto(crypto), HTTP: &http.Client{}}
+	certs := [][]byte{[]byte("cert0"), []byte("cert1"), []byte("cert2")}
+	hashes := def.CertificateHashes(certs)
+	tree, _ := def.CertificateHashTree(hashes)
+	head := def.GenerateRootHash(tree)
+	signed := signedSTH(t, crypto, string(head), []def.CTngID{"M1", "M2", "M3"})
+	proof, err := def.BuildInclusionProof(signed.STH, nil, head, tree, 1, hashes[1])
+	if err != nil {
+		t.Fatalf("BuildInclusionProof failed: %v", err)
+	}
+	if err := c.VerifyInclusion(certs[1], proof, signed); err != nil {
+		t.Errorf("Verification failed: %v", err)
+	}
+	if err := c.VerifyInclusion(certs[2], proof, signed); err == nil {
+		t.Errorf("Proof accepted for another certificate")
+	}
+
+	// a proof against an STH the monitors did not sign
+	other := signedSTH(t, crypto, string(head), []def.CTngID{"M1", "M2"})
+	proof.STH = other.STH
+	if err := c.VerifyInclusion(certs[1], proof, other); err == nil {
+		t.Errorf("Proof accepted against an STH without a quorum")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	ca "github.com/jik18001/CTngV3/ca"
@@ -12,14 +13,16 @@ import (
 )
 
 func main() {
-	if len(os.Args) < 4 && os.Args[1] != "Script" {
+	if len(os.Args) < 2 || (len(os.Args) < 4 && os.Args[1] != "Script" && os.Args[1] != "Keygen") {
 		fmt.Println("Usage: go run ctng.go <CA|Logger|Monitor|Script> <CTngID> <local|deter>")
+		fmt.Println("       go run ctng.go Keygen <local|deter>")
 		os.Exit(1)
 	}
 
 	var cryptofile = "def/testconfig.json"
 	var settingfile = "def/testsettings.json"
-	if os.Args[3] == "deter" {
+	// the mode is always the last argument
+	if os.Args[len(os.Args)-1] == "deter" {
 		cryptofile = "deter/deterconfig.json"
 		settingfile = "deter/detersettings.json"
 	}
@@ -29,16 +32,31 @@ func main() {
 
 	// Load the configuration from the file.
 	def.LoadData(restoredsetting, settingfile)
+	if err := def.ValidateFaults(*restoredsetting); err != nil {
+		fmt.Println("Invalid faults:", err)
+		os.Exit(1)
+	}
+	if err := def.ValidateSchemes(*restoredsetting); err != nil {
+		fmt.Println("Invalid signature schemes:", err)
+		os.Exit(1)
+	}
+	if err := def.ValidateErasure(*restoredsetting); err != nil {
+		fmt.Println("Invalid erasure coding parameters:", err)
+		os.Exit(1)
+	}
 
 	// Extract configuration values
 	numFSMCAEEAs := restoredsetting.Num_CAs
 	numFSMLoggerEEAs := restoredsetting.Num_Loggers
 	numMonitors := restoredsetting.Num_Monitors
 	MUD := restoredsetting.MUD
-	time.AfterFunc(time.Duration(MUD)*time.Second, func() {
-		fmt.Println("Terminating the program after MUD.")
-		os.Exit(0)
-	})
+	// Entities run one update per MUD; stop once the configured number of periods is over.
+	if restoredsetting.Num_Periods > 0 {
+		time.AfterFunc(time.Duration(MUD*restoredsetting.Num_Periods)*time.Second, func() {
+			fmt.Println("Terminating the program after", restoredsetting.Num_Periods, "periods.")
+			os.Exit(0)
+		})
+	}
 
 	fmt.Printf("Configuration Loaded: %d CA(s), %d Logger(s), %d Monitor(s)\n", numFSMCAEEAs, numFSMLoggerEEAs, numMonitors)
 
@@ -57,6 +75,14 @@ func main() {
 		CTngID := def.CTngID(os.Args[2])
 		fmt.Println(CTngID)
 		monitor.StartMonitor(CTngID, cryptofile, settingfile)
+	case "Keygen":
+		// Every entity then loads the public config and its own key file only
+		err := def.GenerateKeyFiles(*restoredsetting, cryptofile)
+		if err != nil {
+			fmt.Printf("Failed to write the key files: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Public config written to", cryptofile, "and key files to", filepath.Dir(def.KeyFile(cryptofile, "")))
 	case "Script":
 		err := generateScript(numFSMCAEEAs, numFSMLoggerEEAs, numMonitors)
 		if err != nil {
@@ -78,9 +104,9 @@ func generateScript(numFSMCAEEAs, numFSMLoggerEEAs, numMonitors int) error {
 	defer file.Close()
 
 	fmt.Fprintln(file, "#!/bin/bash")
-	fmt.Fprintln(file, "SESSION=\"network\"\n")
+	fmt.Fprint(file, "SESSION=\"network\"\n\n")
 	fmt.Fprintln(file, "# Start a new tmux session")
-	fmt.Fprintln(file, "tmux new-session -d -s $SESSION\n")
+	fmt.Fprint(file, "tmux new-session -d -s $SESSION\n\n")
 
 	// Generate monitor windows with race condition detection and redirection to log files
 	for i := 1; i <= numMonitors; i++ {
@@ -88,7 +114,7 @@ func generateScript(numFSMCAEEAs, numFSMLoggerEEAs, numMonitors int) error {
 	}
 
 	// Add a 1-second delay after starting all the monitors
-	fmt.Fprintln(file, "sleep 1\n")
+	fmt.Fprint(file, "sleep 1\n\n")
 
 	// Generate CA windows with race condition detection and redirection to log files
 	for i := 1; i <= numFSMCAEEAs; i++ {
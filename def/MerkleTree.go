@@ -43,14 +43,27 @@ func GenerateRootHash(tree *merkletree.MerkleTree) []byte {
 }
 
 func GeneratePOI(tree *merkletree.MerkleTree, blocks []merkletree.DataBlock, index int) (PoI, error) {
-	// create a Merkle Tree config and set mode to tree building
-	/*
-		config := &merkletree.Config{
-			Mode: merkletree.ModeTreeBuild,
-		}*/
-	tree, err := GenerateMerkleTree(blocks)
-	proof, err := tree.Proof(blocks[index])
-	return PoI{proof}, err
+	// Proofs are generated by position: tree.Proof looks blocks up by content and proves the last of identical blocks,
+	// while bad encoding proofs need the position of every share.
+	config := &merkletree.Config{
+		HashFunc:      merkletree.DefaultHashFuncParallel,
+		Mode:          merkletree.ModeProofGen,
+		RunInParallel: true,
+	}
+	proofs, err := merkletree.New(config, blocks)
+	if err != nil {
+		return PoI{}, err
+	}
+	return PoI{proofs.Proofs[index]}, nil
+}
+
+// PoIIndex returns the leaf position a PoI proves, the path has a bit set for every left child on the way up
+func PoIIndex(poi PoI) int {
+	if poi.Proof == nil {
+		return -1
+	}
+	mask := uint32(1)<<len(poi.Proof.Siblings) - 1
+	return int(^poi.Proof.Path & mask)
 }
 
 func VerifyPOI(sth STH, poi PoI, data []byte) (bool, error) {
@@ -64,10 +64,11 @@ func GenerateThresholdKeypairs(entities []CTngID, threshold int) ([]bls.ID, BlsP
 	sec := new(bls.SecretKey)                       // Secret key instance
 	mpk := make([]bls.PublicKey, threshold)         // Master public key array
 
-	// Step 1: Generate "threshold" number of master secret keys
+	// Step 1: Generate "threshold" number of master secret keys, the first one is the random master secret
+	sec.SetByCSPRNG()
 	mainSecrets = sec.GetMasterSecretKey(threshold)
 
-	// Step 2: Generate master public keys from the master secrets
+	// Step 2: Generate master public keys from the master secrets, mpk[0] verifies the threshold signatures
 	mpk = bls.GetMasterPublicKey(mainSecrets)
 
 	// Step 3: Generate secret shares for all participants using Shamir's Secret Sharing
@@ -99,40 +100,63 @@ func ThresholdSign(msg string, sec *bls.SecretKey, SelfID CTngID) SigFragment {
 	}
 }
 
-// Aggregate signature Fragments into a ThresholdSig.
+// Recover the group signature from signature Fragments by Lagrange interpolation.
+// The result is the signature of the master secret, whichever Fragments were used.
 func ThresholdAggregate(sigs []SigFragment, threshold int) (ThresholdSig, error) {
 	var aggregate = ThresholdSig{
-		IDs:  make([]CTngID, len(sigs)),
 		Sign: new(bls.Sign),
 	}
-	if len(sigs) < threshold {
+	// A signer counted twice would break the interpolation, only its first Fragment is kept.
+	seen := make(map[CTngID]bool, len(sigs))
+	realSigs := make([]bls.Sign, 0, len(sigs))
+	ids := make([]bls.ID, 0, len(sigs))
+	for i := range sigs {
+		if seen[sigs[i].ID] {
+			continue
+		}
+		seen[sigs[i].ID] = true
+		aggregate.IDs = append(aggregate.IDs, sigs[i].ID)
+		realSigs = append(realSigs, *sigs[i].Sign)
+		ids = append(ids, *sigs[i].ID.BlsID())
+	}
+	if len(realSigs) < threshold {
 		return aggregate, errors.New("Not enough signatures to aggregate")
 	}
-	// create list of []bls.Sign for aggregate.
-	realSigs := make([]bls.Sign, len(sigs))
-	for i := range sigs {
-		aggregate.IDs[i] = sigs[i].ID
-		realSigs[i] = *sigs[i].Sign
+	if err := aggregate.Sign.Recover(realSigs, ids); err != nil {
+		return aggregate, err
 	}
 	// Sort the ordering of IDs for consistency.
 	sort.Sort(CTngIDs(aggregate.IDs))
-	aggregate.Sign.Aggregate(realSigs)
 	return aggregate, nil
 }
 
-// Verify an aggregated threshold signature against the message and the public keys
-func (sig ThresholdSig) Verify(msg string, pubs *BlsPublicMap) bool {
-	// Construct the list of public keys
-	pubList := make([]bls.PublicKey, len(sig.IDs))
-	for i := range sig.IDs {
-		pubList[i] = (*pubs)[sig.IDs[i]]
-	}
-	//agregates the public signatures of the signers of the message and then verifies the message against that aggregated signature.
-	return sig.Sign.FastAggregateVerify(pubList, []byte(msg))
+// Verify a threshold signature against the message and the master public key.
+// The IDs only record the signers, a single key verifies the signature.
+func (sig ThresholdSig) Verify(msg string, master *bls.PublicKey) bool {
+	return sig.Sign.Verify(master, msg)
 }
 
-func (sig ThresholdSig) MasterVerify(msg string, pubs []bls.PublicKey) bool {
-	return sig.Sign.FastAggregateVerify(pubs, []byte(msg))
+// MasterPublicKey interpolates the master public key from the public keys of at least threshold signers.
+func MasterPublicKey(pubs BlsPublicMap, threshold int) (*bls.PublicKey, error) {
+	if len(pubs) < threshold {
+		return nil, errors.New("Not enough public keys to recover the master public key")
+	}
+	ids := make([]CTngID, 0, len(pubs))
+	for id := range pubs {
+		ids = append(ids, id)
+	}
+	sort.Sort(CTngIDs(ids))
+	pubList := make([]bls.PublicKey, threshold)
+	idList := make([]bls.ID, threshold)
+	for i := 0; i < threshold; i++ {
+		pubList[i] = pubs[ids[i]]
+		idList[i] = *ids[i].BlsID()
+	}
+	master := new(bls.PublicKey)
+	if err := master.Recover(pubList, idList); err != nil {
+		return nil, err
+	}
+	return master, nil
 }
 
 // Given a message and a public key mapping, verify the signature runs.
@@ -225,7 +249,7 @@ func SigFragmentFromString(str string) (SigFragment, error) {
 }
 
 type ThresholdSig struct {
-	IDs  []CTngID // Users must know the list of IDs that created the theshold signature to verify.
+	IDs  []CTngID // Signers whose Fragments were interpolated, not needed to verify.
 	Sign *bls.Sign
 }
 
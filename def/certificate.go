@@ -0,0 +1,186 @@
+package def
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	merkletree "github.com/txaty/go-merkletree"
+)
+
+// The certificate file of a period is the concatenation of the DER certificates,
+// each prefixed with its length as a 4 byte big endian integer.
+// The STH signs the length of the file, everything after it is padding added for the erasure code.
+
+const certLenSize = 4
+
+func EncodeCertificateFile(certs [][]byte) []byte {
+	size := 0
+	for _, cert := range certs {
+		size += certLenSize + len(cert)
+	}
+	file := make([]byte, 0, size)
+	for _, cert := range certs {
+		file = binary.BigEndian.AppendUint32(file, uint32(len(cert)))
+		file = append(file, cert...)
+	}
+	return file
+}
+
+// DecodeCertificateFile parses a whole certificate file, the padding has to be stripped with STH.FileOf first.
+// The empty file of a period without submissions holds no certificate.
+func DecodeCertificateFile(file []byte) ([][]byte, error) {
+	var certs [][]byte
+	for len(file) > 0 {
+		if len(file) < certLenSize {
+			return nil, errors.New("truncated certificate length")
+		}
+		certLen := int(binary.BigEndian.Uint32(file[:certLenSize]))
+		file = file[certLenSize:]
+		if certLen > len(file) {
+			return nil, errors.New("truncated certificate file")
+		}
+		certs = append(certs, file[:certLen])
+		file = file[certLen:]
+	}
+	return certs, nil
+}
+
+// FileOf strips the padding of the shares holding the certificate file, with the lengths signed in the STH
+func (sth STH) FileOf(shares [][]byte) ([]byte, error) {
+	var data []byte
+	for _, share := range shares {
+		if len(share) != sth.ShardSize {
+			return nil, fmt.Errorf("share of %d bytes, the STH signs %d", len(share), sth.ShardSize)
+		}
+		data = append(data, share...)
+	}
+	if sth.FileLen < 0 || sth.FileLen > len(data) {
+		return nil, fmt.Errorf("the STH signs a certificate file of %d bytes, %d decoded", sth.FileLen, len(data))
+	}
+	return data[:sth.FileLen], nil
+}
+
+// CertificateBlocks returns the leaves of the certificate Merkle tree, one leaf per certificate.
+// The tree needs at least two leaves, so missing ones are filled with empty leaves.
+func CertificateBlocks(certs [][]byte) []merkletree.DataBlock {
+	var blocks []merkletree.DataBlock
+	for _, cert := range certs {
+		blocks = append(blocks, &LeafBlock{Content: cert})
+	}
+	for len(blocks) < 2 {
+		blocks = append(blocks, &LeafBlock{Content: []byte{}})
+	}
+	return blocks
+}
+
+// CertificateHash is the leaf of a certificate in the certificate Merkle tree
+func CertificateHash(cert []byte) []byte {
+	hash, _ := GenerateSHA256(cert)
+	return hash
+}
+
+func CertificateHashes(certs [][]byte) [][]byte {
+	hashes := make([][]byte, len(certs))
+	for i, cert := range certs {
+		hashes[i] = CertificateHash(cert)
+	}
+	return hashes
+}
+
+// CertificateHashTree rebuilds the certificate Merkle tree from the leaf hashes alone.
+// Its root equals the root over CertificateBlocks, so 32 bytes per certificate are enough to serve proofs.
+func CertificateHashTree(hashes [][]byte) (*merkletree.MerkleTree, error) {
+	var blocks []merkletree.DataBlock
+	for _, hash := range hashes {
+		blocks = append(blocks, &LeafBlock{Content: hash})
+	}
+	for len(blocks) < 2 {
+		blocks = append(blocks, &LeafBlock{Content: CertificateHash([]byte{})})
+	}
+	config := &merkletree.Config{
+		HashFunc:           merkletree.DefaultHashFuncParallel,
+		Mode:               merkletree.ModeTreeBuild,
+		RunInParallel:      true,
+		DisableLeafHashing: true,
+	}
+	return merkletree.New(config, blocks)
+}
+
+// CertificateProof returns the PoI of the certificate with the given hash against the root of a CertificateHashTree
+func CertificateProof(tree *merkletree.MerkleTree, hash []byte) (PoI, error) {
+	proof, err := tree.Proof(&LeafBlock{Content: hash})
+	return PoI{proof}, err
+}
+
+// CombinedRootProof returns the PoI of Head_cert in the tree over [Head_rs, Head_cert] the Logger signs in EEA mode
+func CombinedRootProof(head_rs []byte, head_cert []byte) (PoI, error) {
+	rootblocks := []merkletree.DataBlock{&LeafBlock{Content: head_rs}, &LeafBlock{Content: head_cert}}
+	tree, err := GenerateMerkleTree(rootblocks)
+	if err != nil {
+		return PoI{}, err
+	}
+	proof, err := tree.Proof(rootblocks[1])
+	return PoI{proof}, err
+}
+
+// CertificateIndex returns the leaf index of the certificate with the given hash, -1 if it is not logged
+func CertificateIndex(hashes [][]byte, hash []byte) int {
+	for i, h := range hashes {
+		if bytes.Equal(h, hash) {
+			return i
+		}
+	}
+	return -1
+}
+
+// BuildInclusionProof assembles the proof for the leaf at index, tree being the CertificateHashTree of the period.
+// head_rs is nil in default mode, where the STH signs Head_cert directly.
+func BuildInclusionProof(sth STH, head_rs []byte, head_cert []byte, tree *merkletree.MerkleTree, index int, hash []byte) (InclusionProof, error) {
+	certPoI, err := CertificateProof(tree, hash)
+	if err != nil {
+		return InclusionProof{}, err
+	}
+	proof := InclusionProof{
+		STH:       sth,
+		Index:     index,
+		Head_cert: head_cert,
+		Head_rs:   head_rs,
+		CertPoI:   certPoI,
+	}
+	if len(head_rs) > 0 {
+		proof.RootPoI, err = CombinedRootProof(head_rs, head_cert)
+		if err != nil {
+			return InclusionProof{}, err
+		}
+	}
+	return proof, nil
+}
+
+// VerifyInclusion checks that the certificate is covered by proof.STH.Head.
+// The STH itself still has to be checked against its signatures.
+func VerifyInclusion(cert []byte, proof InclusionProof) error {
+	if proof.CertPoI.Proof == nil {
+		return errors.New("missing certificate proof")
+	}
+	ok, err := VerifyPOI2(proof.Head_cert, proof.CertPoI.Proof, cert)
+	if err != nil || !ok {
+		return errors.New("certificate not included under Head_cert")
+	}
+	// Default mode signs Head_cert directly
+	if len(proof.Head_rs) == 0 {
+		if !bytes.Equal(proof.Head_cert, proof.STH.Head) {
+			return errors.New("Head_cert does not match the STH")
+		}
+		return nil
+	}
+	if proof.RootPoI.Proof == nil {
+		return errors.New("missing root proof")
+	}
+	ok, err = VerifyPOI2(proof.STH.Head, proof.RootPoI.Proof, proof.Head_cert)
+	if err != nil || !ok {
+		return errors.New("Head_cert not included under the STH")
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package def
+
+import "time"
+
+// Clock is the time source of every entity.
+// The processes use the wall clock, the simulator replaces it with a virtual one.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the part of *time.Timer the entities rely on
+type Timer interface {
+	Stop() bool
+}
+
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
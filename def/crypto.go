@@ -1,7 +1,9 @@
 package def
 
 import (
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -16,12 +18,24 @@ type GlobalCrypto struct {
 	DSS_private_map RSAPrivateMap
 	TSS_public_map  BlsPublicMap
 	TSS_private_map BlsPrivateMap
+	TSS_master_key  *bls.PublicKey // Master public key, the only key needed to verify a threshold signature
 	DSS_Scheme      string
 	TSS_Scheme      string
 	HashScheme      HashAlgorithm
+	TLS_CA          []byte                    // PEM encoded CTng root, issuer of every TLS certificate
+	TLS_cert_map    map[CTngID]TLSCertificate // Certificate and key of every entity
+	// Loggers and CAs signing with another scheme than DSS_Scheme, and their keys
+	DSS_scheme_map     map[CTngID]string
+	Signer_public_map  SignerPublicMap
+	Signer_private_map SignerPrivateMap
 }
 
 func CTngKeyGen(Lnum int, Cnum int, Mnum int, Threshold int) *GlobalCrypto {
+	return CTngKeyGenSchemes(Lnum, Cnum, Mnum, Threshold, nil)
+}
+
+// CTngKeyGenSchemes generates the keys of every entity, the Loggers and CAs listed in schemes sign with the given scheme instead of RSA.
+func CTngKeyGenSchemes(Lnum int, Cnum int, Mnum int, Threshold int, schemes map[CTngID]string) *GlobalCrypto {
 	Loggers := make([]CTngID, Lnum)
 	for i := 0; i < Lnum; i++ {
 		Loggers[i] = CTngID(fmt.Sprintf("L%d", i+1))
@@ -41,9 +55,37 @@ func CTngKeyGen(Lnum int, Cnum int, Mnum int, Threshold int) *GlobalCrypto {
 	RSAPrivateMap := make(RSAPrivateMap)
 	BLSPublicMap := make(BlsPublicMap)
 	BlsPrivateMap := make(BlsPrivateMap)
+	SchemeMap := make(map[CTngID]string)
+	SignerPublicMap := make(SignerPublicMap)
+	SignerPrivateMap := make(SignerPrivateMap)
+
+	// Loggers and CAs with another scheme get their key from its Signer, RSA keys stay in the RSA maps
+	otherScheme := func(id CTngID) bool {
+		scheme, ok := schemes[id]
+		if !ok || scheme == DSS_RSA {
+			return false
+		}
+		signer, ok := Signers[scheme]
+		if !ok {
+			HandleError(fmt.Errorf("Unknown signature scheme %q", scheme), "CTngKeyGen")
+			return false
+		}
+		privateKey, err := signer.GenerateKey()
+		if err != nil {
+			HandleError(err, "CTngKeyGen")
+			return false
+		}
+		SchemeMap[id] = scheme
+		SignerPrivateMap[id] = privateKey
+		SignerPublicMap[id] = privateKey.Public()
+		return true
+	}
 
 	// Populate the RSA maps with generated keys for CAs
 	for _, ca := range CAs {
+		if otherScheme(ca) {
+			continue
+		}
 		privateKey, err := NewRSAPrivateKey()
 		if err != nil {
 			HandleError(err, "CTngKeyGen")
@@ -56,6 +98,9 @@ func CTngKeyGen(Lnum int, Cnum int, Mnum int, Threshold int) *GlobalCrypto {
 
 	// Populate the RSA maps with generated keys for Loggers
 	for _, logger := range Loggers {
+		if otherScheme(logger) {
+			continue
+		}
 		privateKey, err := NewRSAPrivateKey()
 		if err != nil {
 			HandleError(err, "CTngKeyGen")
@@ -65,35 +110,71 @@ func CTngKeyGen(Lnum int, Cnum int, Mnum int, Threshold int) *GlobalCrypto {
 		RSAPrivateMap[logger] = *privateKey
 		RSAPublicMap[logger] = *publicKey
 	}
+	// Populate the RSA maps with the identity keys of the Monitors, they sign the gossip envelopes
+	for _, monitor := range Monitors {
+		privateKey, err := NewRSAPrivateKey()
+		if err != nil {
+			HandleError(err, "CTngKeyGen")
+			continue
+		}
+		publicKey, _ := GetPublicKey(privateKey)
+		RSAPrivateMap[monitor] = *privateKey
+		RSAPublicMap[monitor] = *publicKey
+	}
 	// Threshold KeyGen for the Monitors
-	_, BLSPublicMap, BlsPrivateMap, _, _ = GenerateThresholdKeypairs(Monitors, Threshold)
+	_, BLSPublicMap, BlsPrivateMap, mpk, err := GenerateThresholdKeypairs(Monitors, Threshold)
+	if err != nil {
+		HandleError(err, "CTngKeyGen")
+	}
+	var masterKey *bls.PublicKey
+	if len(mpk) > 0 {
+		masterKey = &mpk[0]
+	}
+
+	// TLS certificates of every entity, used when the settings enable TLS
+	TLS_CA, TLS_cert_map, err := GenerateTLSCertificates(append(append(append([]CTngID{}, Loggers...), CAs...), Monitors...))
+	if err != nil {
+		HandleError(err, "CTngKeyGen")
+	}
 
 	Total := Mnum
 
 	cryptofile := GlobalCrypto{
-		Total:           Total,
-		Threshold:       Threshold,
-		DSS_public_map:  RSAPublicMap,
-		DSS_private_map: RSAPrivateMap,
-		TSS_public_map:  BLSPublicMap,
-		TSS_private_map: BlsPrivateMap,
-		DSS_Scheme:      "rsa",
-		TSS_Scheme:      "bls",
-		HashScheme:      SHA256,
+		Total:              Total,
+		Threshold:          Threshold,
+		DSS_public_map:     RSAPublicMap,
+		DSS_private_map:    RSAPrivateMap,
+		TSS_public_map:     BLSPublicMap,
+		TSS_private_map:    BlsPrivateMap,
+		TSS_master_key:     masterKey,
+		DSS_Scheme:         "rsa",
+		TSS_Scheme:         "bls",
+		HashScheme:         SHA256,
+		TLS_CA:             TLS_CA,
+		TLS_cert_map:       TLS_cert_map,
+		DSS_scheme_map:     SchemeMap,
+		Signer_public_map:  SignerPublicMap,
+		Signer_private_map: SignerPrivateMap,
 	}
 	return &cryptofile
 }
 
 type StoredCrypto struct {
-	Total           int
-	Threshold       int
-	DSS_public_map  RSAPublicMap
-	DSS_private_map RSAPrivateMap
-	TSS_public_map  map[string][]byte
-	TSS_private_map map[string][]byte
-	DSS_Scheme      string
-	TSS_Scheme      string
-	HashScheme      int
+	Total              int
+	Threshold          int
+	DSS_public_map     RSAPublicMap
+	DSS_private_map    RSAPrivateMap
+	TSS_public_map     map[string][]byte
+	TSS_private_map    map[string][]byte
+	TSS_master_key     []byte
+	DSS_Scheme         string
+	TSS_Scheme         string
+	HashScheme         int
+	TLS_CA             []byte
+	TLS_cert_map       map[CTngID]TLSCertificate
+	DSS_scheme_map     map[CTngID]string `json:",omitempty"`
+	Signer_public_map  map[CTngID][]byte `json:",omitempty"` // PKIX encoded
+	Signer_private_map map[CTngID][]byte `json:",omitempty"` // PKCS #8 encoded
 }
 
 func EncodeCrypto(c *GlobalCrypto) *StoredCrypto {
@@ -106,9 +187,18 @@ func EncodeCrypto(c *GlobalCrypto) *StoredCrypto {
 		DSS_Scheme:      c.DSS_Scheme,
 		TSS_Scheme:      c.TSS_Scheme,
 		HashScheme:      int(c.HashScheme),
+		TLS_CA:          c.TLS_CA,
+		TLS_cert_map:    c.TLS_cert_map,
 	}
 	stored.TSS_public_map = (&c.TSS_public_map).Serialize()
 	stored.TSS_private_map = (c.TSS_private_map).Serialize()
+	if c.TSS_master_key != nil {
+		stored.TSS_master_key = c.TSS_master_key.Serialize()
+	}
+	stored.DSS_scheme_map = c.DSS_scheme_map
+	var err error
+	stored.Signer_public_map, stored.Signer_private_map, err = encodeSignerKeys(c.Signer_public_map, c.Signer_private_map)
+	HandleError(err, "EncodeCrypto")
 	return stored
 }
 
@@ -122,6 +212,8 @@ func DecodeCrypto(c *StoredCrypto) (*GlobalCrypto, error) {
 		DSS_Scheme:      c.DSS_Scheme,
 		TSS_Scheme:      c.TSS_Scheme,
 		HashScheme:      HashAlgorithm(c.HashScheme),
+		TLS_CA:          c.TLS_CA,
+		TLS_cert_map:    c.TLS_cert_map,
 		TSS_public_map:  make(BlsPublicMap),
 		TSS_private_map: make(BlsPrivateMap),
 	}
@@ -133,6 +225,23 @@ func DecodeCrypto(c *StoredCrypto) (*GlobalCrypto, error) {
 	if err != nil {
 		return global, err
 	}
+	global.DSS_scheme_map = c.DSS_scheme_map
+	global.Signer_public_map, global.Signer_private_map, err = decodeSignerKeys(c.Signer_public_map, c.Signer_private_map)
+	if err != nil {
+		return global, err
+	}
+	if c.TSS_master_key != nil {
+		global.TSS_master_key = new(bls.PublicKey)
+		err = global.TSS_master_key.Deserialize(c.TSS_master_key)
+		return global, err
+	}
+	// Configs stored without the master public key still hold enough public shares to interpolate it
+	if len(global.TSS_public_map) >= global.Threshold && global.Threshold > 0 {
+		global.TSS_master_key, err = MasterPublicKey(global.TSS_public_map, global.Threshold)
+		if err != nil {
+			return global, err
+		}
+	}
 	return global, nil
 }
 
@@ -156,24 +265,49 @@ func (c *GlobalCrypto) Hash(msg []byte) ([]byte, error) {
 	return nil, errors.New("Hash Scheme not supported")
 }
 
-// Sign a message using the configured "normal signature" scheme.
+// Sign a message using the "normal signature" scheme of the signer.
 // Note: This is not a threshold signature/threshold signature fragment.
 func (c *GlobalCrypto) Sign(msg []byte, id CTngID) (RSASig, error) {
-	if c.DSS_Scheme == "rsa" {
-		sk := c.DSS_private_map[id]
+	scheme := c.SchemeOf(id)
+	if scheme == "rsa" {
+		sk, ok := c.DSS_private_map[id]
+		if !ok {
+			return RSASig{}, errors.New("No private key for " + id.String())
+		}
 		return RSASign(msg, &sk, id)
 	}
-	return RSASig{}, errors.New("Sign Scheme not supported")
+	signer, ok := Signers[scheme]
+	if !ok {
+		return RSASig{}, errors.New("Sign Scheme not supported")
+	}
+	sk, ok := c.Signer_private_map[id]
+	if !ok {
+		return RSASig{}, errors.New("No private key for " + id.String())
+	}
+	sig, err := signer.Sign(msg, sk)
+	return RSASig{Sig: sig, ID: id}, err
 }
 
-// Verify a message using the configured "normal signature" scheme, and the stored public keys.
+// Verify a message using the "normal signature" scheme of the signer, and the stored public keys.
 func (c *GlobalCrypto) Verify(msg []byte, sig RSASig) error {
-	if c.DSS_Scheme == "rsa" {
-		pub := c.DSS_public_map[sig.ID]
+	scheme := c.SchemeOf(sig.ID)
+	if scheme == "rsa" {
+		pub, ok := c.DSS_public_map[sig.ID]
+		if !ok {
+			return errors.New("No public key for " + sig.ID.String())
+		}
 		//fmt.Println("PublicKey Found: ",pub)
 		return RSAVerify(msg, sig, &pub)
 	}
-	return errors.New("Sign Scheme not supported")
+	signer, ok := Signers[scheme]
+	if !ok {
+		return errors.New("Sign Scheme not supported")
+	}
+	pub, ok := c.Signer_public_map[sig.ID]
+	if !ok {
+		return errors.New("No public key for " + sig.ID.String())
+	}
+	return signer.Verify(msg, sig.Sig, pub)
 }
 
 // Sign a message to make a keyfragment using the configured "threshold signature" scheme.
@@ -199,11 +333,13 @@ func (c *GlobalCrypto) ThresholdAggregate(sigs []SigFragment) (ThresholdSig, err
 	return ThresholdSig{}, errors.New("Threshold Scheme not supported")
 }
 
-// Verify a threshold signature using the configured "threshold signature" scheme, and the stored public keys.
-// Uses the keys stored in the GlobalCrypto struct to verify the signature.
+// Verify a threshold signature using the configured "threshold signature" scheme, and the master public key.
 func (c *GlobalCrypto) ThresholdVerify(msg string, sig ThresholdSig) error {
 	if c.TSS_Scheme == "bls" {
-		if sig.Verify(msg, &c.TSS_public_map) {
+		if c.TSS_master_key == nil {
+			return errors.New("No master public key")
+		}
+		if sig.Sign != nil && sig.Verify(msg, c.TSS_master_key) {
 			return nil
 		} else {
 			return errors.New("Threshold Signature Verification Failed")
@@ -225,6 +361,162 @@ func (c *GlobalCrypto) FragmentVerify(msg string, sig SigFragment) error {
 	return errors.New("Threshold Scheme not supported")
 }
 
+// Verify a CPoM: both heads must be validly signed by the convicted entity for the same period and differ.
+// Returns the period of the conflicting heads.
+func (c *GlobalCrypto) VerifyCPoM(cpom CPoM) (int, error) {
+	var signers [2]string
+	var periods [2]int
+	var sigs [2]RSASig
+	var tbs [2][]byte
+	var err error
+	switch md1 := cpom.MetaData1.(type) {
+	case STH:
+		md2, ok := cpom.MetaData2.(STH)
+		if !ok {
+			return 0, errors.New("CPoM metadata types differ")
+		}
+		for i, sth := range []STH{md1, md2} {
+			signers[i], periods[i], sigs[i] = sth.LID, sth.PeriodNum, sth.Signature
+			sth.Signature = RSASig{}
+			if tbs[i], err = json.Marshal(sth); err != nil {
+				return 0, err
+			}
+		}
+	case SRH:
+		md2, ok := cpom.MetaData2.(SRH)
+		if !ok {
+			return 0, errors.New("CPoM metadata types differ")
+		}
+		for i, srh := range []SRH{md1, md2} {
+			signers[i], periods[i], sigs[i] = srh.CAID, srh.PeriodNum, srh.Signature
+			srh.Signature = RSASig{}
+			if tbs[i], err = json.Marshal(srh); err != nil {
+				return 0, err
+			}
+		}
+	default:
+		return 0, errors.New("CPoM metadata is neither an STH nor an SRH")
+	}
+	for i := range tbs {
+		if CTngID(signers[i]) != cpom.Entity_Convicted || sigs[i].ID != cpom.Entity_Convicted {
+			return 0, errors.New("CPoM not signed by the convicted entity")
+		}
+		if err := c.Verify(tbs[i], sigs[i]); err != nil {
+			return 0, err
+		}
+	}
+	if periods[0] != periods[1] {
+		return 0, errors.New("CPoM periods do not match")
+	}
+	if bytes.Equal(tbs[0], tbs[1]) {
+		return 0, errors.New("CPoM heads do not conflict")
+	}
+	return periods[0], nil
+}
+
+// Verify a BPoM: the head must be validly signed by the convicted entity and commit to Head_rs,
+// and the shares proven under Head_rs, at least K, must not re-encode to it. Returns the period of the head.
+func (c *GlobalCrypto) VerifyBPoM(bpom BPoM) (int, error) {
+	var signer string
+	var period int
+	var sig RSASig
+	var erasure *ErasureParams
+	var shardSize int
+	var tbs []byte
+	var err error
+	switch {
+	case bpom.STH != nil && bpom.SRH == nil:
+		sth := *bpom.STH
+		if !sth.CommitsTo(bpom.Head_rs, bpom.Head_cert) {
+			return 0, errors.New("BPoM share tree not signed in the STH")
+		}
+		signer, period, sig, erasure, shardSize = sth.LID, sth.PeriodNum, sth.Signature, sth.Erasure, sth.ShardSize
+		sth.Signature = RSASig{}
+		tbs, err = json.Marshal(sth)
+	case bpom.SRH != nil && bpom.STH == nil:
+		srh := *bpom.SRH
+		if !srh.CommitsTo(bpom.Head_rs) {
+			return 0, errors.New("BPoM share tree not signed in the SRH")
+		}
+		signer, period, sig, erasure = srh.CAID, srh.PeriodNum, srh.Signature, srh.Erasure
+		srh.Signature = RSASig{}
+		tbs, err = json.Marshal(srh)
+	default:
+		return 0, errors.New("BPoM needs either an STH or an SRH")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if CTngID(signer) != bpom.Entity_Convicted || sig.ID != bpom.Entity_Convicted {
+		return 0, errors.New("BPoM not signed by the convicted entity")
+	}
+	if err := c.Verify(tbs, sig); err != nil {
+		return 0, err
+	}
+	// Threshold is Mal+1
+	params, err := HeadErasure(erasure, c.Total, c.Threshold-1)
+	if err != nil {
+		return 0, err
+	}
+	if len(bpom.Shares) < params.K || len(bpom.Shares) > params.K+params.M || len(bpom.PoIs) != len(bpom.Shares) {
+		return 0, fmt.Errorf("BPoM needs %d to %d shares with their PoIs", params.K, params.K+params.M)
+	}
+	if shardSize == 0 {
+		// an SRH does not sign the share size, all the shares must still have the same one
+		shardSize = len(bpom.Shares[0])
+	}
+	shards := make([][]byte, params.K+params.M)
+	for i, share := range bpom.Shares {
+		// monitors only take shares of the signed size, shares of other sizes prove nothing about the encoding
+		if len(share) != shardSize {
+			return 0, fmt.Errorf("BPoM share of %d bytes, expected %d", len(share), shardSize)
+		}
+		index := PoIIndex(bpom.PoIs[i])
+		if index < 0 || index >= len(shards) || len(share) == 0 || len(shards[index]) > 0 {
+			return 0, errors.New("BPoM shares are not at distinct positions")
+		}
+		if ok, err := VerifyPOI2(bpom.Head_rs, bpom.PoIs[i].Proof, share); err != nil || !ok {
+			return 0, errors.New("BPoM share not included under Head_rs")
+		}
+		shards[index] = share
+	}
+	full, _, err := params.Reencode(shards)
+	if err != nil {
+		// a rateless code may need more shares, which proves nothing
+		return 0, err
+	}
+	root, err := ShareRoot(full)
+	if err != nil {
+		return 0, err
+	}
+	if bytes.Equal(root, bpom.Head_rs) {
+		return 0, errors.New("BPoM shares re-encode to Head_rs")
+	}
+	return period, nil
+}
+
+// Verify an APoM: the accusation must carry a valid threshold signature of the monitors.
+func (c *GlobalCrypto) VerifyAPoM(apom APoM) error {
+	sig, err := ThresholdSigFromString(apom.Signature)
+	if err != nil {
+		return err
+	}
+	return c.QuorumVerify(AccusationMessage(apom.Entity_Convicted, apom.Period), sig)
+}
+
+// Verify a threshold signature that must list at least Threshold distinct monitors.
+// Fewer Fragments cannot interpolate a valid signature anyway, the list is checked to reject malformed signatures early.
+func (c *GlobalCrypto) QuorumVerify(msg string, sig ThresholdSig) error {
+	signers := make(map[CTngID]bool, len(sig.IDs))
+	for _, id := range sig.IDs {
+		signers[id] = true
+	}
+	if len(signers) < c.Threshold {
+		return errors.New("Not enough signers in the threshold signature")
+	}
+	return c.ThresholdVerify(msg, sig)
+}
+
 // Generic Ids are URLS.
 type CTngID string
 
@@ -3,11 +3,16 @@ package def
 import (
 	"bytes"
 	"encoding/asn1"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/bits-and-blooms/bitset"
 	"github.com/klauspost/reedsolomon"
 	merkletree "github.com/txaty/go-merkletree"
 )
@@ -211,7 +216,7 @@ func TestBLSFunctionality(T *testing.T) {
 	threshold := 2
 
 	// First term is list of BLS ids. We now derive the BLS ids from the CTngIDs, so it can be ignored.
-	_, pubs, privs, _, err := GenerateThresholdKeypairs(entities, threshold)
+	_, pubs, privs, mpk, err := GenerateThresholdKeypairs(entities, threshold)
 
 	confirmNil(T, err)
 
@@ -250,27 +255,34 @@ func TestBLSFunctionality(T *testing.T) {
 		//Aggregate first, then confirm the aggregates verify'
 		agg, err := ThresholdAggregate(sigs[l:r], threshold)
 		confirmNil(T, err)
-		if agg.Verify(data, &pubs) == false {
+		if agg.Verify(data, &mpk[0]) == false {
 			T.Errorf("Aggregate failed to verify!")
 		}
-		/*
-			if agg.MasterVerify(data, mpk) == false {
-				fmt.Println("Master PublicKey:", mpk)
-				T.Errorf("mpk Aggregate failed to verify!")
-			}*/
 		fmt.Println(agg)
-		// Provide an incorrect signer and confirm that the aggregate fails to verify
-		agg.IDs[0] = sigs[r%n].ID
-		if agg.Verify(data, &pubs) != false {
+		if agg.Verify(wrongData, &mpk[0]) != false {
+			T.Errorf("Aggregate verified incorrect data!")
+		}
+		// Provide an incorrect signer and confirm that the interpolated signature fails to verify
+		wrong := append([]SigFragment{}, sigs[l:r]...)
+		wrong[0].ID = sigs[r%n].ID
+		agg, err = ThresholdAggregate(wrong, threshold)
+		confirmNil(T, err)
+		if agg.Verify(data, &mpk[0]) != false {
 			T.Errorf("Aggregate verified with incorrect signer!")
 		}
-		// Remove a signer and confirm that the aggregate fails to verify
-		agg.IDs = agg.IDs[1:]
-		if agg.Verify(data, &pubs) != false {
-			T.Errorf("Aggregate verified with insufficient signers!")
+		// Remove a signer and confirm that the aggregate cannot be made
+		if _, err := ThresholdAggregate(sigs[l+1:r], threshold); err == nil {
+			T.Errorf("Aggregate made with insufficient signers!")
 		}
 	}
 
+	// The master public key can be interpolated from the public keys of threshold signers
+	master, err := MasterPublicKey(pubs, threshold)
+	confirmNil(T, err)
+	if !master.IsEqual(&mpk[0]) {
+		T.Errorf("Interpolated master public key differs!")
+	}
+
 }
 
 func TestCryptoconfig(t *testing.T) {
@@ -314,11 +326,218 @@ func TestCryptoconfig(t *testing.T) {
 	}
 }
 
+func TestCPoM(t *testing.T) {
+	config := CTngKeyGen(2, 2, 4, 3)
+	signSTH := func(head string) STH {
+		sth := STH{LID: "L1", PeriodNum: 3, Size: 10, Head: []byte(head)}
+		msg, _ := json.Marshal(sth)
+		sth.Signature, _ = config.Sign(msg, CTngID("L1"))
+		return sth
+	}
+	cpom := CPoM{Entity_Convicted: CTngID("L1"), MetaData1: signSTH("head1"), MetaData2: signSTH("head2")}
+	cpom_json, err := json.Marshal(cpom)
+	confirmNil(t, err)
+	decoded, err := DecodeCPoM(cpom_json)
+	confirmNil(t, err)
+	period, err := config.VerifyCPoM(decoded)
+	confirmNil(t, err)
+	if period != 3 {
+		t.Errorf("Expected period 3, got %d", period)
+	}
+	// identical heads are not a conflict
+	cpom.MetaData2 = cpom.MetaData1
+	if _, err := config.VerifyCPoM(cpom); err == nil {
+		t.Errorf("Non-conflicting heads accepted")
+	}
+	// a tampered head no longer verifies
+	tampered := signSTH("head2")
+	tampered.Head = []byte("head3")
+	cpom.MetaData2 = tampered
+	if _, err := config.VerifyCPoM(cpom); err == nil {
+		t.Errorf("Tampered head accepted")
+	}
+}
+
+func TestAPoM(t *testing.T) {
+	config := CTngKeyGen(2, 2, 4, 3)
+	msg := AccusationMessage(CTngID("C2"), 5)
+	var sigs []SigFragment
+	for _, id := range []CTngID{"M1", "M2", "M3"} {
+		sigfrag, err := config.ThresholdSign(msg, id)
+		confirmNil(t, err)
+		sigs = append(sigs, sigfrag)
+	}
+	sig, err := config.ThresholdAggregate(sigs)
+	confirmNil(t, err)
+	sigstring, err := sig.String()
+	confirmNil(t, err)
+	apom := APoM{Entity_Convicted: CTngID("C2"), Period: 5, Signature: sigstring}
+	confirmNil(t, config.VerifyAPoM(apom))
+	// the accusation is bound to the period
+	apom.Period = 6
+	if config.VerifyAPoM(apom) == nil {
+		t.Errorf("APoM accepted for the wrong period")
+	}
+	// fewer than Threshold signers is not an APoM
+	sig, _ = ThresholdAggregate(sigs[:2], 2)
+	sigstring, _ = sig.String()
+	apom = APoM{Entity_Convicted: CTngID("C2"), Period: 5, Signature: sigstring}
+	if config.VerifyAPoM(apom) == nil {
+		t.Errorf("APoM accepted with too few signers")
+	}
+}
+
+func TestFaults(t *testing.T) {
+	settings := Generate_IP_Json_template(
+		2, 2, 4, 1,
+		"127.0.0.", 10, "127.0.1.", 20, "127.0.2.", 30,
+		8000, 5, 0, 6, 10,
+		30, EEA, MIN_WT, 10000, 0.002, 2000, 20,
+	)
+	settings.Faults = []Fault{
+		{Entity: "L1", Behavior: FAULT_EQUIVOCATE, Targets: []CTngID{"M1"}, Periods: []int{1}},
+		{Entity: "M4", Behavior: FAULT_DELAY, Delay: 500},
+	}
+	confirmNil(t, ValidateFaults(*settings))
+	faults := GetFaults(*settings, "L1")
+	if len(faults) != 1 || !faults[0].Active(1) || faults[0].Active(2) || !faults[0].Affects("M1") || faults[0].Affects("M2") {
+		t.Errorf("Unexpected faults for L1: %v", faults)
+	}
+	if len(GetFaults(*settings, "C1")) != 0 {
+		t.Errorf("C1 has no fault")
+	}
+	invalid := [][]Fault{
+		{{Entity: "L9", Behavior: FAULT_WITHHOLD}},
+		{{Entity: "M1", Behavior: FAULT_WITHHOLD}},
+		{{Entity: "C1", Behavior: FAULT_DROP}},
+		{{Entity: "C1", Behavior: "lie"}},
+		{{Entity: "C1", Behavior: FAULT_WITHHOLD, Targets: []CTngID{"L1"}}},
+		// Mal = 1, two faulty monitors are too many
+		{{Entity: "M1", Behavior: FAULT_DROP}, {Entity: "M2", Behavior: FAULT_BOGUS_SIG}},
+	}
+	for _, faults := range invalid {
+		settings.Faults = faults
+		if ValidateFaults(*settings) == nil {
+			t.Errorf("Invalid faults accepted: %v", faults)
+		}
+	}
+	head := []byte("head")
+	if bytes.Equal(ForkHead(head), head) || !bytes.Equal(ForkHead(head), ForkHead(head)) {
+		t.Errorf("ForkHead should deterministically change the head")
+	}
+	share := []byte{1, 2, 3}
+	if bytes.Equal(CorruptShare(share), share) || share[0] != 1 {
+		t.Errorf("CorruptShare should change a copy of the share")
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	config := CTngKeyGen(1, 1, 2, 2)
+	payload := []byte(`{"type":"note"}`)
+	envelope, err := config.Seal("M1", "/monitor/transparency_notification", 3, payload)
+	confirmNil(t, err)
+	confirmNil(t, config.Open(envelope, "/monitor/transparency_notification"))
+	if config.Open(envelope, "/monitor/transparency_request") == nil {
+		t.Errorf("Envelope accepted on another endpoint")
+	}
+	tampered := envelope
+	tampered.Payload = []byte(`{"type":"other"}`)
+	if config.Open(tampered, "/monitor/transparency_notification") == nil {
+		t.Errorf("Tampered payload accepted")
+	}
+	tampered = envelope
+	tampered.Period = 4
+	if config.Open(tampered, "/monitor/transparency_notification") == nil {
+		t.Errorf("Tampered period accepted")
+	}
+	tampered = envelope
+	tampered.Sender = "M2"
+	if config.Open(tampered, "/monitor/transparency_notification") == nil {
+		t.Errorf("Envelope accepted for another sender")
+	}
+	if _, err := config.Seal("M3", "/monitor/transparency_notification", 3, payload); err == nil {
+		t.Errorf("Sealed without an identity key")
+	}
+}
+
+func TestKeyFiles(t *testing.T) {
+	config := CTngKeyGen(1, 1, 2, 2)
+	cryptofile := filepath.Join(t.TempDir(), "config.json")
+	confirmNil(t, WriteKeyFiles(config, cryptofile))
+
+	public := new(StoredCrypto)
+	LoadData(&public, cryptofile)
+	if len(public.DSS_private_map) != 0 || len(public.TSS_private_map) != 0 || len(public.TLS_cert_map) != 0 {
+		t.Fatalf("The public config holds private keys")
+	}
+	info, err := os.Stat(KeyFile(cryptofile, "M1"))
+	confirmNil(t, err)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Key file readable by others: %v", info.Mode())
+	}
+
+	m1, err := LoadCrypto(cryptofile, "M1")
+	confirmNil(t, err)
+	if len(m1.DSS_private_map) != 1 || len(m1.TSS_private_map) != 1 || len(m1.TLS_cert_map) != 1 {
+		t.Fatalf("M1 should only hold its own keys")
+	}
+	sigfrag, err := m1.ThresholdSign("msg", "M1")
+	confirmNil(t, err)
+	confirmNil(t, m1.FragmentVerify("msg", sigfrag))
+	l1, err := LoadCrypto(cryptofile, "L1")
+	confirmNil(t, err)
+	sig, err := l1.Sign([]byte("msg"), "L1")
+	confirmNil(t, err)
+	// a monitor verifies with the public keys only
+	confirmNil(t, m1.Verify([]byte("msg"), sig))
+	if _, err := l1.Sign([]byte("msg"), "C1"); err == nil {
+		t.Errorf("L1 signed with the key of C1")
+	}
+	if _, err := LoadCrypto(cryptofile, "M9"); err == nil {
+		t.Errorf("Loaded the keys of an unknown entity")
+	}
+
+	// a config with every key is refused
+	legacyfile := filepath.Join(t.TempDir(), "legacy.json")
+	confirmNil(t, WriteData(EncodeCrypto(config), legacyfile))
+	if _, err := LoadCrypto(legacyfile, "M2"); err == nil {
+		t.Errorf("Config holding every private key accepted")
+	}
+}
+
+func TestThresholdKeys(t *testing.T) {
+	config := CTngKeyGen(1, 1, 2, 2)
+	cryptofile := filepath.Join(t.TempDir(), "config.json")
+	confirmNil(t, WriteKeyFiles(config, cryptofile))
+	keyfile := KeyFile(cryptofile, "M1")
+	m1, err := LoadCrypto(cryptofile, "M1")
+	confirmNil(t, err)
+	if loaded, err := LoadThresholdKeys(m1, keyfile, "M1"); loaded || err != nil {
+		t.Fatalf("Loaded threshold keys of no DKG: %v", err)
+	}
+
+	// the keys of a DKG replace those of the public config, the identity key is kept
+	generated := CTngKeyGen(1, 1, 2, 2)
+	confirmNil(t, WriteThresholdKeys(generated, keyfile, "M1"))
+	restarted, err := LoadCrypto(cryptofile, "M1")
+	confirmNil(t, err)
+	loaded, err := LoadThresholdKeys(restarted, keyfile, "M1")
+	confirmNil(t, err)
+	if !loaded || !restarted.TSS_master_key.IsEqual(generated.TSS_master_key) || len(restarted.DSS_private_map) != 1 {
+		t.Fatalf("Threshold keys not restored")
+	}
+	sigfrag, err := restarted.ThresholdSign("msg", "M1")
+	confirmNil(t, err)
+	confirmNil(t, generated.FragmentVerify("msg", sigfrag))
+}
+
 func TestCryptoIO(t *testing.T) {
 	newconfig := CTngKeyGen(2, 2, 4, 3)
 	storedconfig := EncodeCrypto(newconfig)
 	// Write the encoded configuration to a file (handle errors if necessary).
-	err := WriteData(storedconfig, "testconfig.json")
+	// The config holds every private key, it must not replace the public test config
+	cryptofile := filepath.Join(t.TempDir(), "testconfig.json")
+	err := WriteData(storedconfig, cryptofile)
 	if err != nil {
 		t.Fatalf("Failed to write data: %v", err)
 	}
@@ -327,7 +546,7 @@ func TestCryptoIO(t *testing.T) {
 	restoredconfig := new(StoredCrypto)
 
 	// Load the configuration from the file.
-	LoadData(&restoredconfig, "testconfig.json")
+	LoadData(&restoredconfig, cryptofile)
 	config, err := DecodeCrypto(restoredconfig)
 	if err != nil {
 		t.Errorf("Decoding failed: %v", err)
@@ -371,7 +590,14 @@ func TestCryptoIO(t *testing.T) {
 	}
 }
 
+// out is where TestSimulationIO writes the test config and key files, go test -out . replaces those of this folder
+var out = flag.String("out", "", "Directory of the generated files, a temporary one if empty")
+
 func TestSimulationIO(t *testing.T) {
+	dir := *out
+	if dir == "" {
+		dir = t.TempDir()
+	}
 	num_monitors := 4
 	Mal := 2
 	num_loggers := 2
@@ -379,11 +605,8 @@ func TestSimulationIO(t *testing.T) {
 	// Generate a new configuration using CTngKeyGen function with specified parameters.
 	newconfig := CTngKeyGen(num_loggers, num_cas, num_monitors, Mal+1)
 
-	// Encode the new configuration into a storable format.
-	storedconfig := EncodeCrypto(newconfig)
-
-	// Write the encoded configuration to a file and handle any errors.
-	err := WriteData(storedconfig, "testconfig.json")
+	// Write the public configuration and one key file per entity, and handle any errors.
+	err := WriteKeyFiles(newconfig, filepath.Join(dir, "testconfig.json"))
 	if err != nil {
 		t.Fatalf("Failed to write data: %v", err)
 	}
@@ -441,8 +664,12 @@ func TestSimulationIO(t *testing.T) {
 		*mud, *dmode, *bmode, *crvsize, *revocation_ratio, *certificate_size, *certificate_per_logger,
 	)
 
+	// The CAs default to f+1 data shares, more than the n-f honest monitors hold when half of them are malicious
+	if settings.Num_Monitors-settings.Mal < settings.Mal+1 {
+		settings.Erasure_K = settings.Num_Monitors - settings.Mal
+	}
 	// Write the generated settings to a file and handle any errors.
-	err = WriteData(settings, "testsettings.json")
+	err = WriteData(settings, filepath.Join(dir, "testsettings.json"))
 	if err != nil {
 		t.Fatalf("Failed to write data: %v", err)
 	}
@@ -450,3 +677,452 @@ func TestSimulationIO(t *testing.T) {
 	fmt.Println(GetIDs('M', *settings))
 	fmt.Println(MapIDtoInt(CTngID("C8")))
 }
+
+func TestCertificateHashTree(t *testing.T) {
+	for _, num := range []int{1, 2, 5} {
+		var certs [][]byte
+		for i := 0; i < num; i++ {
+			certs = append(certs, []byte(fmt.Sprintf("certificate %d", i)))
+		}
+		tree, err := GenerateMerkleTree(CertificateBlocks(certs))
+		confirmNil(t, err)
+		hashTree, err := CertificateHashTree(CertificateHashes(certs))
+		confirmNil(t, err)
+		if !bytes.Equal(GenerateRootHash(tree), GenerateRootHash(hashTree)) {
+			t.Fatalf("Roots differ for %d certificates", num)
+		}
+		for _, cert := range certs {
+			poi, err := CertificateProof(hashTree, CertificateHash(cert))
+			confirmNil(t, err)
+			ok, err := VerifyPOI2(GenerateRootHash(tree), poi.Proof, cert)
+			if err != nil || !ok {
+				t.Errorf("PoI verification failed for %d certificates", num)
+			}
+		}
+	}
+}
+
+func TestCRVHash(t *testing.T) {
+	dcrv := bitset.New(1000)
+	dcrv.Set(3).Set(700)
+	compressed, err := CompressDCRV(dcrv)
+	confirmNil(t, err)
+	restored, err := DecompressDCRV(compressed)
+	confirmNil(t, err)
+	// the union with an empty CRV of another length hashes the same
+	crv := ApplyDCRV(bitset.New(10), restored)
+	if !bytes.Equal(CRVHash(crv), CRVHash(dcrv)) {
+		t.Errorf("CRV hash depends on the bitset length")
+	}
+	crv.Set(5)
+	if bytes.Equal(CRVHash(crv), CRVHash(dcrv)) {
+		t.Errorf("CRV hash ignores a revocation")
+	}
+}
+
+func TestSigners(t *testing.T) {
+	schemes := map[CTngID]string{"L1": DSS_ED25519, "C1": DSS_ECDSA}
+	config := CTngKeyGenSchemes(2, 2, 2, 2, schemes)
+	msg := []byte("STH of period 1")
+	for _, id := range []CTngID{"L1", "L2", "C1", "C2", "M1"} {
+		sig, err := config.Sign(msg, id)
+		confirmNil(t, err)
+		confirmNil(t, config.Verify(msg, sig))
+		if config.Verify([]byte("another STH"), sig) == nil {
+			t.Errorf("Signature of %s verified another message", id)
+		}
+	}
+	if config.SchemeOf("L1") != DSS_ED25519 || config.SchemeOf("L2") != DSS_RSA {
+		t.Errorf("Wrong schemes: %s and %s", config.SchemeOf("L1"), config.SchemeOf("L2"))
+	}
+	// a signature is checked with the scheme of its claimed signer
+	sig, _ := config.Sign(msg, "L1")
+	sig.ID = "C1"
+	if config.Verify(msg, sig) == nil {
+		t.Errorf("Ed25519 signature verified as ECDSA")
+	}
+
+	// the keys survive the config and the key files
+	cryptofile := filepath.Join(t.TempDir(), "config.json")
+	confirmNil(t, WriteKeyFiles(config, cryptofile))
+	c1, err := LoadCrypto(cryptofile, "C1")
+	confirmNil(t, err)
+	sig, err = c1.Sign(msg, "C1")
+	confirmNil(t, err)
+	decoded, err := DecodeCrypto(EncodeCrypto(config))
+	confirmNil(t, err)
+	confirmNil(t, decoded.Verify(msg, sig))
+	if len(c1.Signer_private_map) != 1 {
+		t.Errorf("C1 should only hold its own key")
+	}
+
+	settings := Settings{DSS_Schemes: map[CTngID]string{"M1": DSS_ED25519}}
+	if ValidateSchemes(settings) == nil {
+		t.Errorf("Monitors must keep RSA keys")
+	}
+	settings.DSS_Schemes = map[CTngID]string{"L1": "dsa"}
+	if ValidateSchemes(settings) == nil {
+		t.Errorf("Unknown scheme accepted")
+	}
+}
+
+// BenchmarkSigners measures the cost of the schemes on an STH, and reports the signature size
+func BenchmarkSigners(b *testing.B) {
+	sth := STH{LID: "L1", PeriodNum: 1, Size: 10, Head: make([]byte, 32)}
+	msg, _ := json.Marshal(sth)
+	for _, scheme := range []string{DSS_RSA, DSS_ED25519, DSS_ECDSA} {
+		signer := Signers[scheme]
+		sk, err := signer.GenerateKey()
+		if err != nil {
+			b.Fatal(err)
+		}
+		sig, err := signer.Sign(msg, sk)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(scheme+"/sign", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				signer.Sign(msg, sk)
+			}
+		})
+		b.Run(scheme+"/verify", func(b *testing.B) {
+			b.ReportMetric(float64(len(sig)), "sig-bytes")
+			for i := 0; i < b.N; i++ {
+				if err := signer.Verify(msg, sig, sk.Public()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestErasureParams(t *testing.T) {
+	settings := Settings{Num_Monitors: 10, Mal: 3}
+	cases := []struct {
+		policy string
+		k, m   int
+	}{
+		{"", 5, 5},
+		{EC_F_PLUS_1, 4, 6},
+		{EC_HALF, 5, 5},
+		{EC_N_MINUS_2F, 4, 6},
+	}
+	for _, c := range cases {
+		settings.Erasure_Policy = c.policy
+		params, err := GetErasureParams(settings, "L1")
+		confirmNil(t, err)
+		if params.K != c.k || params.M != c.m {
+			t.Errorf("Policy %q gave k=%d m=%d, expected k=%d m=%d", c.policy, params.K, params.M, c.k, c.m)
+		}
+	}
+	settings.Erasure_Policy = ""
+	if params, _ := GetErasureParams(settings, "C1"); params.K != 4 {
+		t.Errorf("CAs should default to f+1 data shards, not %d", params.K)
+	}
+	settings.Erasure_M = 3
+	if params, _ := GetErasureParams(settings, "C1"); params.K != 7 {
+		t.Errorf("Erasure_M=3 should leave 7 data shards, not %d", params.K)
+	}
+	settings.Erasure_K = 6
+	if ValidateErasure(settings) == nil {
+		t.Errorf("Erasure_K=6 and Erasure_M=3 accepted for 10 monitors")
+	}
+
+	// parameters outside the safe bounds are rejected
+	for _, bad := range []Settings{
+		{Num_Monitors: 10, Mal: 3, Erasure_K: 10},
+		{Num_Monitors: 10, Mal: 3, Erasure_K: 8},
+		{Num_Monitors: 4, Mal: 2, Erasure_Policy: EC_N_MINUS_2F},
+		{Num_Monitors: 300, Mal: 99},
+		{Num_Monitors: 10, Mal: 3, Erasure_Policy: "n/3"},
+	} {
+		if ValidateErasure(bad) == nil {
+			t.Errorf("Accepted %+v", bad)
+		}
+	}
+	if _, err := HeadErasure(nil, 4, 1); err == nil {
+		t.Errorf("A head without parameters should be rejected")
+	}
+	if _, err := HeadErasure(&ErasureParams{K: 2, M: 3}, 4, 1); err == nil {
+		t.Errorf("Parameters for 5 monitors accepted with 4")
+	}
+	if _, err := HeadErasure(&ErasureParams{K: 8, M: 2}, 10, 3); err == nil {
+		t.Errorf("k=8 accepted with only 7 honest monitors")
+	}
+}
+
+func TestErasureDecode(t *testing.T) {
+	params := ErasureParams{K: 2, M: 2}
+	enc, err := reedsolomon.New(params.K, params.M)
+	confirmNil(t, err)
+	data := []byte("certificate file")
+	shards, err := enc.Split(data)
+	confirmNil(t, err)
+	confirmNil(t, enc.Encode(shards))
+
+	// only the parity shares
+	decoded, err := params.Decode([][]byte{nil, nil, shards[2], shards[3]})
+	confirmNil(t, err)
+	if !bytes.Equal(decoded[:len(data)], data) {
+		t.Errorf("Decoded %q from the parity shares", decoded)
+	}
+	if _, err := params.Decode([][]byte{shards[0], nil, nil, nil}); err == nil {
+		t.Errorf("Decoded from a single share")
+	}
+	// a share of the wrong size is an encoding error, not a crash
+	if _, err := params.Decode([][]byte{shards[0], shards[1][:1], nil, shards[3]}); err == nil {
+		t.Errorf("Decoded shares of different sizes")
+	}
+}
+
+// encodedShares encodes random data with the code of params, returning the shares
+func encodedShares(t testing.TB, params ErasureParams, size int) [][]byte {
+	enc, err := params.Coder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := make([][]byte, params.K+params.M)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < params.K {
+			rand.Read(shards[i])
+		}
+	}
+	if err := enc.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+	return shards
+}
+
+// sharesNeeded returns how many of the shares, taken in the order given, decode
+func sharesNeeded(params ErasureParams, shards [][]byte, order []int) int {
+	received := make([][]byte, len(shards))
+	for count, i := range order {
+		received[i] = shards[i]
+		if count+1 >= params.K {
+			if _, _, err := params.Reencode(received); err == nil {
+				return count + 1
+			}
+		}
+	}
+	return len(order) + 1
+}
+
+func TestLTCode(t *testing.T) {
+	if err := (ErasureParams{K: 100, M: 200, Code: EC_LT}).Check(300, 99); err != nil {
+		t.Errorf("LT codes should not be bound to 256 shares: %v", err)
+	}
+	if (ErasureParams{K: 150, M: 150, Code: EC_LT}).Check(300, 99) == nil {
+		t.Errorf("LT code needing more shares than the honest monitors hold accepted")
+	}
+	if p, err := GetErasureParams(Settings{Num_Monitors: 32, Mal: 10, Erasure_Code: EC_LT}, "L1"); err != nil || p.K != 11 {
+		t.Errorf("LT codes should default to k=(n-f)/2, got %v: %v", p, err)
+	}
+	if ValidateErasure(Settings{Num_Monitors: 10, Mal: 3, Erasure_Code: "raptorq"}) == nil {
+		t.Errorf("Unknown erasure code accepted")
+	}
+
+	params := ErasureParams{K: 64, M: 64, Code: EC_LT}
+	shards := encodedShares(t, params, 32)
+	head_rs, err := ShareRoot(shards)
+	confirmNil(t, err)
+	// every share of a codeword decodes to the same shares
+	full, used, err := params.Reencode(shards)
+	confirmNil(t, err)
+	if len(used) != len(shards) {
+		t.Errorf("Used %d of the %d shares", len(used), len(shards))
+	}
+	if root, _ := ShareRoot(full); !bytes.Equal(root, head_rs) {
+		t.Errorf("Re-encoding all the shares changed Head_rs")
+	}
+	// any sufficient set of shares decodes, the rest keep waiting for more
+	order := rand.Perm(len(shards))
+	needed := sharesNeeded(params, shards, order)
+	if needed > len(shards) {
+		t.Fatalf("All the shares did not decode")
+	}
+	received := make([][]byte, len(shards))
+	for _, i := range order[:needed] {
+		received[i] = shards[i]
+	}
+	decoded, err := params.Decode(received)
+	confirmNil(t, err)
+	if !bytes.Equal(decoded, bytes.Join(shards[:params.K], nil)) {
+		t.Errorf("Decoded other data from %d shares", needed)
+	}
+	received[order[needed-1]] = nil
+	if _, err := params.Decode(received); err == nil {
+		t.Errorf("Decoded from %d shares, fewer than needed", needed-1)
+	}
+
+	// every data share is covered by a parity share, so any single missing one is recovered
+	for _, p := range []ErasureParams{{K: 3, M: 1}, {K: 8, M: 8}, {K: 16, M: 16}, params, {K: 150, M: 150}} {
+		p.Code = EC_LT
+		shares := encodedShares(t, p, 8)
+		for j := 0; j < p.K; j++ {
+			received := append([][]byte{}, shares...)
+			received[j] = nil
+			if decoded, err := p.Decode(received); err != nil || !bytes.Equal(decoded, bytes.Join(shares[:p.K], nil)) {
+				t.Errorf("k=%d m=%d: failed to recover data share %d: %v", p.K, p.M, j, err)
+			}
+		}
+	}
+
+	// shares committed with a corrupted parity share re-encode to another Head_rs
+	shards[params.K] = CorruptShare(shards[params.K])
+	head_rs, err = ShareRoot(shards)
+	confirmNil(t, err)
+	full, _, err = params.Reencode(shards)
+	confirmNil(t, err)
+	if root, _ := ShareRoot(full); bytes.Equal(root, head_rs) {
+		t.Errorf("A corrupted share re-encoded to Head_rs")
+	}
+}
+
+func TestLTDegrees(t *testing.T) {
+	// the degrees of the parity shares follow the robust soliton distribution
+	k, samples := 64, 100000
+	cdf := robustSoliton(k)
+	counts := make([]int, k+1)
+	rng := ltRand(1)
+	for i := 0; i < samples; i++ {
+		counts[rng.degree(cdf)]++
+	}
+	previous := 0.0
+	for d := 1; d <= k; d++ {
+		expected := cdf[d-1] - previous
+		previous = cdf[d-1]
+		if got := float64(counts[d]) / float64(samples); math.Abs(got-expected) > 0.01 {
+			t.Errorf("Degree %d drawn %.3f of the time, expected %.3f", d, got, expected)
+		}
+	}
+}
+
+// BenchmarkErasureCodes compares the decoding cost of the codes at 128 monitors,
+// and how many shares, received in random order, they need to decode
+func BenchmarkErasureCodes(b *testing.B) {
+	for _, code := range []string{EC_RS, EC_LT} {
+		params := ErasureParams{K: 64, M: 64, Code: code}
+		shards := encodedShares(b, params, 1024)
+		b.Run(code, func(b *testing.B) {
+			total := 0
+			for i := 0; i < b.N; i++ {
+				total += sharesNeeded(params, shards, rand.Perm(len(shards)))
+			}
+			b.ReportMetric(float64(total)/float64(b.N), "shares")
+		})
+	}
+}
+
+func TestBPoM(t *testing.T) {
+	config := CTngKeyGen(2, 2, 4, 3)
+	params := ErasureParams{K: 2, M: 2}
+	enc, err := reedsolomon.New(params.K, params.M)
+	confirmNil(t, err)
+	// a bad encoding proof, its shares re-encoded from the first two when honest is set
+	uneven := false
+	prove := func(honest bool) BPoM {
+		shards, err := enc.Split(make([]byte, 64))
+		confirmNil(t, err)
+		confirmNil(t, enc.Encode(shards))
+		if !honest {
+			shards[3] = CorruptShare(shards[3])
+		}
+		shardSize := len(shards[0])
+		if uneven {
+			shards[2] = append(shards[2], 0)
+		}
+		blocks := make([]merkletree.DataBlock, len(shards))
+		for i := range shards {
+			blocks[i] = &LeafBlock{Content: shards[i]}
+		}
+		head_rs, err := ShareRoot(shards)
+		confirmNil(t, err)
+		head_cert := []byte("head_cert")
+		roots, err := GenerateMerkleTree([]merkletree.DataBlock{&LeafBlock{Content: head_rs}, &LeafBlock{Content: head_cert}})
+		confirmNil(t, err)
+		sth := STH{LID: "L1", PeriodNum: 3, Head: GenerateRootHash(roots), Erasure: &params, ShardSize: shardSize}
+		msg, _ := json.Marshal(sth)
+		sth.Signature, _ = config.Sign(msg, CTngID("L1"))
+		bpom := BPoM{Entity_Convicted: "L1", STH: &sth, Head_rs: head_rs, Head_cert: head_cert}
+		for _, i := range []int{1, 2} {
+			// the zero data shares are identical, their PoIs still prove their own positions
+			poi, err := GeneratePOI(nil, blocks, i)
+			confirmNil(t, err)
+			if PoIIndex(poi) != i {
+				t.Fatalf("PoI of share %d proves position %d", i, PoIIndex(poi))
+			}
+			bpom.Shares = append(bpom.Shares, shards[i])
+			bpom.PoIs = append(bpom.PoIs, poi)
+		}
+		return bpom
+	}
+
+	bpom := prove(false)
+	bpom_json, err := json.Marshal(bpom)
+	confirmNil(t, err)
+	var decoded BPoM
+	confirmNil(t, json.Unmarshal(bpom_json, &decoded))
+	period, err := config.VerifyBPoM(decoded)
+	confirmNil(t, err)
+	if period != 3 {
+		t.Errorf("Expected period 3, got %d", period)
+	}
+	if _, err := config.VerifyBPoM(prove(true)); err == nil {
+		t.Errorf("Honest encoding convicted")
+	}
+	// both shares at the same position
+	bpom.PoIs[1] = bpom.PoIs[0]
+	if _, err := config.VerifyBPoM(bpom); err == nil {
+		t.Errorf("Shares at the same position accepted")
+	}
+	// the share tree must be the one of the STH
+	bpom = prove(false)
+	bpom.Head_cert = []byte("another head_cert")
+	if _, err := config.VerifyBPoM(bpom); err == nil {
+		t.Errorf("Share tree not signed in the STH accepted")
+	}
+	// shares of different sizes are rejected, not taken for a bad encoding
+	uneven = true
+	if _, err := config.VerifyBPoM(prove(true)); err == nil {
+		t.Errorf("Shares of different sizes accepted")
+	}
+}
+
+func TestCertificateFile(t *testing.T) {
+	// binary certificates ending in zero bytes, and an empty one, round-trip with the signed length
+	certs := [][]byte{{0x30, 0x00, 0x00}, {}, {0x00}}
+	file := EncodeCertificateFile(certs)
+	shardSize := 5
+	padded := make([]byte, 4*shardSize)
+	copy(padded, file)
+	var shares [][]byte
+	for i := 0; i < len(padded); i += shardSize {
+		shares = append(shares, padded[i:i+shardSize])
+	}
+	sth := STH{FileLen: len(file), ShardSize: shardSize}
+	stripped, err := sth.FileOf(shares)
+	confirmNil(t, err)
+	decoded, err := DecodeCertificateFile(stripped)
+	confirmNil(t, err)
+	if len(decoded) != len(certs) {
+		t.Fatalf("Decoded %d certificates, expected %d", len(decoded), len(certs))
+	}
+	for i := range certs {
+		if !bytes.Equal(decoded[i], certs[i]) {
+			t.Errorf("Certificate %d did not round-trip: %x", i, decoded[i])
+		}
+	}
+	// without the signed length the padding reads as an empty certificate
+	if all, err := DecodeCertificateFile(padded); err == nil && len(all) == len(certs) {
+		t.Errorf("Padding should not decode to the same certificates")
+	}
+	sth.ShardSize = 4
+	if _, err := sth.FileOf(shares); err == nil {
+		t.Errorf("Shares of another size accepted")
+	}
+	sth.ShardSize, sth.FileLen = shardSize, len(padded)+1
+	if _, err := sth.FileOf(shares); err == nil {
+		t.Errorf("File longer than the shares accepted")
+	}
+}
@@ -0,0 +1,80 @@
+package def
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Envelope carries a gossip message, signed by the identity key of the monitor sending it.
+// The signature covers every field but the Payload, which is bound through its Hash.
+type Envelope struct {
+	Sender    CTngID `json:"sender"`
+	Endpoint  string `json:"endpoint"` // A notification can not be replayed as a request
+	Period    int    `json:"period"`   // Period of the sender, receivers only remember the nonces of recent periods
+	Nonce     []byte `json:"nonce"`
+	Hash      []byte `json:"hash"`
+	Payload   []byte `json:"payload"`
+	Signature RSASig `json:"signature"`
+}
+
+// header serializes the signed part of the envelope
+func (e Envelope) header() ([]byte, error) {
+	e.Payload = nil
+	e.Signature = RSASig{}
+	return json.Marshal(e)
+}
+
+// Seal signs the payload sent by a monitor to an endpoint
+func (c *GlobalCrypto) Seal(sender CTngID, endpoint string, period int, payload []byte) (Envelope, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Envelope{}, err
+	}
+	hash, err := c.Hash(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+	e := Envelope{
+		Sender:   sender,
+		Endpoint: endpoint,
+		Period:   period,
+		Nonce:    nonce,
+		Hash:     hash,
+		Payload:  payload,
+	}
+	header, err := e.header()
+	if err != nil {
+		return Envelope{}, err
+	}
+	e.Signature, err = c.Sign(header, sender)
+	return e, err
+}
+
+// Open checks the envelope was signed by its sender for the endpoint it was received on.
+// Freshness is left to the receiver, which tracks the nonces it has seen.
+func (c *GlobalCrypto) Open(e Envelope, endpoint string) error {
+	if e.Endpoint != endpoint {
+		return fmt.Errorf("Envelope for %s received on %s", e.Endpoint, endpoint)
+	}
+	if e.Signature.ID != e.Sender {
+		return errors.New("Envelope not signed by its sender")
+	}
+	if len(e.Nonce) == 0 {
+		return errors.New("Envelope without nonce")
+	}
+	hash, err := c.Hash(e.Payload)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, e.Hash) {
+		return errors.New("Payload does not match the envelope")
+	}
+	header, err := e.header()
+	if err != nil {
+		return err
+	}
+	return c.Verify(header, e.Signature)
+}
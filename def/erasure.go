@@ -0,0 +1,220 @@
+package def
+
+import (
+	"bytes"
+	"fmt"
+
+	rs "github.com/klauspost/reedsolomon"
+	merkletree "github.com/txaty/go-merkletree"
+)
+
+// Named policies for the number of data shards k, with n = Num_Monitors and f = Mal
+const (
+	EC_F_PLUS_1   = "f+1"
+	EC_HALF       = "floor(n/2)"
+	EC_N_MINUS_2F = "n-2f"
+)
+
+// Erasure codes, Reed-Solomon being the default
+const (
+	EC_RS = "rs"
+	EC_LT = "lt" // systematic LT code, see lt.go
+)
+
+// ErasureCoder is an erasure code of k data and m parity shards.
+// The Reed-Solomon encoders of klauspost/reedsolomon satisfy it.
+type ErasureCoder interface {
+	// Encode fills the parity shards shards[k:] from the data shards shards[:k]
+	Encode(shards [][]byte) error
+	// ReconstructData rebuilds the missing data shards from the present shards, missing shards being empty.
+	// It returns an error if the present shards do not suffice.
+	ReconstructData(shards [][]byte) error
+}
+
+// ErasureParams are the erasure coding parameters of an update: each monitor gets one of the K data or M parity shards.
+// Loggers and CAs sign them in their STH or SRH, monitors decode with the parameters of the head.
+type ErasureParams struct {
+	K    int    `json:"k"`
+	M    int    `json:"m"`
+	Code string `json:"code,omitempty"` // empty for Reed-Solomon
+}
+
+// Check rejects parameters that do not give one shard to each of the n monitors, leave no redundancy,
+// or need more shares than the n-f honest monitors hold when f of them are malicious.
+// LT codes do not decode from any k shares, the honest monitors must hold at least 2k of them.
+func (p ErasureParams) Check(n int, f int) error {
+	if p.K < 1 || p.M < 1 {
+		return fmt.Errorf("Erasure coding needs at least one data and one parity shard, got k=%d m=%d", p.K, p.M)
+	}
+	if p.K+p.M != n {
+		return fmt.Errorf("Erasure coding with k=%d m=%d does not give one shard to each of the %d monitors", p.K, p.M, n)
+	}
+	if p.K > n-f {
+		return fmt.Errorf("Erasure coding with k=%d needs more shares than the %d honest monitors hold", p.K, n-f)
+	}
+	switch p.Code {
+	case "", EC_RS:
+		if n > 256 {
+			return fmt.Errorf("Reed-Solomon codes have at most 256 shards, not %d", n)
+		}
+	case EC_LT:
+		if 2*p.K > n-f {
+			return fmt.Errorf("LT codes with k=%d need %d shares, more than the %d honest monitors hold", p.K, 2*p.K, n-f)
+		}
+	default:
+		return fmt.Errorf("Unknown erasure code %q", p.Code)
+	}
+	return nil
+}
+
+// Coder returns the encoder of the parameters
+func (p ErasureParams) Coder() (ErasureCoder, error) {
+	switch p.Code {
+	case "", EC_RS:
+		return rs.New(p.K, p.M)
+	case EC_LT:
+		if p.K < 1 || p.M < 0 {
+			return nil, fmt.Errorf("Invalid LT code with k=%d m=%d", p.K, p.M)
+		}
+		return newLTCoder(p.K, p.M), nil
+	}
+	return nil, fmt.Errorf("Unknown erasure code %q", p.Code)
+}
+
+// HeadErasure returns the parameters carried in an STH or SRH, checked for n monitors, f of them malicious
+func HeadErasure(p *ErasureParams, n int, f int) (ErasureParams, error) {
+	if p == nil {
+		return ErasureParams{}, fmt.Errorf("No erasure coding parameters in the head")
+	}
+	return *p, p.Check(n, f)
+}
+
+// Reencode rebuilds the data shards from the present shards, missing shards being empty, encodes all the parity shards
+// again and returns the positions used. The result is a codeword even when the shards are not.
+// It fails when fewer than K shards are present, or when they are of different sizes or do not suffice to decode.
+func (p ErasureParams) Reencode(shards [][]byte) ([][]byte, []int, error) {
+	if len(shards) > p.K+p.M {
+		return nil, nil, fmt.Errorf("Got %d shards, expected at most %d", len(shards), p.K+p.M)
+	}
+	full := make([][]byte, p.K+p.M)
+	var used []int
+	for i, shard := range shards {
+		if len(shard) > 0 {
+			full[i] = append([]byte{}, shard...)
+			used = append(used, i)
+		}
+	}
+	if len(used) < p.K {
+		return nil, nil, fmt.Errorf("Only %d of the %d shards needed to decode", len(used), p.K)
+	}
+	enc, err := p.Coder()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := enc.ReconstructData(full); err != nil {
+		return nil, nil, err
+	}
+	for i := p.K; i < len(full); i++ {
+		full[i] = make([]byte, len(full[0]))
+	}
+	if err := enc.Encode(full); err != nil {
+		return nil, nil, err
+	}
+	return full, used, nil
+}
+
+// Decode returns the data shards rebuilt from the shards, concatenated.
+func (p ErasureParams) Decode(shards [][]byte) ([]byte, error) {
+	full, _, err := p.Reencode(shards)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, shard := range full[:p.K] {
+		data = append(data, shard...)
+	}
+	return data, nil
+}
+
+// ShareRoot returns Head_rs, the root of the Merkle tree over all the shards
+func ShareRoot(shards [][]byte) ([]byte, error) {
+	blocks := make([]merkletree.DataBlock, len(shards))
+	for i, shard := range shards {
+		blocks[i] = &LeafBlock{Content: shard}
+	}
+	tree, err := GenerateMerkleTree(blocks)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateRootHash(tree), nil
+}
+
+// CombinedHead returns the head a Logger signs in EEA mode, the root of the tree over [Head_rs, Head_cert]
+func CombinedHead(head_rs []byte, head_cert []byte) ([]byte, error) {
+	rootblocks := []merkletree.DataBlock{&LeafBlock{Content: head_rs}, &LeafBlock{Content: head_cert}}
+	tree, err := GenerateMerkleTree(rootblocks)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateRootHash(tree), nil
+}
+
+// CommitsTo tells if the STH signs Head_rs and Head_cert
+func (sth STH) CommitsTo(head_rs []byte, head_cert []byte) bool {
+	head, err := CombinedHead(head_rs, head_cert)
+	return err == nil && bytes.Equal(head, sth.Head)
+}
+
+// CommitsTo tells if the SRH signs Head_rs, the CA signs hcrv || hdcrv || Head_rs
+func (srh SRH) CommitsTo(head_rs []byte) bool {
+	return len(head_rs) > 0 && len(srh.Head) > len(head_rs) && bytes.Equal(srh.Head[len(srh.Head)-len(head_rs):], head_rs)
+}
+
+// GetErasureParams returns the erasure coding parameters of a Logger or CA from the settings.
+// Erasure_K, then Erasure_M, then Erasure_Policy set k. Without any of them Loggers use floor(n/2) and CAs f+1.
+func GetErasureParams(settings Settings, entity CTngID) (ErasureParams, error) {
+	n, f := settings.Num_Monitors, settings.Mal
+	var k int
+	switch {
+	case settings.Erasure_K > 0:
+		k = settings.Erasure_K
+	case settings.Erasure_M > 0:
+		k = n - settings.Erasure_M
+	case settings.Erasure_Policy == "" && settings.Erasure_Code == EC_LT:
+		// the largest k an LT code decodes with from the honest monitors
+		k = (n - f) / 2
+	default:
+		policy := settings.Erasure_Policy
+		if policy == "" {
+			policy = EC_HALF
+			if len(entity) > 0 && entity[0] == 'C' {
+				policy = EC_F_PLUS_1
+			}
+		}
+		switch policy {
+		case EC_F_PLUS_1:
+			k = f + 1
+		case EC_HALF:
+			k = n / 2
+		case EC_N_MINUS_2F:
+			k = n - 2*f
+		default:
+			return ErasureParams{}, fmt.Errorf("Unknown erasure coding policy %q", policy)
+		}
+	}
+	params := ErasureParams{K: k, M: n - k, Code: settings.Erasure_Code}
+	if settings.Erasure_M > 0 && settings.Erasure_M != params.M {
+		return params, fmt.Errorf("Erasure_K=%d and Erasure_M=%d do not add up to the %d monitors", settings.Erasure_K, settings.Erasure_M, n)
+	}
+	return params, params.Check(n, f)
+}
+
+// ValidateErasure checks the erasure coding parameters the settings give to the Loggers and CAs
+func ValidateErasure(settings Settings) error {
+	for _, entity := range []CTngID{"L", "C"} {
+		if _, err := GetErasureParams(settings, entity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package def
+
+import (
+	"fmt"
+)
+
+// Logger and CA behaviors, they act on the updates of the targeted monitors
+const FAULT_EQUIVOCATE = "equivocate"       // a second, validly signed head for the same period
+const FAULT_WITHHOLD = "withhold"           // no update at all
+const FAULT_CORRUPT_SHARE = "corrupt share" // a FileShare that does not match its PoI
+const FAULT_BAD_POI = "bad poi"             // the PoI of another FileShare
+const FAULT_BAD_ENCODING = "bad encoding"   // a FileShare committed in Head_rs, so its PoI verifies, that breaks the erasure code
+
+// Monitor behaviors, they act on the partial signatures the monitor sends to the targeted monitors
+const FAULT_DROP = "drop"                 // the partial signatures are never sent
+const FAULT_DELAY = "delay"               // the partial signatures are sent Delay milliseconds late
+const FAULT_BOGUS_SIG = "bogus signature" // the partial signatures are over another message
+
+// Fault makes an entity misbehave, so the detection time of the monitors can be measured
+type Fault struct {
+	Entity   CTngID   `json:"Entity"`
+	Behavior string   `json:"Behavior"`
+	Targets  []CTngID `json:"Targets,omitempty"` // Monitors receiving the faulty messages, empty for all of them
+	Periods  []int    `json:"Periods,omitempty"` // Periods the fault is active in, empty for all of them
+	Delay    int      `json:"Delay,omitempty"`   // In milliseconds, only used by FAULT_DELAY
+}
+
+// Active tells if the fault applies to the given period
+func (f Fault) Active(period int) bool {
+	if len(f.Periods) == 0 {
+		return true
+	}
+	for _, p := range f.Periods {
+		if p == period {
+			return true
+		}
+	}
+	return false
+}
+
+// Affects tells if the given monitor receives the faulty messages
+func (f Fault) Affects(id CTngID) bool {
+	if len(f.Targets) == 0 {
+		return true
+	}
+	for _, target := range f.Targets {
+		if target == id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFaults returns the faults configured for an entity
+func GetFaults(settings Settings, id CTngID) []Fault {
+	var faults []Fault
+	for _, fault := range settings.Faults {
+		if fault.Entity == id {
+			faults = append(faults, fault)
+		}
+	}
+	return faults
+}
+
+// ValidateFaults checks every behavior fits its entity and that at most Mal monitors misbehave
+func ValidateFaults(settings Settings) error {
+	faulty := make(map[CTngID]bool)
+	for _, fault := range settings.Faults {
+		if _, ok := settings.Ipmap[fault.Entity]; !ok || len(fault.Entity) == 0 {
+			return fmt.Errorf("fault on unknown entity %s", fault.Entity)
+		}
+		switch fault.Behavior {
+		case FAULT_EQUIVOCATE, FAULT_WITHHOLD, FAULT_CORRUPT_SHARE, FAULT_BAD_POI, FAULT_BAD_ENCODING:
+			if fault.Entity[0] != 'L' && fault.Entity[0] != 'C' {
+				return fmt.Errorf("%s is a Logger or CA behavior, not one of %s", fault.Behavior, fault.Entity)
+			}
+		case FAULT_DROP, FAULT_DELAY, FAULT_BOGUS_SIG:
+			if fault.Entity[0] != 'M' {
+				return fmt.Errorf("%s is a monitor behavior, not one of %s", fault.Behavior, fault.Entity)
+			}
+			faulty[fault.Entity] = true
+		default:
+			return fmt.Errorf("unknown behavior %q for %s", fault.Behavior, fault.Entity)
+		}
+		for _, target := range fault.Targets {
+			if len(target) == 0 || target[0] != 'M' {
+				return fmt.Errorf("fault target %s is not a monitor", target)
+			}
+		}
+	}
+	if len(faulty) > settings.Mal {
+		return fmt.Errorf("%d faulty monitors, at most Mal = %d are tolerated", len(faulty), settings.Mal)
+	}
+	return nil
+}
+
+// ForkHead derives a different head from a genuine one, for equivocating Loggers and CAs
+func ForkHead(head []byte) []byte {
+	fork, _ := GenerateSHA256(append(append([]byte{}, head...), []byte("fork")...))
+	return fork
+}
+
+// CorruptShare returns a copy of a FileShare with its first byte flipped
+func CorruptShare(share []byte) []byte {
+	corrupted := append([]byte{}, share...)
+	if len(corrupted) == 0 {
+		return []byte{0xff}
+	}
+	corrupted[0] ^= 0xff
+	return corrupted
+}
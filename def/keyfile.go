@@ -0,0 +1,225 @@
+package def
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	bls "github.com/herumi/bls-go-binary/bls"
+)
+
+// StoredKey holds the secrets of a single entity, kept in its own file next to the public config
+type StoredKey struct {
+	ID          CTngID
+	DSS_private *rsa.PrivateKey `json:",omitempty"` // Signing key of a Logger or CA, identity key of a Monitor
+	TSS_private []byte          `json:",omitempty"` // Serialized BLS share of a Monitor
+	TLS         *TLSCertificate `json:",omitempty"`
+	Signer      []byte          `json:",omitempty"` // PKCS #8 signing key of a Logger or CA not using rsa
+	DKG         *StoredDKG      `json:",omitempty"` // Threshold keys a Monitor generated with the others, they replace those of the public config
+}
+
+// StoredDKG holds the public side of the threshold keys generated by a DKG, the share itself is TSS_private
+type StoredDKG struct {
+	Threshold      int
+	TSS_public_map map[string][]byte
+	TSS_master_key []byte
+}
+
+// PublicCrypto copies the public keys and parameters of a crypto config, leaving out every private key.
+func PublicCrypto(c *GlobalCrypto) *GlobalCrypto {
+	return &GlobalCrypto{
+		Total:             c.Total,
+		Threshold:         c.Threshold,
+		DSS_public_map:    c.DSS_public_map,
+		TSS_public_map:    c.TSS_public_map,
+		TSS_master_key:    c.TSS_master_key,
+		DSS_Scheme:        c.DSS_Scheme,
+		TSS_Scheme:        c.TSS_Scheme,
+		HashScheme:        c.HashScheme,
+		TLS_CA:            c.TLS_CA,
+		DSS_scheme_map:    c.DSS_scheme_map,
+		Signer_public_map: c.Signer_public_map,
+	}
+}
+
+// KeyFile is where the secrets of an entity are stored, in the keys folder next to the public config
+func KeyFile(cryptofile string, id CTngID) string {
+	return filepath.Join(filepath.Dir(cryptofile), "keys", id.String()+".json")
+}
+
+// WriteKeyFiles splits a crypto config: the public config goes to cryptofile and every entity gets its own key file.
+// Key files are only readable by their owner, each entity is meant to receive its own file only.
+func WriteKeyFiles(c *GlobalCrypto, cryptofile string) error {
+	if err := WriteData(EncodeCrypto(PublicCrypto(c)), cryptofile); err != nil {
+		return err
+	}
+	keys := make(map[CTngID]*StoredKey)
+	key := func(id CTngID) *StoredKey {
+		if _, ok := keys[id]; !ok {
+			keys[id] = &StoredKey{ID: id}
+		}
+		return keys[id]
+	}
+	for id, sk := range c.DSS_private_map {
+		sk := sk
+		key(id).DSS_private = &sk
+	}
+	for id, sk := range c.TSS_private_map {
+		key(id).TSS_private = (&sk).Serialize()
+	}
+	for id, cert := range c.TLS_cert_map {
+		cert := cert
+		key(id).TLS = &cert
+	}
+	_, signers, err := encodeSignerKeys(nil, c.Signer_private_map)
+	if err != nil {
+		return err
+	}
+	for id, der := range signers {
+		key(id).Signer = der
+	}
+	if err := os.MkdirAll(filepath.Dir(KeyFile(cryptofile, "")), 0700); err != nil {
+		return err
+	}
+	for id, stored := range keys {
+		data, err := json.MarshalIndent(stored, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(KeyFile(cryptofile, id), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateKeyFiles generates the keys of every entity of the settings and writes them with WriteKeyFiles
+func GenerateKeyFiles(settings Settings, cryptofile string) error {
+	crypto := CTngKeyGenSchemes(settings.Num_Loggers, settings.Num_CAs, settings.Num_Monitors, settings.Mal+1, settings.DSS_Schemes)
+	return WriteKeyFiles(crypto, cryptofile)
+}
+
+// LoadCrypto loads the public config and the secrets of a single entity.
+// A config holding private keys is refused, every entity would hold the keys of all the others.
+func LoadCrypto(cryptofile string, id CTngID) (*GlobalCrypto, error) {
+	restoredcrypto := new(StoredCrypto)
+	LoadData(&restoredcrypto, cryptofile)
+	crypto, err := DecodeCrypto(restoredcrypto)
+	if err != nil {
+		return nil, err
+	}
+	if len(crypto.DSS_private_map) > 0 || len(crypto.TSS_private_map) > 0 || len(crypto.TLS_cert_map) > 0 || len(crypto.Signer_private_map) > 0 {
+		return nil, fmt.Errorf("%s holds private keys, split it into a public config and key files with Keygen", cryptofile)
+	}
+
+	content, err := os.ReadFile(KeyFile(cryptofile, id))
+	if err != nil {
+		return nil, err
+	}
+	stored := new(StoredKey)
+	if err := json.Unmarshal(content, stored); err != nil {
+		return nil, err
+	}
+	if stored.ID != id {
+		return nil, fmt.Errorf("Key file of %s holds the keys of %s", id, stored.ID)
+	}
+	crypto.DSS_private_map = make(RSAPrivateMap)
+	crypto.TSS_private_map = make(BlsPrivateMap)
+	crypto.TLS_cert_map = make(map[CTngID]TLSCertificate)
+	crypto.Signer_private_map = make(SignerPrivateMap)
+	if stored.DSS_private != nil {
+		crypto.DSS_private_map[id] = *stored.DSS_private
+	}
+	if stored.TSS_private != nil {
+		sk := new(bls.SecretKey)
+		if err := sk.Deserialize(stored.TSS_private); err != nil {
+			return nil, err
+		}
+		crypto.TSS_private_map[id] = *sk
+	}
+	if stored.TLS != nil {
+		crypto.TLS_cert_map[id] = *stored.TLS
+	}
+	if stored.Signer != nil {
+		sk, err := parseSignerKey(stored.Signer)
+		if err != nil {
+			return nil, err
+		}
+		crypto.Signer_private_map[id] = sk
+	}
+	return crypto, nil
+}
+
+// WriteThresholdKeys stores the threshold keys of a monitor in its key file, keeping the other secrets of the file,
+// so that a restarted monitor keeps the keys of its DKG.
+func WriteThresholdKeys(c *GlobalCrypto, keyfile string, id CTngID) error {
+	stored := &StoredKey{ID: id}
+	if content, err := os.ReadFile(keyfile); err == nil {
+		if err := json.Unmarshal(content, stored); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	sk, ok := c.TSS_private_map[id]
+	if !ok || c.TSS_master_key == nil {
+		return fmt.Errorf("No threshold keys for %s", id)
+	}
+	stored.TSS_private = (&sk).Serialize()
+	stored.DKG = &StoredDKG{
+		Threshold:      c.Threshold,
+		TSS_public_map: (&c.TSS_public_map).Serialize(),
+		TSS_master_key: c.TSS_master_key.Serialize(),
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyfile), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(keyfile, data, 0600)
+}
+
+// LoadThresholdKeys installs the threshold keys stored by WriteThresholdKeys, it returns false if the key file holds none
+func LoadThresholdKeys(c *GlobalCrypto, keyfile string, id CTngID) (bool, error) {
+	content, err := os.ReadFile(keyfile)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	stored := new(StoredKey)
+	if err := json.Unmarshal(content, stored); err != nil {
+		return false, err
+	}
+	if stored.DKG == nil || stored.TSS_private == nil {
+		return false, nil
+	}
+	if stored.ID != id {
+		return false, fmt.Errorf("Key file of %s holds the keys of %s", id, stored.ID)
+	}
+	sk := new(bls.SecretKey)
+	if err := sk.Deserialize(stored.TSS_private); err != nil {
+		return false, err
+	}
+	public := make(BlsPublicMap)
+	if err := (&public).Deserialize(stored.DKG.TSS_public_map); err != nil {
+		return false, err
+	}
+	master := new(bls.PublicKey)
+	if err := master.Deserialize(stored.DKG.TSS_master_key); err != nil {
+		return false, err
+	}
+	if c.TSS_private_map == nil {
+		c.TSS_private_map = make(BlsPrivateMap)
+	}
+	c.TSS_private_map[id] = *sk
+	c.TSS_public_map = public
+	c.TSS_master_key = master
+	c.Threshold = stored.DKG.Threshold
+	c.Total = len(public)
+	return true, nil
+}
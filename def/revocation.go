@@ -0,0 +1,63 @@
+package def
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/bits-and-blooms/bitset"
+)
+
+// The CRV of a CA is the bitset of every revoked serial index, the DCRV of a period holds the indices revoked in that period.
+// The CRV after period p is the union of the DCRVs of periods 1..p.
+
+// CRVHash hashes the words of a CRV with the trailing zero words dropped,
+// so the hash does not depend on the length the bitset happens to be allocated with.
+func CRVHash(crv *bitset.BitSet) []byte {
+	words := crv.Bytes()
+	end := len(words)
+	for end > 0 && words[end-1] == 0 {
+		end--
+	}
+	buf := make([]byte, 0, end*8)
+	for _, word := range words[:end] {
+		buf = binary.BigEndian.AppendUint64(buf, word)
+	}
+	hash, _ := GenerateSHA256(buf)
+	return hash
+}
+
+// CommitsToCRV tells if the SRH signs the CRV, the head of an SRH starting with the hcrv
+func (srh SRH) CommitsToCRV(crv *bitset.BitSet) bool {
+	hcrv := CRVHash(crv)
+	return len(srh.Head) >= len(hcrv) && bytes.Equal(srh.Head[:len(hcrv)], hcrv)
+}
+
+// CompressDCRV serializes and compresses a DCRV the way the CA distributes it
+func CompressDCRV(dcrv *bitset.BitSet) ([]byte, error) {
+	dcrv_bytes, err := dcrv.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return CompressData(dcrv_bytes)
+}
+
+// DecompressDCRV reverses CompressDCRV
+func DecompressDCRV(compressed []byte) (*bitset.BitSet, error) {
+	dcrv_bytes, err := DecompressData(compressed)
+	if err != nil {
+		return nil, err
+	}
+	dcrv := new(bitset.BitSet)
+	if err := dcrv.UnmarshalBinary(dcrv_bytes); err != nil {
+		return nil, err
+	}
+	return dcrv, nil
+}
+
+// ApplyDCRV returns the CRV after a period, prev is the CRV of the previous period (nil for the first period)
+func ApplyDCRV(prev *bitset.BitSet, dcrv *bitset.BitSet) *bitset.BitSet {
+	if prev == nil {
+		return dcrv.Clone()
+	}
+	return prev.Union(dcrv)
+}
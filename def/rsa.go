@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -49,7 +50,7 @@ func RSAVerify(msg []byte, signature RSASig, pub *rsa.PublicKey) error {
 	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash, signature.Sig)
 }
 
-// RSASig contains the ID of the signer and the rsa signature.
+// RSASig contains the ID of the signer and its signature, in the scheme of the signer (rsa unless the config says otherwise).
 type RSASig struct {
 	Sig []byte
 	ID  CTngID
@@ -76,3 +77,12 @@ func RSASigFromString(str string) (RSASig, error) {
 	sig.ID = CTngID(stringmap["id"])
 	return *sig, err
 }
+
+// RSAEncrypt encrypts a short message to the holder of the private key, the DKG sends the shares with it
+func RSAEncrypt(msg []byte, pub *rsa.PublicKey) ([]byte, error) {
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, msg, nil)
+}
+
+func RSADecrypt(ciphertext []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, ciphertext, nil)
+}
@@ -0,0 +1,195 @@
+package def
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Signature schemes of the Loggers and CAs.
+// Monitors keep RSA keys: the DKG encrypts the shares to them.
+const (
+	DSS_RSA     = "rsa"
+	DSS_ED25519 = "ed25519"
+	DSS_ECDSA   = "ecdsa" // ECDSA on P-256 with SHA256
+)
+
+// Signer is a digital signature scheme, its keys are those of the standard library.
+type Signer interface {
+	GenerateKey() (crypto.Signer, error)
+	Sign(msg []byte, sk crypto.Signer) ([]byte, error)
+	// Verify returns an error if the signature couldnt be verified.
+	Verify(msg []byte, sig []byte, pub crypto.PublicKey) error
+}
+
+type SignerPublicMap map[CTngID]crypto.PublicKey
+type SignerPrivateMap map[CTngID]crypto.Signer
+
+// Signers holds the supported schemes, keyed by the name used in the configs
+var Signers = map[string]Signer{
+	DSS_RSA:     rsaSigner{},
+	DSS_ED25519: ed25519Signer{},
+	DSS_ECDSA:   ecdsaSigner{},
+}
+
+type rsaSigner struct{}
+
+func (rsaSigner) GenerateKey() (crypto.Signer, error) {
+	return NewRSAPrivateKey()
+}
+
+func (rsaSigner) Sign(msg []byte, sk crypto.Signer) ([]byte, error) {
+	key, ok := sk.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Not an RSA private key")
+	}
+	sig, err := RSASign(msg, key, "")
+	return sig.Sig, err
+}
+
+func (rsaSigner) Verify(msg []byte, sig []byte, pub crypto.PublicKey) error {
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("Not an RSA public key")
+	}
+	return RSAVerify(msg, RSASig{Sig: sig}, key)
+}
+
+type ed25519Signer struct{}
+
+func (ed25519Signer) GenerateKey() (crypto.Signer, error) {
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	return sk, err
+}
+
+func (ed25519Signer) Sign(msg []byte, sk crypto.Signer) ([]byte, error) {
+	key, ok := sk.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("Not an Ed25519 private key")
+	}
+	return ed25519.Sign(key, msg), nil
+}
+
+func (ed25519Signer) Verify(msg []byte, sig []byte, pub crypto.PublicKey) error {
+	key, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("Not an Ed25519 public key")
+	}
+	if !ed25519.Verify(key, msg, sig) {
+		return errors.New("Ed25519 verification failed")
+	}
+	return nil
+}
+
+type ecdsaSigner struct{}
+
+func (ecdsaSigner) GenerateKey() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func (ecdsaSigner) Sign(msg []byte, sk crypto.Signer) ([]byte, error) {
+	key, ok := sk.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Not an ECDSA private key")
+	}
+	hash, err := GenerateSHA256(msg)
+	if err != nil {
+		return nil, err
+	}
+	return ecdsa.SignASN1(rand.Reader, key, hash)
+}
+
+func (ecdsaSigner) Verify(msg []byte, sig []byte, pub crypto.PublicKey) error {
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("Not an ECDSA public key")
+	}
+	hash, err := GenerateSHA256(msg)
+	if err != nil {
+		return err
+	}
+	if !ecdsa.VerifyASN1(key, hash, sig) {
+		return errors.New("ECDSA verification failed")
+	}
+	return nil
+}
+
+// SchemeOf returns the signature scheme of an entity, DSS_Scheme unless the entity picked another one
+func (c *GlobalCrypto) SchemeOf(id CTngID) string {
+	if scheme, ok := c.DSS_scheme_map[id]; ok {
+		return scheme
+	}
+	return c.DSS_Scheme
+}
+
+// ValidateSchemes checks the signature schemes picked in the settings: only Loggers and CAs may leave RSA.
+func ValidateSchemes(settings Settings) error {
+	for id, scheme := range settings.DSS_Schemes {
+		if _, ok := Signers[scheme]; !ok {
+			return fmt.Errorf("Unknown signature scheme %q for %s", scheme, id)
+		}
+		if len(id) == 0 || (id[0] != 'L' && id[0] != 'C') {
+			return fmt.Errorf("Only Loggers and CAs pick their signature scheme, not %q", id)
+		}
+	}
+	return nil
+}
+
+// Keys of the other schemes are stored in their PKIX and PKCS #8 encodings.
+func encodeSignerKeys(pubs SignerPublicMap, privs SignerPrivateMap) (map[CTngID][]byte, map[CTngID][]byte, error) {
+	storedPubs := make(map[CTngID][]byte, len(pubs))
+	for id, pub := range pubs {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, nil, err
+		}
+		storedPubs[id] = der
+	}
+	storedPrivs := make(map[CTngID][]byte, len(privs))
+	for id, sk := range privs {
+		der, err := x509.MarshalPKCS8PrivateKey(sk)
+		if err != nil {
+			return nil, nil, err
+		}
+		storedPrivs[id] = der
+	}
+	return storedPubs, storedPrivs, nil
+}
+
+func decodeSignerKeys(storedPubs map[CTngID][]byte, storedPrivs map[CTngID][]byte) (SignerPublicMap, SignerPrivateMap, error) {
+	pubs := make(SignerPublicMap, len(storedPubs))
+	for id, der := range storedPubs {
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, nil, err
+		}
+		pubs[id] = pub
+	}
+	privs := make(SignerPrivateMap, len(storedPrivs))
+	for id, der := range storedPrivs {
+		sk, err := parseSignerKey(der)
+		if err != nil {
+			return nil, nil, err
+		}
+		privs[id] = sk
+	}
+	return pubs, privs, nil
+}
+
+func parseSignerKey(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	sk, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("Stored key cannot sign")
+	}
+	return sk, nil
+}
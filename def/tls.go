@@ -0,0 +1,184 @@
+package def
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const PROTOCOL_TLS = "https://"
+
+// TLSCertificate is the PEM encoded certificate and private key an entity authenticates with.
+// The certificate is issued by the CTng root of the crypto config and its CommonName is the CTngID of the entity.
+type TLSCertificate struct {
+	Cert []byte
+	Key  []byte
+}
+
+// GetProtocol returns the scheme the entities of the settings talk to each other with
+func GetProtocol(settings Settings) string {
+	if settings.TLS {
+		return PROTOCOL_TLS
+	}
+	return PROTOCOL
+}
+
+func newTLSKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// GenerateTLSCertificates creates a CTng root and a client and server certificate for every entity.
+// Only the root certificate is returned, its key is discarded once the entity certificates are signed.
+func GenerateTLSCertificates(ids []CTngID) ([]byte, map[CTngID]TLSCertificate, error) {
+	rootKey, _, err := newTLSKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	root := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "CTng root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, root, root, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err = x509.ParseCertificate(rootDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certs := make(map[CTngID]TLSCertificate)
+	for _, id := range ids {
+		key, keyPEM, err := newTLSKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		serial, err := newSerial()
+		if err != nil {
+			return nil, nil, err
+		}
+		template := &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: id.String()},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.AddDate(10, 0, 0),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, root, &key.PublicKey, rootKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs[id] = TLSCertificate{
+			Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			Key:  keyPEM,
+		}
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}), certs, nil
+}
+
+// verifyPeer checks the certificate of the peer against the CTng root.
+// Entities are addressed by ip:port while their certificates name CTngIDs, so the host name is not checked,
+// the receivers map the certificate to the CTngID of the peer instead.
+func verifyPeer(roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		// only a server sees no certificate, PeerID then reports an unauthenticated peer
+		if len(cs.PeerCertificates) == 0 {
+			return nil
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		return err
+	}
+}
+
+func (c *GlobalCrypto) tlsRoots() (*x509.CertPool, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(c.TLS_CA) {
+		return nil, errors.New("No TLS root certificate in the crypto config")
+	}
+	return roots, nil
+}
+
+// TLSConfig is the client and server configuration of an entity.
+// Servers ask for a certificate without requiring one, relying parties query the monitors without any.
+func (c *GlobalCrypto) TLSConfig(id CTngID) (*tls.Config, error) {
+	pair, ok := c.TLS_cert_map[id]
+	if !ok {
+		return nil, fmt.Errorf("No TLS certificate for %s", id)
+	}
+	cert, err := tls.X509KeyPair(pair.Cert, pair.Key)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := c.tlsRoots()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		ClientAuth:         tls.VerifyClientCertIfGiven,
+		ClientCAs:          roots,
+		InsecureSkipVerify: true, // replaced by verifyPeer
+		VerifyConnection:   verifyPeer(roots),
+		MinVersion:         tls.VersionTLS12,
+	}, nil
+}
+
+// TLSClientConfig only trusts the CTng root, for relying parties without a certificate of their own
+func (c *GlobalCrypto) TLSClientConfig() (*tls.Config, error) {
+	roots, err := c.tlsRoots()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		InsecureSkipVerify: true, // replaced by verifyPeer
+		VerifyConnection:   verifyPeer(roots),
+		MinVersion:         tls.VersionTLS12,
+	}, nil
+}
+
+// PeerID returns the CTngID authenticated by a connection, empty if the peer sent no certificate
+func PeerID(state *tls.ConnectionState) CTngID {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return CTngID(state.PeerCertificates[0].Subject.CommonName)
+}
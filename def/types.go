@@ -1,6 +1,7 @@
 package def
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -45,33 +46,49 @@ type Context struct {
 }
 
 type Settings struct {
-	Ipmap                  map[CTngID]string `json:"Ipmap"`
-	Portmap                map[CTngID]string `json:"Portmap"`
-	Num_Monitors           int               `json:"Num_Monitors"`
-	Mal                    int               `json:"Mal"`
-	Update_Wait_time       int               `json:"Update_Wait_time"`
-	Mature_Wait_time       int               `json:"Mature_Wait_time"`
-	Response_Wait_time     int               `json:"Response_Wait_time "`
-	Verification_Wait_time int               `json:"Verification_Wait_time"`
-	MUD                    int               `json:"MUD"`
-	Distribution_Mode      string            `json:"Distribution_Mode"`
-	Broadcasting_Mode      string            `json:"Broadcasting_Mode"`
-	Num_CAs                int               `json:"Num_CAs"`
-	CRV_size               int               `json:"CRV_size"`
-	Revocation_ratio       float64           `json:"Revocation_ratio"`
-	Num_Loggers            int               `json:"Num_Loggers"`
-	Certificate_size       int               `json:"Certificate_size"`
-	Certificate_per_logger int               `json:"Certificate_per_logger"`
+	Ipmap                  map[CTngID]string   `json:"Ipmap"`
+	Portmap                map[CTngID]string   `json:"Portmap"`
+	Num_Monitors           int                 `json:"Num_Monitors"`
+	Mal                    int                 `json:"Mal"`
+	Update_Wait_time       int                 `json:"Update_Wait_time"`
+	Mature_Wait_time       int                 `json:"Mature_Wait_time"`
+	Response_Wait_time     int                 `json:"Response_Wait_time "`
+	Verification_Wait_time int                 `json:"Verification_Wait_time"`
+	MUD                    int                 `json:"MUD"`
+	Distribution_Mode      string              `json:"Distribution_Mode"`
+	Broadcasting_Mode      string              `json:"Broadcasting_Mode"`
+	Num_CAs                int                 `json:"Num_CAs"`
+	CRV_size               int                 `json:"CRV_size"`
+	Revocation_ratio       float64             `json:"Revocation_ratio"`
+	Num_Loggers            int                 `json:"Num_Loggers"`
+	Certificate_size       int                 `json:"Certificate_size"`
+	Certificate_per_logger int                 `json:"Certificate_per_logger"`
+	Num_Periods            int                 `json:"Num_Periods,omitempty"`    // 0 runs until the process is stopped
+	Storage_Dir            string              `json:"Storage_Dir,omitempty"`    // Monitors persist their state here, empty keeps it in memory only
+	Faults                 []Fault             `json:"Faults,omitempty"`         // Misbehaving entities, for simulations only
+	TLS                    bool                `json:"TLS,omitempty"`            // Entities authenticate each other with the certificates of the crypto config
+	DKG                    bool                `json:"DKG,omitempty"`            // Monitors generate their threshold keys jointly at startup, see the dkg package
+	Reshare                bool                `json:"Reshare,omitempty"`        // Monitors refresh their threshold shares jointly at startup under the threshold Mal+1, see dkg.NewResharing
+	DSS_Schemes            map[CTngID]string   `json:"DSS_Schemes,omitempty"`    // Signature scheme of a Logger or CA when not rsa, see def/signer.go
+	Erasure_Policy         string              `json:"Erasure_Policy,omitempty"` // Data shards of the updates: f+1, floor(n/2) or n-2f, see def/erasure.go
+	Erasure_K              int                 `json:"Erasure_K,omitempty"`      // Data shards, overrides the policy
+	Erasure_M              int                 `json:"Erasure_M,omitempty"`      // Parity shards, overrides the policy
+	Erasure_Code           string              `json:"Erasure_Code,omitempty"`   // Erasure code of the updates: rs (default) or lt
+	Issuers                map[CTngID][]CTngID `json:"Issuers,omitempty"`        // Entities allowed to issue and revoke certificates at a CA under TLS, besides the CA itself
+	Simulated_load         bool                `json:"Simulated_load,omitempty"` // CAs revoke random indices of a CRV of their own instead of issuing certificates, Loggers log dummy certificates when nothing was submitted, for experiments only
 }
 
 // Logger related
 type STH struct {
-	LID       string `json:"lid"`
-	PeriodNum int    `json:"period"`
-	Size      int    `json:"size"`
-	Timestamp string `json:"timestamp"` //Timestamp is a UTC RFC3339 string
-	Head      []byte `json:"head"`
-	Signature RSASig `json:"signature"`
+	LID       string         `json:"lid"`
+	PeriodNum int            `json:"period"`
+	Size      int            `json:"size"`
+	Timestamp string         `json:"timestamp"` //Timestamp is a UTC RFC3339 string
+	Head      []byte         `json:"head"`
+	Erasure   *ErasureParams `json:"erasure,omitempty"` // Encoding of the certificate file in EEA mode
+	FileLen   int            `json:"file_len"`          // Length of the certificate file, the shares are zero padded past it
+	ShardSize int            `json:"shard_size"`        // Length of every share of the certificate file
+	Signature RSASig         `json:"signature"`
 }
 
 type PoI struct {
@@ -79,11 +96,12 @@ type PoI struct {
 }
 
 type SRH struct {
-	CAID      string `json:"CAID"`
-	PeriodNum int    `json:"period"`
-	Head      []byte `json:"head,omitempty"`
-	Timestamp string `json:"timestamp"`
-	Signature RSASig `json:"signature"`
+	CAID      string         `json:"CAID"`
+	PeriodNum int            `json:"period"`
+	Head      []byte         `json:"head,omitempty"`
+	Timestamp string         `json:"timestamp"`
+	Erasure   *ErasureParams `json:"erasure,omitempty"` // Encoding of the DCRV in EEA mode
+	Signature RSASig         `json:"signature"`
 }
 
 type DCRV struct {
@@ -126,6 +144,7 @@ type Notification struct {
 	Type       string `json:"type"`
 	Originator CTngID `json:"originator"`
 	Monitor    CTngID `json:"monitor,omitempty"`
+	Period     int    `json:"period"`
 	Sender     string `json:"sender"` // identifies the sender URL for query
 }
 
@@ -136,11 +155,104 @@ type CPoM struct {
 	MetaData2        interface{}
 }
 
+// Proof that an entity committed in Head_rs to shares that are not a codeword of the erasure code signed in its head:
+// the K shares, each with its PoI under Head_rs, re-encode to another share tree.
+type BPoM struct {
+	Entity_Convicted CTngID
+	STH              *STH `json:",omitempty"` // Signed head of a Logger
+	SRH              *SRH `json:",omitempty"` // Signed head of a CA
+	Head_rs          []byte
+	Head_cert        []byte `json:",omitempty"` // Only for Loggers, the STH signs the tree over [Head_rs, Head_cert]
+	Shares           [][]byte
+	PoIs             []PoI
+}
+
 type APoM struct {
 	Entity_Convicted CTngID
+	Period           int
 	Signature        string //serialzied threshold signature
 }
 
+// Signed views served by the monitors to relying parties, the signature is a serialized ThresholdSig
+type SignedSTH struct {
+	STH       STH    `json:"sth"`
+	Signature string `json:"signature"`
+}
+
+type SignedSRH struct {
+	SRH       SRH    `json:"srh"`
+	Signature string `json:"signature"`
+}
+
+// Revocation status of a serial index, served by the monitors with the threshold-signed SRH as evidence
+type RevocationStatus struct {
+	SRH       SRH    `json:"srh"`
+	Signature string `json:"signature"` // Threshold signature of the monitors over the SRH
+	Index     int    `json:"index"`     // Serial index in the CRV
+	Revoked   bool   `json:"revoked"`   // Revoked as of the SRH period
+	CRV       []byte `json:"crv"`       // CRV of the SRH period compressed like a DCRV, its hash is the hcrv signed in the SRH
+}
+
+// Proof that a certificate is covered by an STH, served by the monitors and the Logger
+type InclusionProof struct {
+	STH       STH    `json:"sth"`
+	Index     int    `json:"index"` // Leaf index of the certificate
+	Head_cert []byte `json:"head_cert"`
+	Head_rs   []byte `json:"head_rs,omitempty"` // Only in EEA mode
+	CertPoI   PoI    `json:"cert_poi"`          // Certificate up to Head_cert
+	RootPoI   PoI    `json:"root_poi"`          // Head_cert up to STH.Head, only in EEA mode
+}
+
+// PoMs held by a monitor against one entity in one period
+type PoMRecord struct {
+	Entity_Convicted CTngID `json:"entity_convicted"`
+	Period           int    `json:"period"`
+	APoM             *APoM  `json:"apom,omitempty"`
+	CPoM             *CPoM  `json:"cpom,omitempty"`
+	BPoM             *BPoM  `json:"bpom,omitempty"`
+}
+
+// AccusationMessage is the message the monitors threshold sign to accuse an entity of withholding data in a period
+func AccusationMessage(id CTngID, period int) string {
+	return fmt.Sprintf("APoM:%s:%d", id, period)
+}
+
+// DecodeCPoM restores the metadata of a serialized CPoM as STHs (Logger) or SRHs (CA)
+func DecodeCPoM(data []byte) (CPoM, error) {
+	var raw struct {
+		Entity_Convicted CTngID
+		MetaData1        json.RawMessage
+		MetaData2        json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return CPoM{}, err
+	}
+	if len(raw.Entity_Convicted) == 0 {
+		return CPoM{}, fmt.Errorf("missing convicted entity")
+	}
+	switch raw.Entity_Convicted[0] {
+	case 'L':
+		var sth1, sth2 STH
+		if err := json.Unmarshal(raw.MetaData1, &sth1); err != nil {
+			return CPoM{}, err
+		}
+		if err := json.Unmarshal(raw.MetaData2, &sth2); err != nil {
+			return CPoM{}, err
+		}
+		return CPoM{Entity_Convicted: raw.Entity_Convicted, MetaData1: sth1, MetaData2: sth2}, nil
+	case 'C':
+		var srh1, srh2 SRH
+		if err := json.Unmarshal(raw.MetaData1, &srh1); err != nil {
+			return CPoM{}, err
+		}
+		if err := json.Unmarshal(raw.MetaData2, &srh2); err != nil {
+			return CPoM{}, err
+		}
+		return CPoM{Entity_Convicted: raw.Entity_Convicted, MetaData1: srh1, MetaData2: srh2}, nil
+	}
+	return CPoM{}, fmt.Errorf("invalid convicted entity: %s", raw.Entity_Convicted)
+}
+
 func Generate_IP_Json_template(num_ca int, num_logger int, num_monitor int, mal int, ca_mask string, ca_offset int, logger_mask string, logger_offset int, monitor_mask string, monitor_offset int, starting_port int, update_wait_time int, mature_wait_time int, response_wait_time int, verification_wait_time int, mud int, dmode string, bmode string, crvsize int, revocation_ratio float64, certificate_size int, certificate_per_logger int) *Settings {
 	ipmap := make(map[CTngID]string)
 	portmap := make(map[CTngID]string)
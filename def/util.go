@@ -29,12 +29,6 @@ func (e *OutOfBounds) Error() string {
 	return "Index Out of Bounds"
 }
 
-func RemovePadding(data []byte) []byte {
-	// Remove zero padding from the end of data slices
-	// This is safe under the assumption that original data does not end with zero bytes
-	return bytes.TrimRight(data, "\x00")
-}
-
 func HandleError(err error, functionName string) {
 	if err != nil {
 		pc, fn, line, _ := runtime.Caller(1)
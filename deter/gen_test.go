@@ -3,12 +3,20 @@ package deter
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
 	"testing"
 
 	def "github.com/jik18001/CTngV3/def"
 )
 
+// out is where the config, key files and settings go, go test -out . replaces those of the experiments
+var out = flag.String("out", "", "Directory of the generated files, a temporary one if empty")
+
 func TestSimulationIO(t *testing.T) {
+	dir := *out
+	if dir == "" {
+		dir = t.TempDir()
+	}
 	num_monitors := 32
 	Mal := 3
 	num_loggers := 8
@@ -16,11 +24,8 @@ func TestSimulationIO(t *testing.T) {
 	// Generate a new configuration using CTngKeyGen function with specified parameters.
 	newconfig := def.CTngKeyGen(num_loggers, num_cas, num_monitors, Mal+1)
 
-	// Encode the new configuration into a storable format.
-	storedconfig := def.EncodeCrypto(newconfig)
-
-	// Write the encoded configuration to a file and handle any errors.
-	err := def.WriteData(storedconfig, "deterconfig.json")
+	// Write the public configuration and one key file per entity, and handle any errors.
+	err := def.WriteKeyFiles(newconfig, filepath.Join(dir, "deterconfig.json"))
 	if err != nil {
 		t.Fatalf("Failed to write data: %v", err)
 	}
@@ -69,8 +74,11 @@ func TestSimulationIO(t *testing.T) {
 		*mud, *dmode, *bmode, *crvsize, *revocation_ratio, *certificate_size, *certificate_per_logger,
 	)
 
+	// The experiments measure the load of random revocations
+	settings.Simulated_load = true
+
 	// Write the generated settings to a file and handle any errors.
-	err = def.WriteData(settings, "detersettings.json")
+	err = def.WriteData(settings, filepath.Join(dir, "detersettings.json"))
 	if err != nil {
 		t.Fatalf("Failed to write data: %v", err)
 	}
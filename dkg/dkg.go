@@ -0,0 +1,669 @@
+// Package dkg lets the monitors generate their BLS threshold key without a trusted dealer.
+// It runs the joint-Feldman protocol: every monitor deals a random polynomial, the shares it hands out
+// are checked against its public commitments, and the final share of a monitor is the sum of the shares
+// dealt by the qualified dealers. No party ever holds the master secret.
+//
+// The protocol assumes messages are delivered within a Phase. It runs in four phases after the start:
+//  1. every monitor broadcasts its signed deal, the shares encrypted to each monitor's identity key;
+//  2. a monitor that got no deal, or a share not matching the commitments, broadcasts a complaint;
+//  3. a dealer answers every complaint by revealing the disputed share along with its deal;
+//  4. every monitor broadcasts the qualified dealers and the group key it derived, signed.
+//
+// Dealers with an unanswered complaint, or that revealed a share not matching their commitments, are disqualified.
+// Complaints and responses are sent point to point, so a faulty monitor may complain to some monitors only and
+// leave them with other qualified dealers: a monitor aborts when another one confirms a different outcome.
+//
+// The same phases reshare an existing key, see NewResharing.
+package dkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bls "github.com/herumi/bls-go-binary/bls"
+	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+// A dealing: the Feldman commitments of a polynomial and its evaluation at every monitor, encrypted to the monitor
+type Deal struct {
+	Dealer      def.CTngID
+	Commitments [][]byte              // Serialized coefficients of the polynomial in G2, the first one is the dealer's part of the group key
+	Shares      map[def.CTngID][]byte // Encrypted share of every monitor
+	Signature   def.RSASig
+}
+
+// A monitor accusing a dealer of a missing or invalid share
+type Complaint struct {
+	Complainer def.CTngID
+	Dealer     def.CTngID
+	Signature  def.RSASig
+}
+
+// The answer of a dealer to a complaint, the deal lets monitors that missed it check the revealed share
+type Response struct {
+	Deal      Deal
+	Target    def.CTngID
+	Share     []byte // Serialized share of the Target, in clear
+	Signature def.RSASig
+}
+
+// The outcome a monitor derived, every monitor checks the others derived the same
+type Confirmation struct {
+	Monitor   def.CTngID
+	Qualified []def.CTngID
+	GroupKey  []byte // Serialized group public key
+	Signature def.RSASig
+}
+
+// Result of the protocol, the same for every honest monitor but the Share
+type Result struct {
+	Share     bls.SecretKey    // Private share of this monitor
+	Public    def.BlsPublicMap // Public share of every monitor
+	GroupKey  bls.PublicKey    // Public key of the threshold signatures
+	Qualified []def.CTngID     // Dealers whose polynomials were combined
+	Threshold int
+}
+
+// Apply installs the result in a crypto config, the share of a monitor leaving the set is erased
+func (r *Result) Apply(c *def.GlobalCrypto, id def.CTngID) {
+	c.TSS_public_map = r.Public
+	c.TSS_master_key = &r.GroupKey
+	c.Threshold = r.Threshold
+	c.Total = len(r.Public)
+	if c.TSS_private_map == nil {
+		c.TSS_private_map = make(def.BlsPrivateMap)
+	}
+	if _, ok := r.Public[id]; ok {
+		c.TSS_private_map[id] = r.Share
+	} else {
+		delete(c.TSS_private_map, id)
+	}
+}
+
+type Participant struct {
+	ID        def.CTngID
+	Dealers   []def.CTngID
+	Receivers []def.CTngID // Monitors getting a share of the key
+	Threshold int
+	Mal       int               // Faulty monitors tolerated, a key generation needs all the other dealers qualified
+	Crypto    *def.GlobalCrypto // Identity keys, they sign the messages and encrypt the shares
+	Addresses map[def.CTngID]string
+	Transport transport.Transport
+	Clock     def.Clock
+	Phase     time.Duration
+
+	// resharing only: the shared key, the dealers deal their share of it
+	previous          def.BlsPublicMap
+	previousThreshold int
+	secret            *bls.SecretKey
+
+	lock       sync.Mutex
+	poly       []bls.SecretKey
+	deals      map[def.CTngID]Deal
+	shares     map[def.CTngID]bls.SecretKey       // Verified shares dealt to this monitor
+	complaints map[def.CTngID]map[def.CTngID]bool // Complainers of every dealer
+	resolved   map[def.CTngID]map[def.CTngID]bool // Complaints answered with a valid share
+	bad        map[def.CTngID]bool                // Dealers caught misbehaving
+	done       bool
+	result     *Result
+	resultErr  error
+	confirmed  []Confirmation // Outcomes confirmed by the other monitors
+
+	// misbehaviors, for tests only
+	tamper func(*Deal)
+	silent bool
+}
+
+// NewParticipant makes a monitor take part in the key generation of every monitor of the settings.
+// The threshold is the one of the crypto config, which needs the identity keys of every monitor.
+func NewParticipant(id def.CTngID, crypto *def.GlobalCrypto, settings *def.Settings, t transport.Transport, clock def.Clock) *Participant {
+	addresses := def.GetMonitorURL(*settings)
+	monitors := sortedIDs(addresses)
+	return newParticipant(id, crypto, monitors, monitors, crypto.Threshold, addresses, settings, t, clock)
+}
+
+func sortedIDs(addresses map[def.CTngID]string) []def.CTngID {
+	ids := make([]def.CTngID, 0, len(addresses))
+	for id := range addresses {
+		ids = append(ids, id)
+	}
+	sort.Sort(def.CTngIDs(ids))
+	return ids
+}
+
+func newParticipant(id def.CTngID, crypto *def.GlobalCrypto, dealers []def.CTngID, receivers []def.CTngID, threshold int, addresses map[def.CTngID]string, settings *def.Settings, t transport.Transport, clock def.Clock) *Participant {
+	p := &Participant{
+		ID:         id,
+		Dealers:    dealers,
+		Receivers:  receivers,
+		Threshold:  threshold,
+		Mal:        settings.Mal,
+		Crypto:     crypto,
+		Addresses:  addresses,
+		Transport:  t,
+		Clock:      clock,
+		Phase:      time.Duration(settings.Response_Wait_time) * time.Second,
+		deals:      make(map[def.CTngID]Deal),
+		shares:     make(map[def.CTngID]bls.SecretKey),
+		complaints: make(map[def.CTngID]map[def.CTngID]bool),
+		resolved:   make(map[def.CTngID]map[def.CTngID]bool),
+		bad:        make(map[def.CTngID]bool),
+	}
+	t.Register("/monitor/dkg_deal", p.bind(p.handleDeal))
+	t.Register("/monitor/dkg_complaint", p.bind(p.handleComplaint))
+	t.Register("/monitor/dkg_response", p.bind(p.handleResponse))
+	t.Register("/monitor/dkg_confirmation", p.bind(p.handleConfirmation))
+	return p
+}
+
+func (p *Participant) bind(fn func(from def.CTngID, data []byte) error) transport.Handler {
+	return func(from def.CTngID, data []byte) error {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return fn(from, data)
+	}
+}
+
+func contains(ids []def.CTngID, id def.CTngID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcast sends to the other monitors, the caller handles its own copy
+func (p *Participant) broadcast(endpoint string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println(def.RED+"Failed to marshal DKG message:", err, def.RESET)
+		return
+	}
+	addresses := make([]string, 0, len(p.Addresses))
+	for id, addr := range p.Addresses {
+		if id != p.ID {
+			addresses = append(addresses, addr)
+		}
+	}
+	p.Transport.Broadcast(addresses, endpoint, data)
+}
+
+// sign covers the JSON of a message without its signature, as for STHs and SRHs
+func (p *Participant) sign(v interface{}) (def.RSASig, error) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return def.RSASig{}, err
+	}
+	return p.Crypto.Sign(msg, p.ID)
+}
+
+func (p *Participant) verify(v interface{}, sig def.RSASig, signer def.CTngID) error {
+	if sig.ID != signer {
+		return fmt.Errorf("Not signed by %s", signer)
+	}
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.Crypto.Verify(msg, sig)
+}
+
+// Start deals the polynomial of this monitor and schedules the phases, done is called once the key is generated.
+// A dealer whose own deal is invalid, a resharing dealer holding a wrong share, only takes part as a receiver.
+func (p *Participant) Start(done func(*Result, error)) {
+	if contains(p.Dealers, p.ID) {
+		deal, err := p.deal()
+		if err != nil {
+			done(nil, err)
+			return
+		}
+		if err := p.verifyDeal(deal); err != nil {
+			fmt.Println(def.RED+"DKG:", p.ID, "does not deal:", err, def.RESET)
+		} else {
+			p.lock.Lock()
+			p.acceptDeal(deal)
+			p.lock.Unlock()
+			p.broadcast("/monitor/dkg_deal", deal)
+		}
+	}
+	p.Clock.AfterFunc(p.Phase, p.complain)
+	p.Clock.AfterFunc(2*p.Phase, p.respond)
+	p.Clock.AfterFunc(3*p.Phase, p.confirm)
+	p.Clock.AfterFunc(4*p.Phase, func() {
+		result, err := p.conclude()
+		done(result, err)
+	})
+}
+
+func (p *Participant) deal() (Deal, error) {
+	secret := p.secret
+	if p.previous != nil && secret == nil {
+		return Deal{}, fmt.Errorf("%s holds no share to reshare", p.ID)
+	}
+	if secret == nil {
+		secret = new(bls.SecretKey)
+		secret.SetByCSPRNG()
+	}
+	p.poly = secret.GetMasterSecretKey(p.Threshold)
+	deal := Deal{Dealer: p.ID, Shares: make(map[def.CTngID][]byte)}
+	for _, commitment := range bls.GetMasterPublicKey(p.poly) {
+		deal.Commitments = append(deal.Commitments, commitment.Serialize())
+	}
+	for _, id := range p.Receivers {
+		share, err := p.shareOf(id)
+		if err != nil {
+			return Deal{}, err
+		}
+		pub, ok := p.Crypto.DSS_public_map[id]
+		if !ok {
+			return Deal{}, fmt.Errorf("No identity key for %s", id)
+		}
+		deal.Shares[id], err = def.RSAEncrypt(share.Serialize(), &pub)
+		if err != nil {
+			return Deal{}, err
+		}
+	}
+	if p.tamper != nil {
+		p.tamper(&deal)
+	}
+	var err error
+	deal.Signature, err = p.sign(deal)
+	return deal, err
+}
+
+// shareOf evaluates the polynomial of this monitor at another one
+func (p *Participant) shareOf(id def.CTngID) (*bls.SecretKey, error) {
+	share := new(bls.SecretKey)
+	err := share.Set(p.poly, id.BlsID())
+	return share, err
+}
+
+// checkShare verifies a share against the commitments of its deal
+func checkShare(deal Deal, id def.CTngID, share *bls.SecretKey) error {
+	commitments, err := deserializeCommitments(deal.Commitments)
+	if err != nil {
+		return err
+	}
+	expected := new(bls.PublicKey)
+	if err := expected.Set(commitments, id.BlsID()); err != nil {
+		return err
+	}
+	if !share.GetPublicKey().IsEqual(expected) {
+		return fmt.Errorf("Share of %s does not match the commitments of %s", id, deal.Dealer)
+	}
+	return nil
+}
+
+func deserializeCommitments(serialized [][]byte) ([]bls.PublicKey, error) {
+	commitments := make([]bls.PublicKey, len(serialized))
+	for i := range serialized {
+		if err := commitments[i].Deserialize(serialized[i]); err != nil {
+			return nil, err
+		}
+	}
+	return commitments, nil
+}
+
+func (p *Participant) verifyDeal(deal Deal) error {
+	if !contains(p.Dealers, deal.Dealer) {
+		return fmt.Errorf("Deal from %s, not a dealer", deal.Dealer)
+	}
+	if len(deal.Commitments) != p.Threshold {
+		return fmt.Errorf("Deal of %s commits to %d coefficients instead of %d", deal.Dealer, len(deal.Commitments), p.Threshold)
+	}
+	// a resharing dealer deals its own share, which keeps the group key
+	if p.previous != nil {
+		previous, ok := p.previous[deal.Dealer]
+		commitment := new(bls.PublicKey)
+		if !ok || commitment.Deserialize(deal.Commitments[0]) != nil || !commitment.IsEqual(&previous) {
+			return fmt.Errorf("Deal of %s does not reshare its share", deal.Dealer)
+		}
+	}
+	unsigned := deal
+	unsigned.Signature = def.RSASig{}
+	return p.verify(unsigned, deal.Signature, deal.Dealer)
+}
+
+// acceptDeal keeps the first valid deal of every dealer and decrypts the share of this monitor.
+// It must be called with the lock held.
+func (p *Participant) acceptDeal(deal Deal) {
+	if _, ok := p.deals[deal.Dealer]; ok {
+		return
+	}
+	p.deals[deal.Dealer] = deal
+	if _, ok := p.shares[deal.Dealer]; ok || !contains(p.Receivers, p.ID) {
+		return
+	}
+	sk, ok := p.Crypto.DSS_private_map[p.ID]
+	if !ok {
+		return
+	}
+	plaintext, err := def.RSADecrypt(deal.Shares[p.ID], &sk)
+	if err != nil {
+		return
+	}
+	share := new(bls.SecretKey)
+	if share.Deserialize(plaintext) != nil || checkShare(deal, p.ID, share) != nil {
+		return
+	}
+	p.shares[deal.Dealer] = *share
+}
+
+func (p *Participant) handleDeal(from def.CTngID, data []byte) error {
+	var deal Deal
+	if err := json.Unmarshal(data, &deal); err != nil {
+		return errors.New("Failed to decode deal")
+	}
+	if from != "" && from != deal.Dealer {
+		return fmt.Errorf("%s forwarded the deal of %s", from, deal.Dealer)
+	}
+	if err := p.verifyDeal(deal); err != nil {
+		return err
+	}
+	p.acceptDeal(deal)
+	return nil
+}
+
+// complain accuses every dealer this monitor holds no valid share of
+func (p *Participant) complain() {
+	p.lock.Lock()
+	var complaints []Complaint
+	if !contains(p.Receivers, p.ID) {
+		p.lock.Unlock()
+		return
+	}
+	for _, dealer := range p.Dealers {
+		if _, ok := p.shares[dealer]; ok {
+			continue
+		}
+		complaint := Complaint{Complainer: p.ID, Dealer: dealer}
+		sig, err := p.sign(complaint)
+		if err != nil {
+			continue
+		}
+		complaint.Signature = sig
+		p.addComplaint(complaint)
+		complaints = append(complaints, complaint)
+	}
+	p.lock.Unlock()
+	for _, complaint := range complaints {
+		fmt.Println(def.RED+"DKG:", p.ID, "complains about", complaint.Dealer, def.RESET)
+		p.broadcast("/monitor/dkg_complaint", complaint)
+	}
+}
+
+func (p *Participant) addComplaint(complaint Complaint) {
+	if p.complaints[complaint.Dealer] == nil {
+		p.complaints[complaint.Dealer] = make(map[def.CTngID]bool)
+	}
+	p.complaints[complaint.Dealer][complaint.Complainer] = true
+}
+
+func (p *Participant) handleComplaint(from def.CTngID, data []byte) error {
+	var complaint Complaint
+	if err := json.Unmarshal(data, &complaint); err != nil {
+		return errors.New("Failed to decode complaint")
+	}
+	if from != "" && from != complaint.Complainer {
+		return fmt.Errorf("%s forwarded the complaint of %s", from, complaint.Complainer)
+	}
+	if !contains(p.Receivers, complaint.Complainer) || !contains(p.Dealers, complaint.Dealer) {
+		return errors.New("Complaint from a monitor not receiving or against a monitor not dealing")
+	}
+	unsigned := complaint
+	unsigned.Signature = def.RSASig{}
+	if err := p.verify(unsigned, complaint.Signature, complaint.Complainer); err != nil {
+		return err
+	}
+	p.addComplaint(complaint)
+	return nil
+}
+
+// respond reveals the share of every monitor complaining about this one
+func (p *Participant) respond() {
+	p.lock.Lock()
+	var responses []Response
+	if !p.silent {
+		for complainer := range p.complaints[p.ID] {
+			share, err := p.shareOf(complainer)
+			if err != nil {
+				continue
+			}
+			response := Response{Deal: p.deals[p.ID], Target: complainer, Share: share.Serialize()}
+			sig, err := p.sign(response)
+			if err != nil {
+				continue
+			}
+			response.Signature = sig
+			p.acceptResponse(response)
+			responses = append(responses, response)
+		}
+	}
+	p.lock.Unlock()
+	for _, response := range responses {
+		p.broadcast("/monitor/dkg_response", response)
+	}
+}
+
+// acceptResponse resolves a complaint with a valid share, or disqualifies the dealer.
+// It must be called with the lock held, on a response whose signatures were checked.
+func (p *Participant) acceptResponse(response Response) {
+	dealer := response.Deal.Dealer
+	share := new(bls.SecretKey)
+	if share.Deserialize(response.Share) != nil || checkShare(response.Deal, response.Target, share) != nil {
+		p.bad[dealer] = true
+		return
+	}
+	p.acceptDeal(response.Deal)
+	if p.resolved[dealer] == nil {
+		p.resolved[dealer] = make(map[def.CTngID]bool)
+	}
+	p.resolved[dealer][response.Target] = true
+	if response.Target == p.ID {
+		p.shares[dealer] = *share
+	}
+}
+
+func (p *Participant) handleResponse(from def.CTngID, data []byte) error {
+	var response Response
+	if err := json.Unmarshal(data, &response); err != nil {
+		return errors.New("Failed to decode response")
+	}
+	if from != "" && from != response.Deal.Dealer {
+		return fmt.Errorf("%s forwarded the response of %s", from, response.Deal.Dealer)
+	}
+	if err := p.verifyDeal(response.Deal); err != nil {
+		return err
+	}
+	// a dealer with two deals equivocated
+	if deal, ok := p.deals[response.Deal.Dealer]; ok && !bytes.Equal(deal.Signature.Sig, response.Deal.Signature.Sig) {
+		p.bad[response.Deal.Dealer] = true
+		return errors.New("Response with another deal")
+	}
+	unsigned := response
+	unsigned.Signature = def.RSASig{}
+	if err := p.verify(unsigned, response.Signature, response.Deal.Dealer); err != nil {
+		return err
+	}
+	p.acceptResponse(response)
+	return nil
+}
+
+// qualified lists the dealers with a deal, no misbehavior and every complaint answered.
+// It must be called with the lock held.
+func (p *Participant) qualified() []def.CTngID {
+	var qual []def.CTngID
+	for _, dealer := range p.Dealers {
+		if _, ok := p.deals[dealer]; !ok || p.bad[dealer] {
+			continue
+		}
+		answered := true
+		for complainer := range p.complaints[dealer] {
+			if !p.resolved[dealer][complainer] {
+				answered = false
+			}
+		}
+		if answered {
+			qual = append(qual, dealer)
+		}
+	}
+	return qual
+}
+
+// confirm derives the key and broadcasts the outcome for the other monitors to compare
+func (p *Participant) confirm() {
+	result, err := p.finish()
+	p.lock.Lock()
+	p.result, p.resultErr = result, err
+	if err != nil {
+		p.lock.Unlock()
+		return
+	}
+	confirmation := Confirmation{Monitor: p.ID, Qualified: result.Qualified, GroupKey: result.GroupKey.Serialize()}
+	confirmation.Signature, err = p.sign(confirmation)
+	p.lock.Unlock()
+	if err != nil {
+		fmt.Println(def.RED+"Failed to sign DKG confirmation:", err, def.RESET)
+		return
+	}
+	p.broadcast("/monitor/dkg_confirmation", confirmation)
+}
+
+func (p *Participant) handleConfirmation(from def.CTngID, data []byte) error {
+	var confirmation Confirmation
+	if err := json.Unmarshal(data, &confirmation); err != nil {
+		return errors.New("Failed to decode confirmation")
+	}
+	if from != "" && from != confirmation.Monitor {
+		return fmt.Errorf("%s forwarded the confirmation of %s", from, confirmation.Monitor)
+	}
+	if _, ok := p.Addresses[confirmation.Monitor]; !ok {
+		return errors.New("Confirmation from a monitor not taking part")
+	}
+	unsigned := confirmation
+	unsigned.Signature = def.RSASig{}
+	if err := p.verify(unsigned, confirmation.Signature, confirmation.Monitor); err != nil {
+		return err
+	}
+	p.confirmed = append(p.confirmed, confirmation)
+	return nil
+}
+
+// conclude returns the key derived by this monitor, unless another monitor confirmed a different outcome
+func (p *Participant) conclude() (*Result, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.resultErr != nil {
+		return nil, p.resultErr
+	}
+	groupKey := p.result.GroupKey.Serialize()
+	for _, confirmation := range p.confirmed {
+		same := bytes.Equal(confirmation.GroupKey, groupKey) && len(confirmation.Qualified) == len(p.result.Qualified)
+		for i := 0; same && i < len(confirmation.Qualified); i++ {
+			same = confirmation.Qualified[i] == p.result.Qualified[i]
+		}
+		if !same {
+			return nil, fmt.Errorf("%s derived the qualified dealers %v, not %v: DKG aborted", confirmation.Monitor, confirmation.Qualified, p.result.Qualified)
+		}
+	}
+	return p.result, nil
+}
+
+func (p *Participant) finish() (*Result, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.done {
+		return nil, errors.New("DKG already finished")
+	}
+	p.done = true
+	qual := p.qualified()
+	// a key generation needs every dealer but the Mal faulty ones, and at least a threshold of them,
+	// a resharing needs enough shares of the previous key to interpolate it
+	required := len(p.Dealers) - p.Mal
+	if required < p.Threshold {
+		required = p.Threshold
+	}
+	if p.previous != nil {
+		required = p.previousThreshold
+	}
+	if len(qual) < required {
+		return nil, fmt.Errorf("%d qualified dealers, %d needed", len(qual), required)
+	}
+	receiving := contains(p.Receivers, p.ID)
+	ids := make([]bls.ID, len(qual))
+	shares := make([]bls.SecretKey, 0, len(qual))
+	constants := make([]bls.PublicKey, len(qual))
+	public := make(map[def.CTngID][]bls.PublicKey)
+	for i, dealer := range qual {
+		ids[i] = *dealer.BlsID()
+		if receiving {
+			share, ok := p.shares[dealer]
+			if !ok {
+				return nil, fmt.Errorf("No valid share from the qualified dealer %s", dealer)
+			}
+			shares = append(shares, share)
+		}
+		commitments, err := deserializeCommitments(p.deals[dealer].Commitments)
+		if err != nil {
+			return nil, err
+		}
+		constants[i] = commitments[0]
+		for _, id := range p.Receivers {
+			pub := new(bls.PublicKey)
+			if err := pub.Set(commitments, id.BlsID()); err != nil {
+				return nil, err
+			}
+			public[id] = append(public[id], *pub)
+		}
+	}
+
+	result := &Result{Public: make(def.BlsPublicMap), Qualified: qual, Threshold: p.Threshold}
+	if receiving {
+		if err := p.combineSecret(&result.Share, shares, ids); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.combinePublic(&result.GroupKey, constants, ids); err != nil {
+		return nil, err
+	}
+	for id, parts := range public {
+		var pub bls.PublicKey
+		if err := p.combinePublic(&pub, parts, ids); err != nil {
+			return nil, err
+		}
+		result.Public[id] = pub
+	}
+	fmt.Println(def.BLUE+"DKG:", p.ID, "derived its share, qualified dealers", qual, def.RESET)
+	return result, nil
+}
+
+// combineSecret adds up the shares of a key generation, and interpolates those of a resharing at 0
+func (p *Participant) combineSecret(sum *bls.SecretKey, parts []bls.SecretKey, ids []bls.ID) error {
+	if p.previous != nil {
+		return sum.Recover(parts, ids)
+	}
+	*sum = parts[0]
+	for _, part := range parts[1:] {
+		sum.Add(&part)
+	}
+	return nil
+}
+
+// combinePublic matches combineSecret on the public side
+func (p *Participant) combinePublic(sum *bls.PublicKey, parts []bls.PublicKey, ids []bls.ID) error {
+	if p.previous != nil {
+		return sum.Recover(parts, ids)
+	}
+	*sum = parts[0]
+	for _, part := range parts[1:] {
+		sum.Add(&part)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package dkg
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	bls "github.com/herumi/bls-go-binary/bls"
+	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+type outcome struct {
+	result *Result
+	err    error
+}
+
+// identity keys of the monitors, shared by the runs
+var crypto = def.CTngKeyGen(2, 2, 5, 3)
+
+// monitors returns settings with the monitors M1 to M5 but the excluded ones
+func monitors(excluded ...def.CTngID) *def.Settings {
+	settings := def.Generate_IP_Json_template(2, 2, 5, 2, "127.0.0.", 10, "127.0.1.", 20, "127.0.2.", 30, 8000, 5, 0, 6, 10, 30, def.EEA, def.MIN_WT, 10000, 0.002, 2000, 20)
+	for _, id := range excluded {
+		delete(settings.Ipmap, id)
+		delete(settings.Portmap, id)
+	}
+	return settings
+}
+
+// run generates the key of M1 to M4 over an in-memory network, setup may make some monitors misbehave
+func run(t *testing.T, setup func(map[def.CTngID]*Participant)) map[def.CTngID]outcome {
+	settings := monitors("M5")
+	network := transport.NewNetwork()
+	participants := make(map[def.CTngID]*Participant)
+	for id, addr := range def.GetMonitorURL(*settings) {
+		participants[id] = NewParticipant(id, crypto, settings, network.Transport(addr), def.RealClock{})
+	}
+	if setup != nil {
+		setup(participants)
+	}
+	return start(participants)
+}
+
+// start runs the participants with a short phase and collects their results
+func start(participants map[def.CTngID]*Participant) map[def.CTngID]outcome {
+	for _, p := range participants {
+		p.Phase = 300 * time.Millisecond
+	}
+	outcomes := make(chan struct {
+		id def.CTngID
+		outcome
+	}, len(participants))
+	for id, p := range participants {
+		id := id
+		p.Start(func(result *Result, err error) {
+			outcomes <- struct {
+				id def.CTngID
+				outcome
+			}{id, outcome{result, err}}
+		})
+	}
+	results := make(map[def.CTngID]outcome)
+	for range participants {
+		o := <-outcomes
+		results[o.id] = o.outcome
+	}
+	return results
+}
+
+// checkGroup verifies every monitor ends with the same public key and that threshold shares sign for it
+func checkGroup(t *testing.T, results map[def.CTngID]outcome, qualified []def.CTngID, signers []def.CTngID) {
+	var reference *Result
+	for id, o := range results {
+		if o.err != nil {
+			t.Fatalf("%s failed: %v", id, o.err)
+		}
+		if len(o.result.Qualified) != len(qualified) {
+			t.Fatalf("%s qualified %v, expected %v", id, o.result.Qualified, qualified)
+		}
+		for i := range qualified {
+			if o.result.Qualified[i] != qualified[i] {
+				t.Fatalf("%s qualified %v, expected %v", id, o.result.Qualified, qualified)
+			}
+		}
+		pub := o.result.Public[id]
+		if !o.result.Share.GetPublicKey().IsEqual(&pub) {
+			t.Fatalf("Share of %s does not match its public share", id)
+		}
+		if reference == nil {
+			reference = o.result
+			continue
+		}
+		if !reference.GroupKey.IsEqual(&o.result.GroupKey) {
+			t.Fatalf("%s derived another group key", id)
+		}
+		for member, pub := range reference.Public {
+			other := o.result.Public[member]
+			if !pub.IsEqual(&other) {
+				t.Fatalf("%s derived another public share for %s", id, member)
+			}
+		}
+	}
+
+	msg := "STH of period 1"
+	var sigs []bls.Sign
+	var ids []bls.ID
+	for _, id := range signers {
+		share := results[id].result.Share
+		sigs = append(sigs, *share.Sign(msg))
+		ids = append(ids, *id.BlsID())
+	}
+	var sig bls.Sign
+	if err := sig.Recover(sigs, ids); err != nil {
+		t.Fatal(err)
+	}
+	if !sig.Verify(&reference.GroupKey, msg) {
+		t.Fatal("Recovered signature does not verify against the group key")
+	}
+}
+
+func TestDKG(t *testing.T) {
+	all := []def.CTngID{"M1", "M2", "M3", "M4"}
+
+	t.Run("honest", func(t *testing.T) {
+		checkGroup(t, run(t, nil), all, []def.CTngID{"M1", "M3", "M4"})
+	})
+
+	t.Run("answered complaint", func(t *testing.T) {
+		// M2 hands M3 a bad share but reveals the right one once accused
+		results := run(t, func(participants map[def.CTngID]*Participant) {
+			m2 := participants["M2"]
+			m2.tamper = func(deal *Deal) {
+				deal.Shares["M3"] = deal.Shares["M1"]
+			}
+		})
+		checkGroup(t, results, all, []def.CTngID{"M1", "M3", "M4"})
+	})
+
+	t.Run("unanswered complaint", func(t *testing.T) {
+		results := run(t, func(participants map[def.CTngID]*Participant) {
+			m2 := participants["M2"]
+			m2.tamper = func(deal *Deal) {
+				deal.Shares["M3"] = deal.Shares["M1"]
+			}
+			m2.silent = true
+		})
+		checkGroup(t, results, []def.CTngID{"M1", "M3", "M4"}, []def.CTngID{"M1", "M3", "M4"})
+	})
+
+	t.Run("missing deal", func(t *testing.T) {
+		results := run(t, func(participants map[def.CTngID]*Participant) {
+			// M4 only deals to itself and ignores the complaints
+			participants["M4"].Transport = transport.NewNetwork().Transport("nowhere")
+			participants["M4"].silent = true
+		})
+		checkGroup(t, results, []def.CTngID{"M1", "M2", "M3"}, []def.CTngID{"M1", "M3", "M4"})
+	})
+
+	t.Run("complaint to a single monitor", func(t *testing.T) {
+		// M1 complains about M2 to M3 only: M2 never answers, so M3 alone disqualifies it
+		results := run(t, func(participants map[def.CTngID]*Participant) {
+			complaint := Complaint{Complainer: "M1", Dealer: "M2"}
+			complaint.Signature, _ = participants["M1"].sign(complaint)
+			data, _ := json.Marshal(complaint)
+			m3 := participants["M3"]
+			if err := m3.bind(m3.handleComplaint)("M1", data); err != nil {
+				t.Fatal(err)
+			}
+		})
+		for id, o := range results {
+			if o.err == nil {
+				t.Errorf("%s kept the key of qualified dealers %v", id, o.result.Qualified)
+			}
+		}
+	})
+
+	t.Run("too few qualified dealers", func(t *testing.T) {
+		results := run(t, func(participants map[def.CTngID]*Participant) {
+			for _, id := range []def.CTngID{"M2", "M3"} {
+				participants[id].Transport = transport.NewNetwork().Transport("nowhere")
+				participants[id].silent = true
+			}
+		})
+		if o := results["M1"]; o.err == nil {
+			t.Errorf("Key generated with the qualified dealers %v", o.result.Qualified)
+		}
+	})
+}
+
+// reshare moves the key generated by M1 to M4 to the monitors of next
+func reshare(t *testing.T, generated map[def.CTngID]outcome, next *def.Settings, threshold int, setup func(map[def.CTngID]*Participant)) map[def.CTngID]outcome {
+	current := monitors("M5")
+	network := transport.NewNetwork()
+	participants := make(map[def.CTngID]*Participant)
+	addresses := def.GetMonitorURL(*current)
+	for id, addr := range def.GetMonitorURL(*next) {
+		addresses[id] = addr
+	}
+	for id, addr := range addresses {
+		// every monitor loads its own share of the current key, a joining monitor has none
+		own := *crypto
+		own.TSS_private_map = make(def.BlsPrivateMap)
+		generated["M2"].result.Apply(&own, id)
+		if o, ok := generated[id]; ok {
+			o.result.Apply(&own, id)
+		}
+		participants[id] = NewResharing(id, &own, current, next, threshold, network.Transport(addr), def.RealClock{})
+	}
+	if setup != nil {
+		setup(participants)
+	}
+	return start(participants)
+}
+
+func TestResharing(t *testing.T) {
+	generated := run(t, nil)
+	groupKey := generated["M1"].result.GroupKey
+
+	t.Run("new monitor set", func(t *testing.T) {
+		// M1 leaves, M5 joins and the threshold goes down to 2
+		results := reshare(t, generated, monitors("M1"), 2, nil)
+		left := results["M1"]
+		if left.err != nil {
+			t.Fatal(left.err)
+		}
+		own := *crypto
+		own.TSS_private_map = make(def.BlsPrivateMap)
+		generated["M1"].result.Apply(&own, "M1")
+		left.result.Apply(&own, "M1")
+		if _, ok := own.TSS_private_map["M1"]; ok || own.Threshold != 2 || own.Total != 4 {
+			t.Fatal("The leaving monitor should erase its share and load the new public key")
+		}
+		delete(results, "M1")
+		checkGroup(t, results, []def.CTngID{"M1", "M2", "M3", "M4"}, []def.CTngID{"M2", "M5"})
+		if !results["M5"].result.GroupKey.IsEqual(&groupKey) {
+			t.Fatal("Resharing changed the group key")
+		}
+	})
+
+	t.Run("proactive refresh", func(t *testing.T) {
+		// M4 holds a share that is not its own, so it does not deal: the other three are enough to interpolate the key
+		results := reshare(t, generated, monitors("M5"), 3, func(participants map[def.CTngID]*Participant) {
+			participants["M4"].secret = new(bls.SecretKey)
+			participants["M4"].secret.SetByCSPRNG()
+		})
+		checkGroup(t, results, []def.CTngID{"M1", "M2", "M3"}, []def.CTngID{"M1", "M2", "M4"})
+		if !results["M1"].result.GroupKey.IsEqual(&groupKey) {
+			t.Fatal("Refreshing changed the group key")
+		}
+		old := generated["M1"].result.Share
+		if results["M1"].result.Share.IsEqual(&old) {
+			t.Fatal("Refreshing kept the share")
+		}
+	})
+}
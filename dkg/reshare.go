@@ -0,0 +1,33 @@
+package dkg
+
+import (
+	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+// NewResharing moves the threshold key of the monitors of current to the monitors of next, under a new threshold.
+// Every current monitor deals its own share with a polynomial of the new threshold, and the new shares interpolate
+// the dealings of the qualified dealers at 0: the group public key stays the same, so threshold signatures issued
+// before remain verifiable. It needs the previous threshold of honest current monitors.
+//
+// The crypto config holds the current threshold key and the identity keys of both sets; a monitor only in next
+// takes part without a share. Resharing to the same set under the same threshold is a proactive refresh: the shares
+// change, and shares leaked before are useless once erased.
+func NewResharing(id def.CTngID, crypto *def.GlobalCrypto, current *def.Settings, next *def.Settings, threshold int, t transport.Transport, clock def.Clock) *Participant {
+	dealing := def.GetMonitorURL(*current)
+	receiving := def.GetMonitorURL(*next)
+	addresses := make(map[def.CTngID]string)
+	for monitor, addr := range dealing {
+		addresses[monitor] = addr
+	}
+	for monitor, addr := range receiving {
+		addresses[monitor] = addr
+	}
+	p := newParticipant(id, crypto, sortedIDs(dealing), sortedIDs(receiving), threshold, addresses, next, t, clock)
+	p.previous = crypto.TSS_public_map
+	p.previousThreshold = crypto.Threshold
+	if share, ok := crypto.TSS_private_map[id]; ok {
+		p.secret = &share
+	}
+	return p
+}
@@ -0,0 +1,60 @@
+package logger
+
+import (
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// corruptEncoding rewrites the shares of the monitors targeted by a bad encoding fault, before they are committed in Head_rs
+func (l *Logger) corruptEncoding(shares [][]byte) {
+	for _, fault := range def.GetFaults(*l.Settings, l.CTngID) {
+		if !fault.Active(l.PeriodNum) || fault.Behavior != def.FAULT_BAD_ENCODING {
+			continue
+		}
+		for id := range l.Updates_EEA {
+			if index := def.GetIndex(id); fault.Affects(id) && index < len(shares) {
+				shares[index] = def.CorruptShare(shares[index])
+			}
+		}
+	}
+}
+
+// injectFaults rewrites the updates of the monitors targeted by the faults configured for this Logger.
+// A withheld update is removed from the map, Send_Update_EEA skips the monitors without one.
+func (l *Logger) injectFaults(updates map[def.CTngID]*def.Update_Logger_EEA) {
+	for _, fault := range def.GetFaults(*l.Settings, l.CTngID) {
+		if !fault.Active(l.PeriodNum) {
+			continue
+		}
+		// the PoIs of the genuine shares, before any of them is rewritten
+		pois := make(map[int]def.PoI, len(updates))
+		for id, update := range updates {
+			pois[def.GetIndex(id)] = update.PoI
+		}
+		var fork *def.STH
+		for id, update := range updates {
+			if !fault.Affects(id) {
+				continue
+			}
+			switch fault.Behavior {
+			case def.FAULT_EQUIVOCATE:
+				// the forked STH signs another certificate tree over the same shares, so the targets still accept them
+				forkCert := def.ForkHead(update.Head_cert)
+				if fork == nil {
+					head, err := def.CombinedHead(update.Head_rs, forkCert)
+					def.HandleError(err, "Forked head generation")
+					fork = l.GenerateSTH(head, update.STH.Size, update.STH.FileLen, update.STH.ShardSize)
+				}
+				update.STH = *fork
+				update.Head_cert = forkCert
+			case def.FAULT_WITHHOLD:
+				delete(updates, id)
+			case def.FAULT_CORRUPT_SHARE:
+				update.FileShare = def.CorruptShare(update.FileShare)
+			case def.FAULT_BAD_POI:
+				if poi, ok := pois[(def.GetIndex(id)+1)%l.NumMonitors]; ok {
+					update.PoI = poi
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"errors"
+
+	def "github.com/jik18001/CTngV3/def"
+	merkletree "github.com/txaty/go-merkletree"
+)
+
+// loggedPeriod keeps what the Logger needs to prove inclusion after the update is sent:
+// the signed STH, the heads it covers and 32 bytes per certificate.
+type loggedPeriod struct {
+	sth       def.STH
+	head_rs   []byte // nil in default mode
+	head_cert []byte
+	hashes    [][]byte
+	tree      *merkletree.MerkleTree // Built on the first PoI request
+}
+
+func (l *Logger) recordPeriod(sth def.STH, head_rs []byte, head_cert []byte, certs [][]byte) {
+	l.historyLock.Lock()
+	defer l.historyLock.Unlock()
+	if l.history == nil {
+		l.history = make(map[int]*loggedPeriod)
+	}
+	l.history[sth.PeriodNum] = &loggedPeriod{
+		sth:       sth,
+		head_rs:   head_rs,
+		head_cert: head_cert,
+		hashes:    def.CertificateHashes(certs),
+	}
+}
+
+// InclusionProof returns the PoI of the certificate with the given hash up to the STH of the period.
+func (l *Logger) InclusionProof(period int, hash []byte) (def.InclusionProof, error) {
+	l.historyLock.Lock()
+	defer l.historyLock.Unlock()
+	record, ok := l.history[period]
+	if !ok {
+		return def.InclusionProof{}, errors.New("period not logged")
+	}
+	index := def.CertificateIndex(record.hashes, hash)
+	if index < 0 {
+		return def.InclusionProof{}, errors.New("certificate not logged in this period")
+	}
+	if record.tree == nil {
+		tree, err := def.CertificateHashTree(record.hashes)
+		if err != nil {
+			return def.InclusionProof{}, err
+		}
+		record.tree = tree
+	}
+	return def.BuildInclusionProof(record.sth, record.head_rs, record.head_cert, record.tree, index, hash)
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// OID of the critical poison extension that marks a precertificate (RFC 6962, Section 3.1)
+var ctPoisonOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+func isPrecertificate(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ctPoisonOID) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddChain validates a DER encoded chain (leaf first) and queues the leaf for the next update.
+// Returns the period the certificate will be logged in and its leaf index in that period.
+func (l *Logger) AddChain(chain [][]byte, precert bool) (int, int, error) {
+	if len(chain) == 0 {
+		return 0, 0, errors.New("empty chain")
+	}
+	certs := make([]*x509.Certificate, len(chain))
+	for i, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return 0, 0, fmt.Errorf("certificate %d: %v", i, err)
+		}
+		certs[i] = cert
+	}
+	// every certificate has to be signed by the next one in the chain
+	for i := 0; i+1 < len(certs); i++ {
+		if err := certs[i].CheckSignatureFrom(certs[i+1]); err != nil {
+			return 0, 0, fmt.Errorf("certificate %d: %v", i, err)
+		}
+	}
+	if isPrecertificate(certs[0]) != precert {
+		if precert {
+			return 0, 0, errors.New("leaf is not a precertificate")
+		}
+		return 0, 0, errors.New("precertificates must be submitted to add-pre-chain")
+	}
+
+	l.queueLock.Lock()
+	defer l.queueLock.Unlock()
+	l.queue = append(l.queue, chain[0])
+	return l.queuePeriod, len(l.queue) - 1, nil
+}
+
+// takeCertificates empties the queue for the update of the current period, a period without submissions logs no certificate.
+// With Simulated_load such a period is filled with dummy certificates instead, so simulations keep their load.
+func (l *Logger) takeCertificates() [][]byte {
+	l.queueLock.Lock()
+	defer l.queueLock.Unlock()
+	certs := l.queue
+	l.queue = nil
+	l.queuePeriod = l.PeriodNum + 1
+	if len(certs) == 0 && l.Settings.Simulated_load {
+		certs = dummyCertificates(l.Settings.Certificate_per_logger, l.Settings.Certificate_size)
+	}
+	return certs
+}
+
+func dummyCertificates(num int, size int) [][]byte {
+	if num < 1 {
+		num = 1
+	}
+	if size < 4 {
+		size = 4
+	}
+	certs := make([][]byte, num)
+	for i := range certs {
+		cert := make([]byte, size)
+		for j := range cert {
+			cert[j] = byte((i + j) & 0xff)
+		}
+		// keep the dummy certificates distinct
+		binary.BigEndian.PutUint32(cert, uint32(i))
+		certs[i] = cert
+	}
+	return certs
+}
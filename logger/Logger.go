@@ -1,18 +1,18 @@
 package logger
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	def "github.com/jik18001/CTngV3/def"
-	rs "github.com/klauspost/reedsolomon"
+	transport "github.com/jik18001/CTngV3/transport"
 	merkletree "github.com/txaty/go-merkletree"
 )
 
@@ -20,30 +20,61 @@ type Logger struct {
 	CTngID      def.CTngID                            `json:"CTngID"`
 	Crypto      *def.GlobalCrypto                     `json:"Crypto,omitempty"`
 	Settings    *def.Settings                         `json:"Settings,omitempty"`
-	Client      *http.Client                          `json:"Client,omitempty"`
+	Transport   transport.Transport                   `json:"-"`
+	Clock       def.Clock                             `json:"-"`
 	Update      *def.Update_Logger                    `json:"Update,omitempty"`
 	Updates_EEA map[def.CTngID]*def.Update_Logger_EEA `json:"Updates_EEA,omitempty"`
 	PeriodNum   int                                   `json:"PeriodNum"`
 	NumMonitors int                                   `json:"NumMonitors"`
 	Mal         int                                   `json:"Mal"`
+	Erasure     def.ErasureParams                     `json:"Erasure"`
+	lock        sync.Mutex                            // Serializes the per-period tasks
+	queue       [][]byte                              // DER certificates waiting for the next update
+	queuePeriod int                                   // Period the queued certificates will be logged in
+	queueLock   sync.Mutex                            // Guards the queue, kept apart so submissions never wait on an update
+	history     map[int]*loggedPeriod                 // Logged periods, indexed by period number
+	historyLock sync.Mutex                            // Guards the history
 }
 
 func NewLogger(CTngID def.CTngID, cryptofile string, settingfile string) *Logger {
-	// Initialize a new StoredCrypto object.
-	restoredcrypto := new(def.StoredCrypto)
-
-	// Load the configuration from the file.
-	def.LoadData(&restoredcrypto, cryptofile)
-	crypto, err := def.DecodeCrypto(restoredcrypto)
+	// Load the public configuration and the keys of this entity only.
+	crypto, err := def.LoadCrypto(cryptofile, CTngID)
 	if err != nil {
-		def.HandleError(err, "DecodeCrypto")
+		log.Fatalf("Failed to load the keys of %s: %v", CTngID, err)
 	}
 
 	// Initalize a new Setting object
 	restoredsetting := new(def.Settings)
 	def.LoadData(&restoredsetting, settingfile)
+	loggerContext := InitLogger(CTngID, crypto, restoredsetting, def.RealClock{})
+	tr := &http.Transport{
+		MaxIdleConnsPerHost: 300,
+		MaxConnsPerHost:     300,
+		WriteBufferSize:     1024 * 1024, // 1MB
+		ReadBufferSize:      1024 * 1024, // 1MB
+	}
+	t := transport.NewHTTP(&http.Client{
+		Transport: tr,
+	})
+	if restoredsetting.TLS {
+		config, err := crypto.TLSConfig(CTngID)
+		if err != nil {
+			log.Fatalf("Failed to load the TLS certificate: %v", err)
+		}
+		t.EnableTLS(config)
+	}
+	loggerContext.Transport = t
+	return loggerContext
+}
+
+// InitLogger builds a Logger from a loaded configuration, the caller sets its Transport
+func InitLogger(CTngID def.CTngID, crypto *def.GlobalCrypto, settings *def.Settings, clock def.Clock) *Logger {
 	numMonitors := crypto.Total
 	numMal := crypto.Threshold - 1
+	erasure, err := def.GetErasureParams(*settings, CTngID)
+	if err != nil {
+		log.Fatalf("Invalid erasure coding parameters: %v", err)
+	}
 	var Update *def.Update_Logger
 	Updates_EEA := make(map[def.CTngID]*def.Update_Logger_EEA, numMonitors)
 	for i := 0; i < numMonitors; i++ {
@@ -52,26 +83,19 @@ func NewLogger(CTngID def.CTngID, cryptofile string, settingfile string) *Logger
 			MonitorID: id,
 		}
 	}
-	loggerContext := Logger{
+	return &Logger{
 		CTngID:      CTngID,
 		Crypto:      crypto,
-		Settings:    restoredsetting,
+		Settings:    settings,
+		Clock:       clock,
 		Update:      Update,
 		Updates_EEA: Updates_EEA,
 		PeriodNum:   1,
 		NumMonitors: numMonitors,
 		Mal:         numMal,
+		Erasure:     erasure,
+		queuePeriod: 1,
 	}
-	tr := &http.Transport{
-		MaxIdleConnsPerHost: 300,
-		MaxConnsPerHost:     300,
-		WriteBufferSize:     1024 * 1024, // 1MB
-		ReadBufferSize:      1024 * 1024, // 1MB
-	}
-	loggerContext.Client = &http.Client{
-		Transport: tr,
-	}
-	return &loggerContext
 }
 func (l *Logger) Sign(msg []byte) (def.RSASig, error) {
 	sig, err := l.Crypto.Sign(msg, l.CTngID)
@@ -104,9 +128,10 @@ func adjustFileSize(numMonitorsm1, certificateSize, filesize int) int {
 // for simulation purposes, we assume certificate size of 5KB
 // This means that file size needs to be divisible by the greatest common divsior of the product
 
-func (l *Logger) GenerateSTH(rootHash []byte, size int) *def.STH {
+// GenerateSTH signs the head of size certificates, held in a certificate file of fileLen bytes split into shares of shardSize bytes
+func (l *Logger) GenerateSTH(rootHash []byte, size int, fileLen int, shardSize int) *def.STH {
 	// Get the current timestamp in UTC RFC3339 format
-	timestamp := time.Now().UTC().Format(time.RFC3339)
+	timestamp := l.Clock.Now().UTC().Format(time.RFC3339)
 
 	// Create the STH
 	sth := &def.STH{
@@ -115,8 +140,15 @@ func (l *Logger) GenerateSTH(rootHash []byte, size int) *def.STH {
 		Timestamp: timestamp,
 		Head:      rootHash,
 		LID:       l.CTngID.String(),
+		FileLen:   fileLen,
+		ShardSize: shardSize,
 		Signature: def.RSASig{}, // Placeholder for the signature
 	}
+	// Monitors decode the certificate file with the parameters signed here
+	if l.Settings.Distribution_Mode == def.EEA {
+		erasure := l.Erasure
+		sth.Erasure = &erasure
+	}
 
 	// Serialize the STH for signing
 	sthBytes, err := json.Marshal(sth)
@@ -130,17 +162,18 @@ func (l *Logger) GenerateSTH(rootHash []byte, size int) *def.STH {
 }
 
 func (l *Logger) GenerateUpdate() {
-	// Initialize Reed-Solomon encoder
-	k := l.NumMonitors / 2           // Number of data shards (floor division)
-	m := l.NumMonitors - k           // Number of parity shards
-	enc, err := rs.New(k, m)
+	// Initialize the erasure encoder, Reed-Solomon unless the settings pick another code
+	k := l.Erasure.K // Number of data shards
+	enc, err := l.Erasure.Coder()
 	if err != nil {
-		log.Fatalf("Error initializing Reed-Solomon encoder: %v", err)
+		log.Fatalf("Error initializing erasure encoder: %v", err)
 	}
 
-	// We'll compute the total file size as before
-	filesize := adjustFileSize(k, l.Settings.Certificate_size,
-		l.Settings.Certificate_size*l.Settings.Certificate_per_logger)
+	// The certificates queued for this period, encoded into a single certificate file
+	certs := l.takeCertificates()
+	file := def.EncodeCertificateFile(certs)
+	// The file is zero padded so that it splits into k blocks of the same size, of at least one byte for an empty period
+	filesize := adjustFileSize(k, l.Settings.Certificate_size, max(len(file), 1))
 
 	// Decide how many blocks total to allocate
 	// We still want to produce 'n = NumMonitors' slices in EEA mode
@@ -158,38 +191,28 @@ func (l *Logger) GenerateUpdate() {
 		data[i] = make([]byte, filesize/k)
 	}
 
-	// Fill the first k blocks with some dummy data for the example:
-	// (In practice, this is where you'd write your actual certificate bytes.)
+	// Fill the first k blocks with the certificate file
 	for i, in := range data[:k] {
-		for j := range in {
-			in[j] = byte((i + j) & 0xff)
+		start := i * len(in)
+		if start < len(file) {
+			copy(in, file[start:])
 		}
 	}
 
-	// Now build a Merkle tree of the actual data blocks (only the first k).
-	var dataBlocks []merkletree.DataBlock
-	for i := 0; i < k; i++ {
-		// chunk them by l.Settings.Certificate_size
-		for j := 0; j < len(data[i]); j += l.Settings.Certificate_size {
-			end := j + l.Settings.Certificate_size
-			if end > len(data[i]) {
-				end = len(data[i])
-			}
-			dataBlocks = append(dataBlocks, &def.LeafBlock{Content: data[i][j:end]})
-		}
-	}
+	// Now build a Merkle tree over the certificates, one leaf per certificate.
+	dataBlocks := def.CertificateBlocks(certs)
 
 	// Generate the "certificate" Merkle Tree, rootHash, and STH as normal
 	tree, err := def.GenerateMerkleTree(dataBlocks)
 	def.HandleError(err, "MT Generation")
 	rootHash := def.GenerateRootHash(tree)
-	sth := l.GenerateSTH(rootHash, l.Settings.Certificate_per_logger)
-
+	sth := l.GenerateSTH(rootHash, len(certs), len(file), filesize/k)
 	// If we're in erasure-encoding mode (EEA), do the encoding
 	if l.Settings.Distribution_Mode == def.EEA {
 		// Encode the data: data[:k] are data shards, data[k:] are parity
 		err := enc.Encode(data)
-		def.HandleError(err, "RS Encoding error")
+		def.HandleError(err, "Erasure encoding error")
+		l.corruptEncoding(data)
 
 		// Build a second Merkle tree of the entire data[] (k+m = NumMonitors blocks)
 		var RSdataBlocks []merkletree.DataBlock
@@ -208,22 +231,31 @@ func (l *Logger) GenerateUpdate() {
 		newtree, err := def.GenerateMerkleTree(rootblocks)
 		def.HandleError(err, "Third Merkle Tree Generation")
 		combinedroot := def.GenerateRootHash(newtree)
-		newSTH := l.GenerateSTH(combinedroot, l.Settings.Certificate_per_logger)
+		newSTH := l.GenerateSTH(combinedroot, len(certs), len(file), filesize/k)
+		l.recordPeriod(*newSTH, rootHashRS, rootHash, certs)
 
 		// Assign each monitor’s share and PoI
-		for id, update := range l.Updates_EEA {
+		// A fresh map is built every period so updates still in flight are never modified.
+		updates := make(map[def.CTngID]*def.Update_Logger_EEA, len(l.Updates_EEA))
+		for id := range l.Updates_EEA {
 			index := def.GetIndex(id) // e.g. M1 => index=0, M2 => 1, etc.
-			update.STH = *newSTH
-			update.FileShare = data[index] // The shard for that monitor
 			poi, _ := def.GeneratePOI(newtree, RSdataBlocks, index)
-			update.Head_rs = rootHashRS
-			update.Head_cert = rootHash
-			update.PoI = poi
+			updates[id] = &def.Update_Logger_EEA{
+				MonitorID: id,
+				STH:       *newSTH,
+				FileShare: data[index], // The shard for that monitor
+				Head_rs:   rootHashRS,
+				Head_cert: rootHash,
+				PoI:       poi,
+			}
 		}
+		l.injectFaults(updates)
+		l.Updates_EEA = updates
 		return
 	}
 
 	// Else (not EEA), just store the raw data in l.Update
+	l.recordPeriod(*sth, nil, rootHash, certs)
 	l.Update = &def.Update_Logger{
 		STH:  *sth,
 		File: data,
@@ -231,14 +263,13 @@ func (l *Logger) GenerateUpdate() {
 }
 
 func (l *Logger) sendUpdateToMonitor(urlSuffix string, update interface{}, monitorID string) int {
-	url := "http://" + monitorID + urlSuffix
 	updateJSON, err := json.Marshal(update)
 	if err != nil {
 		log.Fatalf("Failed to marshal update: %v", err)
 	}
 
 	trafficSize := len(updateJSON) // Measure the size of the JSON data
-	_, err = l.Client.Post(url, "application/json", bytes.NewBuffer(updateJSON))
+	err = l.Transport.Send(monitorID, urlSuffix, updateJSON)
 	if err != nil {
 		fmt.Printf("Failed to send update to: %s\nError: %v\n", monitorID, err)
 	} else {
@@ -250,40 +281,38 @@ func (l *Logger) sendUpdateToMonitor(urlSuffix string, update interface{}, monit
 
 func (l *Logger) Send_Update_EEA() {
 	monitors := def.GetMonitorURL(*l.Settings)
+	updates := l.Updates_EEA
 
 	// We'll keep track of total traffic with an atomic counter
 	var totalTraffic int64
 
-	// We use a WaitGroup to wait for all goroutines to finish
-	var wg sync.WaitGroup
-
-	for id, monitorURL := range monitors {
-		update := l.Updates_EEA[id]
-
-		// For each monitor, spawn a goroutine
-		wg.Add(1)
-		go func(url string, upd *def.Update_Logger_EEA) {
-			defer wg.Done()
+	// The monitors are visited in order so a seeded run always draws the same delays
+	// Monitors without an update are the ones a faulty Logger withholds it from
+	ids := make([]def.CTngID, 0, len(monitors))
+	for id := range monitors {
+		if updates[id] != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return def.GetIndex(ids[i]) < def.GetIndex(ids[j]) })
+	remaining := int64(len(ids))
 
-			// Introduce random delay of 10–50 ms
-			delay := time.Duration(rand.Intn(41)+10) * time.Millisecond
-			time.Sleep(delay)
+	for _, id := range ids {
+		url, upd := monitors[id], updates[id]
 
+		// Introduce random delay of 10–50 ms
+		delay := time.Duration(rand.Intn(41)+10) * time.Millisecond
+		l.Clock.AfterFunc(delay, func() {
 			// Now actually send the update
 			traffic := l.sendUpdateToMonitor("/monitor/logger_update_EEA", upd, url)
 
-			// Accumulate into the totalTraffic
+			// Accumulate into the totalTraffic, the last update reports it
 			atomic.AddInt64(&totalTraffic, int64(traffic))
-
-		}(monitorURL, update)
+			if atomic.AddInt64(&remaining, -1) == 0 {
+				fmt.Printf("Total traffic sent for EEA updates: %d bytes\n", atomic.LoadInt64(&totalTraffic))
+			}
+		})
 	}
-
-	// Wait until all goroutines have finished
-	wg.Wait()
-
-	// Convert from int64 to int for printing, if desired
-	finalTraffic := atomic.LoadInt64(&totalTraffic)
-	fmt.Printf("Total traffic sent for EEA updates: %d bytes\n", finalTraffic)
 }
 
 /*
@@ -310,19 +339,43 @@ func (l *Logger) Send_Update() {
 	fmt.Printf("Total traffic sent for general updates: %d bytes\n", totalTraffic)
 }
 
+// PeriodicTasks generates and sends the update for the current period, then moves on to the next period every MUD.
+func (l *Logger) PeriodicTasks() {
+	// Immediately queue up the next task to run at next MUD.
+	// Doing this first means: no matter how long the rest of the function takes,
+	// the next call will always occur after the correct amount of time.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.Settings.Num_Periods == 0 || l.PeriodNum < l.Settings.Num_Periods {
+		l.Clock.AfterFunc(time.Duration(l.Settings.MUD)*time.Second, func() {
+			l.lock.Lock()
+			l.PeriodNum++
+			l.lock.Unlock()
+			l.PeriodicTasks()
+		})
+	}
+	fmt.Println(def.BLUE+"Logger", l.CTngID, "entering period", l.PeriodNum, def.RESET)
+	l.GenerateUpdate()
+	if l.Settings.Distribution_Mode == def.EEA {
+		if update, ok := l.Updates_EEA[def.CTngID("M1")]; ok {
+			fmt.Println(update.Head_cert)
+			fmt.Println(update.Head_rs)
+		}
+		l.Send_Update_EEA()
+	} else {
+		fmt.Println(l.Update.STH)
+		l.Send_Update()
+	}
+}
+
 func StartLogger(id def.CTngID, cryptofile string, settingfile string) {
 	// Add a random delay between 0 and 4 seconds
 	rand.Seed(time.Now().UnixNano())                   // Seed the random number generator
 	delay := time.Duration(rand.Intn(5)) * time.Second // Random delay in the range [0, 4] seconds
 	time.Sleep(delay)                                  // Introduce the delay
 	newlogger := NewLogger(id, cryptofile, settingfile)
-	newlogger.GenerateUpdate()
-	if newlogger.Settings.Distribution_Mode == def.EEA {
-		fmt.Println(newlogger.Updates_EEA[def.CTngID("M1")].Head_cert)
-		fmt.Println(newlogger.Updates_EEA[def.CTngID("M1")].Head_rs)
-		newlogger.Send_Update_EEA()
-	} else {
-		fmt.Println(newlogger.Update.STH)
-		newlogger.Send_Update()
-	}
+	go StartLoggerServer(newlogger)
+	newlogger.PeriodicTasks()
+	// The periodic tasks run on their own timers from here on.
+	select {}
 }
@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+// AddChainRequest follows the add-chain input of RFC 6962, the chain holds base64 DER certificates, leaf first
+type AddChainRequest struct {
+	Chain [][]byte `json:"chain"`
+}
+
+type AddChainResponse struct {
+	LID    string `json:"lid"`
+	Period int    `json:"period"` // Period of the STH that will cover the certificate
+	Index  int    `json:"index"`  // Leaf index of the certificate in that period
+}
+
+func bindContext(context *Logger, fn func(context *Logger, w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fn(context, w, r)
+	}
+}
+
+func handleAddChain(l *Logger, w http.ResponseWriter, r *http.Request, precert bool) {
+	var req AddChainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to decode chain", http.StatusBadRequest)
+		return
+	}
+	period, index, err := l.AddChain(req.Chain, precert)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AddChainResponse{
+		LID:    l.CTngID.String(),
+		Period: period,
+		Index:  index,
+	})
+}
+
+func add_chain_handler(l *Logger, w http.ResponseWriter, r *http.Request) {
+	handleAddChain(l, w, r, false)
+}
+
+func add_pre_chain_handler(l *Logger, w http.ResponseWriter, r *http.Request) {
+	handleAddChain(l, w, r, true)
+}
+
+// inclusion_handler returns the PoI of a certificate, identified by the hex SHA256 of its DER, up to the STH of the period
+func inclusion_handler(l *Logger, w http.ResponseWriter, r *http.Request) {
+	period, err := strconv.Atoi(mux.Vars(r)["period"])
+	if err != nil {
+		http.Error(w, "Invalid period", http.StatusBadRequest)
+		return
+	}
+	hash, err := hex.DecodeString(mux.Vars(r)["hash"])
+	if err != nil {
+		http.Error(w, "Invalid certificate hash", http.StatusBadRequest)
+		return
+	}
+	proof, err := l.InclusionProof(period, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proof)
+}
+
+// NewLoggerRouter routes the submission and proof endpoints of a Logger, only authenticated peers may submit under TLS
+func NewLoggerRouter(l *Logger) *mux.Router {
+	// MUX which routes HTTP directories to functions.
+	gorillaRouter := mux.NewRouter().StrictSlash(true)
+	gorillaRouter.HandleFunc("/logger/add-chain", transport.RequirePeer(l.Transport, bindContext(l, add_chain_handler))).Methods("POST")
+	gorillaRouter.HandleFunc("/logger/add-pre-chain", transport.RequirePeer(l.Transport, bindContext(l, add_pre_chain_handler))).Methods("POST")
+	gorillaRouter.HandleFunc("/logger/inclusion/{period}/{hash}", bindContext(l, inclusion_handler)).Methods("GET")
+	return gorillaRouter
+}
+
+func StartLoggerServer(l *Logger) {
+	gorillaRouter := NewLoggerRouter(l)
+	// Serve over TLS when the transport was authenticated
+	var config *tls.Config
+	if t, ok := l.Transport.(*transport.HTTP); ok {
+		config = t.TLS
+	}
+	// Start the HTTP server.
+	fmt.Println(def.BLUE+"Logger listening on port:", l.Settings.Portmap[l.CTngID], def.RESET)
+	err := transport.ListenAndServe(l.Settings.Portmap[l.CTngID], gorillaRouter, config)
+	// We wont get here unless there's an error.
+	log.Fatal("ListenAndServe: ", err)
+}
@@ -2,20 +2,51 @@ package logger
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
 
 	def "github.com/jik18001/CTngV3/def"
 	rs "github.com/klauspost/reedsolomon"
-	merkletree "github.com/txaty/go-merkletree"
 )
 
+// testconfig is a crypto config generated for the test settings with its key files, the committed one holds no private keys
+var testconfig string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ctng")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testconfig = filepath.Join(dir, "testconfig.json")
+	settings := new(def.Settings)
+	def.LoadData(settings, "../def/testsettings.json")
+	if err := def.GenerateKeyFiles(*settings, testconfig); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestLoggerCryptoFunctionality(t *testing.T) {
-	l1 := NewLogger(def.CTngID("L1"), "../def/testconfig.json", "../def/testsettings.json")
+	l1 := NewLogger(def.CTngID("L1"), testconfig, "../def/testsettings.json")
 	// Example test data
 	testdata := []byte("test data")
 	sig, _ := l1.Sign([]byte(testdata))
@@ -26,10 +57,19 @@ func TestLoggerCryptoFunctionality(t *testing.T) {
 }
 
 func TestLoggerEEA(t *testing.T) {
-	logger := NewLogger(def.CTngID("L1"), "../def/testconfig.json", "../def/testsettings.json")
+	logger := NewLogger(def.CTngID("L1"), testconfig, "../def/testsettings.json")
 	CertificateSize := logger.Settings.Certificate_size
+	// The def tests rewrite the shared settings file with the default distribution mode
+	logger.Settings.Distribution_Mode = def.EEA
+	// Without submissions the period is filled with dummy certificates
+	logger.Settings.Simulated_load = true
 	logger.GenerateUpdate()
-	monitorIDs := def.GenerateRandomCTngIDs(logger.NumMonitors-logger.Mal, logger.NumMonitors)
+	// The Logger encodes with the k data shares of its settings, floor(n/2) by default
+	k := logger.Erasure.K
+	if k != logger.NumMonitors/2 {
+		t.Fatalf("Default erasure coding should use %d data shares, not %d", logger.NumMonitors/2, k)
+	}
+	monitorIDs := def.GenerateRandomCTngIDs(k, logger.NumMonitors)
 	for _, monitorID := range monitorIDs {
 		update, exists := logger.Updates_EEA[monitorID]
 		if !exists {
@@ -88,8 +128,8 @@ func TestLoggerEEA(t *testing.T) {
 	}
 
 	// Reed-Solomon decode
-	fmt.Println(logger.NumMonitors-logger.Mal, logger.Mal)
-	dec, err := rs.New(logger.NumMonitors-logger.Mal, logger.Mal)
+	fmt.Println(k, logger.NumMonitors-k)
+	dec, err := rs.New(k, logger.NumMonitors-k)
 	if err != nil {
 		log.Fatalf("Error initializing Reed-Solomon decoder: %v", err)
 	}
@@ -98,18 +138,20 @@ func TestLoggerEEA(t *testing.T) {
 	if err != nil {
 		log.Fatalf("Error during Reed-Solomon decoding: %v", err)
 	}
-	var dataBlocks []merkletree.DataBlock
-	for i := range fileShares[:(logger.NumMonitors - logger.Mal)] {
-		for j := 0; j < len(fileShares[i]); j += CertificateSize {
-			end := j + CertificateSize
-			if end > len(fileShares[i]) {
-				end = len(fileShares[i])
-			}
-			dataBlocks = append(dataBlocks, &def.LeafBlock{Content: fileShares[i][j:end]})
-		}
+	// The STH signs the length of the file, the rest of the data shares is padding
+	file, err := logger.Updates_EEA[monitorIDs[0]].STH.FileOf(fileShares[:k])
+	if err != nil {
+		t.Fatalf("Failed to strip the padding: %v", err)
+	}
+	certs, err := def.DecodeCertificateFile(file)
+	if err != nil {
+		t.Fatalf("Failed to decode certificate file: %v", err)
+	}
+	if len(certs) != logger.Settings.Certificate_per_logger || len(certs[0]) != CertificateSize {
+		t.Errorf("Unexpected dummy certificates: %d of %d bytes", len(certs), len(certs[0]))
 	}
 	// Generate Merkle Tree
-	tree, err := def.GenerateMerkleTree(dataBlocks)
+	tree, err := def.GenerateMerkleTree(def.CertificateBlocks(certs))
 	def.HandleError(err, "MT Generation")
 	rootHash := def.GenerateRootHash(tree)
 
@@ -123,3 +165,104 @@ func TestLoggerEEA(t *testing.T) {
 		t.Errorf("STH Verification Failed")
 	}
 }
+
+// Generates a self-signed CA and a leaf issued by it, the leaf is a precertificate when poison is set
+func testChain(t *testing.T, poison bool) [][]byte {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if poison {
+		leafTemplate.ExtraExtensions = []pkix.Extension{{Id: ctPoisonOID, Critical: true, Value: []byte{0x05, 0x00}}}
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create leaf certificate: %v", err)
+	}
+	return [][]byte{leafDER, caDER}
+}
+
+func TestAddChain(t *testing.T) {
+	logger := NewLogger(def.CTngID("L1"), testconfig, "../def/testsettings.json")
+	logger.Settings.Distribution_Mode = def.EEA
+	router := mux.NewRouter()
+	router.HandleFunc("/logger/add-chain", bindContext(logger, add_chain_handler)).Methods("POST")
+
+	chain := testChain(t, false)
+	body, _ := json.Marshal(AddChainRequest{Chain: chain})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/logger/add-chain", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add-chain failed: %d %s", rec.Code, rec.Body.String())
+	}
+	var resp AddChainResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Period != 1 || resp.Index != 0 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	precert := testChain(t, true)
+	if _, _, err := logger.AddChain(precert, false); err == nil {
+		t.Errorf("Precertificate accepted by add-chain")
+	}
+	if _, index, err := logger.AddChain(precert, true); err != nil || index != 1 {
+		t.Errorf("add-pre-chain failed: %v", err)
+	}
+	// a broken chain is rejected
+	if _, _, err := logger.AddChain([][]byte{chain[0], precert[1]}, false); err == nil {
+		t.Errorf("Chain with the wrong issuer accepted")
+	}
+
+	logger.GenerateUpdate()
+	update := logger.Updates_EEA[def.CTngID("M1")]
+	if update.STH.Size != 2 {
+		t.Errorf("Expected 2 certificates in the STH, got %d", update.STH.Size)
+	}
+	tree, _ := def.GenerateMerkleTree(def.CertificateBlocks([][]byte{chain[0], precert[0]}))
+	if !reflect.DeepEqual(def.GenerateRootHash(tree), update.Head_cert) {
+		t.Errorf("Certificate tree does not cover the submitted certificates")
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	for _, mode := range []string{def.EEA, def.DEFAULT} {
+		logger := NewLogger(def.CTngID("L1"), testconfig, "../def/testsettings.json")
+		logger.Settings.Distribution_Mode = mode
+		chain := testChain(t, false)
+		if _, _, err := logger.AddChain(chain, false); err != nil {
+			t.Fatalf("AddChain failed: %v", err)
+		}
+		logger.GenerateUpdate()
+		proof, err := logger.InclusionProof(1, def.CertificateHash(chain[0]))
+		if err != nil {
+			t.Fatalf("%s: InclusionProof failed: %v", mode, err)
+		}
+		if err := def.VerifyInclusion(chain[0], proof); err != nil {
+			t.Errorf("%s: Verification failed: %v", mode, err)
+		}
+		if err := def.VerifyInclusion(chain[1], proof); err == nil {
+			t.Errorf("%s: Proof accepted for another certificate", mode)
+		}
+		if _, err := logger.InclusionProof(1, def.CertificateHash(chain[1])); err == nil {
+			t.Errorf("%s: Proof served for a certificate that was not logged", mode)
+		}
+	}
+}
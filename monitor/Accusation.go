@@ -0,0 +1,113 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// accusable is implemented by both the Logger and the CA state machines
+type accusable interface {
+	GetField(field string) (interface{}, error)
+	SetField(field string, value interface{}) error
+	AddAccusationFragment(accusationFragment def.SigFragment) bool
+	GetAccusationList() []def.SigFragment
+	AddAPoM(apom def.APoM) error
+	AddBPoM(bpom def.BPoM) error
+	GetStartTime() time.Time
+}
+
+// convict moves a state machine to PoM, the first conviction records how long the detection took
+func convict(m *MonitorEEA, fsm accusable) {
+	fsm.SetField("State", def.POM)
+	if pomtime, _ := fsm.GetField("PoMtime"); pomtime == time.Duration(0) {
+		fsm.SetField("PoMtime", m.Clock.Now().Sub(fsm.GetStartTime()))
+	}
+}
+
+func (m *MonitorEEA) getAccusable(id def.CTngID, period int) (accusable, error) {
+	if len(id) == 0 {
+		return nil, fmt.Errorf("missing entity ID")
+	}
+	switch id[0] {
+	case 'L':
+		return m.GetFSMLogger(id, period)
+	case 'C':
+		return m.GetFSMCA(id, period)
+	}
+	return nil, fmt.Errorf("unknown entity: %s", id)
+}
+
+// accuse threshold signs an accusation against an entity that failed the data check and broadcasts the fragment
+func accuse(m *MonitorEEA, id def.CTngID, period int) {
+	fmt.Println(def.RED+"Accusing", id, "of withholding data in period", period, def.RESET)
+	sigfrag := m.ThresholdSign(def.AccusationMessage(id, period))
+	monitor_signed_data := MonitorSignedData{
+		Type:      "APoM",
+		CTngID:    id,
+		Period:    period,
+		Signature: sigfrag.String(),
+	}
+	msd_json, err := json.Marshal(monitor_signed_data)
+	if err != nil {
+		log.Fatalf("Failed to marshal accusation: %v", err)
+	}
+	broadcastEEA(m, "/monitor/accusation", msd_json)
+}
+
+// accusation_handler collects accusation fragments, Threshold of them are aggregated into an APoM
+func accusation_handler(m *MonitorEEA, data []byte) error {
+	var msd MonitorSignedData
+	if err := json.Unmarshal(data, &msd); err != nil || msd.Type != "APoM" {
+		return errors.New("Failed to decode accusation")
+	}
+	fsm, err := m.getAccusable(msd.CTngID, msd.Period)
+	if err != nil {
+		fmt.Println("Failed to locate state:", err)
+		return nil
+	}
+	apom, _ := fsm.GetField("APoM")
+	if apom != (def.APoM{}) {
+		return nil
+	}
+	sigfrag, err := def.SigFragmentFromString(msd.Signature)
+	if err != nil {
+		return errors.New("Failed to decode accusation")
+	}
+	err = m.FragmentVerify(def.AccusationMessage(msd.CTngID, msd.Period), sigfrag)
+	if err != nil {
+		fmt.Println("accusation verification failed: ", err)
+		return nil
+	}
+	if !fsm.AddAccusationFragment(sigfrag) {
+		return nil
+	}
+	accusations := fsm.GetAccusationList()
+	fmt.Println("number of accusations against", msd.CTngID, ":", len(accusations))
+	if len(accusations) == m.Crypto.Threshold {
+		sig := m.Aggregate(accusations)
+		sigstring, err := sig.String()
+		if err != nil {
+			log.Fatalf("Failed to serialize threshold signature: %v", err)
+		}
+		err = fsm.AddAPoM(def.APoM{
+			Entity_Convicted: msd.CTngID,
+			Period:           msd.Period,
+			Signature:        sigstring,
+		})
+		if err == nil {
+			convict(m, fsm)
+			fmt.Println("Switched to PoM State, APoM generated against", msd.CTngID)
+		}
+	}
+	msd_json, err := json.Marshal(msd)
+	if err != nil {
+		log.Fatalf("Failed to marshal accusation: %v", err)
+	}
+	broadcastEEA(m, "/monitor/accusation", msd_json)
+	return nil
+}
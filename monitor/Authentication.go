@@ -0,0 +1,78 @@
+package monitor
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+// claims are the fields of a message that name its sender
+type claims struct {
+	Sender    string     `json:"sender"`    // Notifications and requests, the address answers go to
+	MonitorID def.CTngID `json:"MonitorID"` // Updates, the monitor the Logger or CA addressed the share to
+	STH       struct {
+		LID string `json:"lid"`
+	} `json:"STH"`
+	SRH struct {
+		CAID string `json:"CAID"`
+	} `json:"SRH"`
+}
+
+// authenticate rejects a message whose claims do not match the peer the transport authenticated.
+// Monitors relay the partial signatures, accusations and updates of others, only the Sender of a monitor is its own.
+// Loggers and CAs may only send their own updates, addressed to this monitor.
+func authenticate(m *MonitorEEA, from def.CTngID, data []byte) error {
+	if from == "" {
+		// the transport does not authenticate its peers
+		return nil
+	}
+	if _, ok := m.Settings.Ipmap[from]; !ok {
+		return fmt.Errorf("Unknown peer %s", from)
+	}
+	var claimed claims
+	// messages without these fields leave them empty
+	json.Unmarshal(data, &claimed)
+	switch from[0] {
+	case 'M':
+		if claimed.Sender != "" && claimed.Sender != def.GetMonitorURL(*m.Settings)[from] {
+			return fmt.Errorf("%s claims to be %s", from, claimed.Sender)
+		}
+	case 'L':
+		if claimed.STH.LID != from.String() || (claimed.MonitorID != "" && claimed.MonitorID != m.CTngID) {
+			return fmt.Errorf("%s may only send its own updates to %s", from, m.CTngID)
+		}
+	case 'C':
+		if claimed.SRH.CAID != from.String() || (claimed.MonitorID != "" && claimed.MonitorID != m.CTngID) {
+			return fmt.Errorf("%s may only send its own updates to %s", from, m.CTngID)
+		}
+	default:
+		return fmt.Errorf("Unknown peer %s", from)
+	}
+	return nil
+}
+
+// tlsConfig loads the certificate of the monitor, nil when the settings do not enable TLS
+func tlsConfig(m *MonitorEEA) *tls.Config {
+	if !m.Settings.TLS {
+		return nil
+	}
+	config, err := m.Crypto.TLSConfig(m.CTngID)
+	if err != nil {
+		log.Fatalf("Failed to load the TLS certificate: %v", err)
+	}
+	return config
+}
+
+// newHTTP builds the HTTP transport of the monitor, authenticated when the settings enable TLS
+func newHTTP(m *MonitorEEA, client *http.Client) *transport.HTTP {
+	t := transport.NewHTTP(client)
+	if config := tlsConfig(m); config != nil {
+		t.EnableTLS(config)
+	}
+	return t
+}
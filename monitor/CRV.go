@@ -0,0 +1,154 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	bitset "github.com/bits-and-blooms/bitset"
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// A monitor keeps the CRV of each CA after the latest period it verified and rolls it forward with the DCRV of each period.
+// The state machine of a period only holds its CRV until the period is pruned, for the revocation queries.
+// A monitor that missed a period, or whose CRV does not match the hcrv of an SRH, fetches the CRV from its peers:
+// any CRV whose hash is the hcrv signed by the CA will do.
+
+// CRVState is the CRV of a CA after a period
+type CRVState struct {
+	Period int
+	CRV    *bitset.BitSet
+}
+
+// CRVTransfer carries the CRV of a CA after a period to a monitor that does not know it
+type CRVTransfer struct {
+	CAID   def.CTngID `json:"caid"`
+	Period int        `json:"period"`
+	CRV    []byte     `json:"crv"`
+}
+
+// setCRV records the CRV of a CA after the period of the state machine, rec persists the DCRV or the CRV it was obtained from
+func (m *MonitorEEA) setCRV(fsmca *FSMCAEEA, crv *bitset.BitSet, rec Record) {
+	fsmca.SetCRV(crv)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if latest, ok := m.CRVs[fsmca.CTngID]; !ok || latest.Period < fsmca.Period {
+		m.CRVs[fsmca.CTngID] = CRVState{Period: fsmca.Period, CRV: crv}
+	}
+	rec.Entity, rec.Period = fsmca.CTngID, fsmca.Period
+	persist(m.store, rec)
+}
+
+// crvAt returns the CRV of a CA after the period of the state machine, nil if this monitor does not know it
+func (m *MonitorEEA) crvAt(fsmca *FSMCAEEA) *bitset.BitSet {
+	if crv := fsmca.GetCRV(); crv != nil {
+		return crv
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if latest, ok := m.CRVs[fsmca.CTngID]; ok && latest.Period == fsmca.Period {
+		return latest.CRV
+	}
+	return nil
+}
+
+// previousCRV returns the CRV of a CA before the given period, false if this monitor does not know it
+func previousCRV(m *MonitorEEA, id def.CTngID, period int) (*bitset.BitSet, bool) {
+	if period == 1 {
+		return nil, true
+	}
+	fsmca, err := m.GetFSMCA(id, period-1)
+	if err != nil {
+		return nil, false
+	}
+	crv := m.crvAt(fsmca)
+	return crv, crv != nil
+}
+
+// requestCRV asks Threshold peers for the CRV of a CA after the period, at least one of them is honest as the threshold exceeds Mal
+func requestCRV(m *MonitorEEA, id def.CTngID, period int) {
+	peers := make([]def.CTngID, 0, len(m.Broadcast_targets))
+	for peer := range m.Broadcast_targets {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	if len(peers) > m.Crypto.Threshold {
+		peers = peers[:m.Crypto.Threshold]
+	}
+	request, err := json.Marshal(def.Notification{
+		Type:       def.RUEEA,
+		Originator: id,
+		Period:     period,
+		Sender:     m.Self_ip_port,
+	})
+	if err != nil {
+		log.Fatalf("Failed to marshal CRV request: %v", err)
+	}
+	for _, peer := range peers {
+		if err := m.Transport.Send(m.Broadcast_targets[peer], "/monitor/crv_request", request); err != nil {
+			fmt.Println("Failed to send CRV request:", err)
+		}
+	}
+}
+
+// crv_request_handler answers with the CRV of the requested period, if this monitor still holds it
+func crv_request_handler(m *MonitorEEA, data []byte) error {
+	var request def.Notification
+	if err := json.Unmarshal(data, &request); err != nil {
+		return errors.New("Failed to decode CRV request")
+	}
+	fsmca, err := m.GetFSMCA(request.Originator, request.Period)
+	if err != nil {
+		fmt.Println("Failed to locate CA state:", err)
+		return nil
+	}
+	crv := m.crvAt(fsmca)
+	if crv == nil {
+		return nil
+	}
+	crvbytes, err := crv.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	transfer, err := json.Marshal(CRVTransfer{CAID: request.Originator, Period: request.Period, CRV: crvbytes})
+	if err != nil {
+		log.Fatalf("Failed to marshal CRV: %v", err)
+	}
+	if err := m.Transport.Send(request.Sender, "/monitor/crv", transfer); err != nil {
+		fmt.Println("Failed to send CRV:", err)
+	}
+	return nil
+}
+
+// crv_handler adopts a CRV sent by a peer when it matches the hcrv of the SRH of its period
+func crv_handler(m *MonitorEEA, data []byte) error {
+	var transfer CRVTransfer
+	if err := json.Unmarshal(data, &transfer); err != nil {
+		return errors.New("Failed to decode CRV")
+	}
+	// pruned periods no longer hold a CRV
+	if transfer.Period < m.GetPeriod()-1 {
+		return nil
+	}
+	fsmca, err := m.GetFSMCA(transfer.CAID, transfer.Period)
+	if err != nil {
+		return err
+	}
+	if m.crvAt(fsmca) != nil {
+		return nil
+	}
+	value, _ := fsmca.GetField("SRH")
+	srh, _ := value.(def.SRH)
+	crv := new(bitset.BitSet)
+	if err := crv.UnmarshalBinary(transfer.CRV); err != nil {
+		return err
+	}
+	if !srh.CommitsToCRV(crv) {
+		return errors.New("CRV does not match the SRH")
+	}
+	m.setCRV(fsmca, crv, bytesRecord("crv", transfer.CRV))
+	fmt.Println("CRV of", transfer.CAID, "in period", transfer.Period, "resynchronized")
+	return nil
+}
@@ -0,0 +1,96 @@
+package monitor
+
+import (
+	"fmt"
+
+	def "github.com/jik18001/CTngV3/def"
+	dkg "github.com/jik18001/CTngV3/dkg"
+)
+
+// runDKG replaces the threshold keys of the crypto config with keys generated jointly by the monitors.
+// The transport must already be served, and every monitor has to start within a phase of the others.
+// The keys are stored in the key file of the monitor, a restarted monitor loads them instead of running the DKG alone.
+func runDKG(m *MonitorEEA) error {
+	if !m.Settings.DKG {
+		return nil
+	}
+	loaded, err := loadThresholdKeys(m)
+	if err != nil || loaded {
+		return err
+	}
+	o := runParticipant(dkg.NewParticipant(m.CTngID, m.Crypto, m.Settings, m.Transport, m.Clock))
+	if o.err != nil {
+		return o.err
+	}
+	if err := applyThresholdKeys(m, o.result); err != nil {
+		return err
+	}
+	fmt.Println(def.BLUE+"Threshold keys generated with", len(o.result.Qualified), "qualified dealers", def.RESET)
+	return nil
+}
+
+// runResharing moves the threshold keys of the monitors of the settings to those of next, under a new threshold,
+// keeping the group key. It runs after runDKG, so a monitor reshares the keys of its key file if it holds any.
+// Every monitor of both sets has to start it within a phase of the others; a monitor only in next deals nothing.
+func runResharing(m *MonitorEEA, next *def.Settings, threshold int) error {
+	if !m.Settings.DKG {
+		if _, err := loadThresholdKeys(m); err != nil {
+			return err
+		}
+	}
+	o := runParticipant(dkg.NewResharing(m.CTngID, m.Crypto, m.Settings, next, threshold, m.Transport, m.Clock))
+	if o.err != nil {
+		return o.err
+	}
+	if err := applyThresholdKeys(m, o.result); err != nil {
+		return err
+	}
+	fmt.Println(def.BLUE+"Threshold keys reshared with", len(o.result.Qualified), "qualified dealers, threshold", o.result.Threshold, def.RESET)
+	return nil
+}
+
+type outcome struct {
+	result *dkg.Result
+	err    error
+}
+
+// runParticipant runs the phases of a key generation or resharing and waits for its outcome
+func runParticipant(participant *dkg.Participant) outcome {
+	done := make(chan outcome, 1)
+	participant.Start(func(result *dkg.Result, err error) {
+		done <- outcome{result, err}
+	})
+	return <-done
+}
+
+// loadThresholdKeys installs the threshold keys stored in the key file of the monitor, if any
+func loadThresholdKeys(m *MonitorEEA) (bool, error) {
+	if m.KeyFile == "" {
+		return false, nil
+	}
+	m.lock.Lock()
+	loaded, err := def.LoadThresholdKeys(m.Crypto, m.KeyFile, m.CTngID)
+	m.lock.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if loaded {
+		fmt.Println(def.BLUE+"Threshold keys of an earlier DKG or resharing loaded from", m.KeyFile, def.RESET)
+	}
+	return loaded, nil
+}
+
+// applyThresholdKeys installs the result in the crypto config of the monitor and stores it in its key file
+func applyThresholdKeys(m *MonitorEEA, result *dkg.Result) error {
+	m.lock.Lock()
+	result.Apply(m.Crypto, m.CTngID)
+	m.lock.Unlock()
+	if m.KeyFile == "" {
+		return nil
+	}
+	if _, ok := m.Crypto.TSS_private_map[m.CTngID]; !ok {
+		// a monitor leaving the set keeps no threshold keys
+		return nil
+	}
+	return def.WriteThresholdKeys(m.Crypto, m.KeyFile, m.CTngID)
+}
@@ -3,15 +3,13 @@ package monitor
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"reflect"
 	"time"
 
 	def "github.com/jik18001/CTngV3/def"
-	merkletree "github.com/txaty/go-merkletree"
 	//rs "github.com/klauspost/reedsolomon"
 )
 
@@ -40,6 +38,7 @@ func defaultLSMWakeup(m *MonitorEEA, lsm *FSMLoggerEEA, c def.Context) {
 		monitor_signed_data := MonitorSignedData{
 			Type:      "STH",
 			CTngID:    def.CTngID(sth_fork.LID),
+			Period:    sth_fork.PeriodNum,
 			Signature: sigstring,
 		}
 		msd_json, err := json.Marshal(monitor_signed_data)
@@ -86,23 +85,30 @@ func check_and_send_valid_sth(m *MonitorEEA, fsmlogger *FSMLoggerEEA, sth def.ST
 		log.Fatalf("Failed to marshal update: %v", err)
 	}
 	broadcastEEA(m, "/monitor/logger_update", sth_json)
+	startDefaultSTHTimer(m, fsmlogger)
+}
+
+// startDefaultSTHTimer waits for the file of the period, then signs the STH or accuses the Logger.
+// It runs once per period, from the start of the period, or from the first STH in the first period.
+func startDefaultSTHTimer(m *MonitorEEA, fsmlogger *FSMLoggerEEA) {
+	if !fsmlogger.claimTimer() {
+		return
+	}
 	NewContext := def.Context{
 		Label: def.WAKE_TM,
 	}
 	// Run this in a new goroutine
-	go func() {
-		time.AfterFunc(time.Duration(m.Settings.Verification_Wait_time)*time.Second, func() {
-			fsmlogger.SetField("TimeCheck", true)
-			value, _ := fsmlogger.GetField("DataCheck")
-			dataCheckValue, _ := value.(bool)
-			fmt.Println(m.Settings.Verification_Wait_time, dataCheckValue)
-			if dataCheckValue {
-				defaultLSMWakeup(m, fsmlogger, NewContext)
-			} else {
-				fmt.Println("Place holder for accusation.")
-			}
-		})
-	}()
+	m.Clock.AfterFunc(time.Duration(m.Settings.Verification_Wait_time)*time.Second, func() {
+		fsmlogger.SetField("TimeCheck", true)
+		value, _ := fsmlogger.GetField("DataCheck")
+		dataCheckValue, _ := value.(bool)
+		fmt.Println(m.Settings.Verification_Wait_time, dataCheckValue)
+		if dataCheckValue {
+			defaultLSMWakeup(m, fsmlogger, NewContext)
+		} else {
+			accuse(m, fsmlogger.CTngID, fsmlogger.Period)
+		}
+	})
 
 }
 
@@ -111,9 +117,12 @@ func check_and_send_conflict_sth(m *MonitorEEA, fsmlogger *FSMLoggerEEA, sth def
 	// if we already have an existing STH
 	if !reflect.DeepEqual(sth2, def.STH{}) {
 		// we compare the sth against the existing record and broadcast a cPoM when needed
-		sthsigbytes, _ := json.Marshal(sth)
-		sthsigbytes2, _ := json.Marshal(sth2)
-		if !reflect.DeepEqual(sthsigbytes, sthsigbytes2) {
+		// signatures are randomized, so only the signed contents are compared
+		unsigned, stored := sth, sth2.(def.STH)
+		unsigned.Signature, stored.Signature = def.RSASig{}, def.RSASig{}
+		sthsigbytes, _ := json.Marshal(unsigned)
+		sthsigbytes2, _ := json.Marshal(stored)
+		if !bytes.Equal(sthsigbytes, sthsigbytes2) {
 			cPoM := &def.CPoM{
 				Entity_Convicted: def.CTngID(sth.LID),
 				MetaData1:        sth,
@@ -122,26 +131,10 @@ func check_and_send_conflict_sth(m *MonitorEEA, fsmlogger *FSMLoggerEEA, sth def
 			err := fsmlogger.AddCPoM(*cPoM)
 			//This means the cPoM is the first to be added
 			if err == nil {
-				fsmlogger.SetField("State", def.POM)
+				convict(m, fsmlogger)
 				fmt.Println("Switched to PoM State")
-				STH_only_update := def.Update_Logger{
-					STH:  sth,
-					File: [][]byte{},
-				}
-				sth_json, err := json.Marshal(STH_only_update)
-				if err != nil {
-					log.Fatalf("Failed to marshal update: %v", err)
-				}
-				broadcastEEA(m, "/monitor/logger_update", sth_json)
+				broadcastCPoM(m, *cPoM)
 			}
-
-			/*
-				cPoM_json, err := json.Marshal(cPoM)
-				if err != nil {
-					log.Fatalf("Failed to marshal update: %v", err)
-				}
-				broadcastEEA(m, "/monitor/PoM", cPoM_json)
-			*/
 			return true
 		}
 	}
@@ -166,26 +159,26 @@ func check_and_send_notifcation(m *MonitorEEA, fsmlogger *FSMLoggerEEA, update d
 	}
 
 	//PoI verification
-	data := update.File
-	var dataBlocks []merkletree.DataBlock
-	for i := range data[:(m.Settings.Mal + 1)] {
-		for j := 0; j < len(data[i]); j += m.Settings.Certificate_size {
-			end := j + m.Settings.Certificate_size
-			if end > len(data[i]) {
-				end = len(data[i])
-			}
-			dataBlocks = append(dataBlocks, &def.LeafBlock{Content: data[i][j:end]})
-		}
+	file, err := update.STH.FileOf(update.File)
+	if err != nil {
+		fmt.Println("Certificate file does not match the STH:", err)
+		return
+	}
+	certificates, err := def.DecodeCertificateFile(file)
+	if err != nil {
+		fmt.Println("Failed to decode certificate file:", err)
+		return
 	}
-	// Generate Merkle Tree
-	tree, err := def.GenerateMerkleTree(dataBlocks)
+	// Generate Merkle Tree over the certificates
+	tree, err := def.GenerateMerkleTree(def.CertificateBlocks(certificates))
 	def.HandleError(err, "MT Generation")
 	rootHash := def.GenerateRootHash(tree)
-	if !reflect.DeepEqual(rootHash, update.STH.Head) {
+	if !reflect.DeepEqual(rootHash, update.STH.Head) || len(certificates) != update.STH.Size {
 		fmt.Println("PoI verification Failed!")
 		return
 	}
 	fsmlogger.SetField("Data", update.File)
+	fsmlogger.SetCertificates(certificates, nil, update.STH.Head)
 	fsmlogger.SetField("DataCheck", true)
 	value, _ := fsmlogger.GetField("TimeCheck")
 	noconf, _ := value.(bool)
@@ -200,6 +193,7 @@ func check_and_send_notifcation(m *MonitorEEA, fsmlogger *FSMLoggerEEA, update d
 		Type:       def.TUEEA,
 		Originator: def.CTngID(update.STH.LID),
 		//Monitor:    update.MonitorID,
+		Period: update.STH.PeriodNum,
 		Sender: m.Self_ip_port,
 	}
 	//fmt.Println(new_note)
@@ -211,10 +205,27 @@ func check_and_send_notifcation(m *MonitorEEA, fsmlogger *FSMLoggerEEA, update d
 }
 
 func process_logger_update(m *MonitorEEA, update def.Update_Logger) {
+	// only a valid STH of a new period moves the monitor into that period
+	STH_fork := update.STH
+	STH_fork.Signature = def.RSASig{}
+	sthBytes, err := json.Marshal(STH_fork)
+	if err != nil {
+		log.Fatalf("Failed to serialize STH: %v", err)
+	}
+	if err := m.Crypto.Verify(sthBytes, update.STH.Signature); err != nil {
+		fmt.Println("Signature Verification Failed")
+		return
+	}
+	if err := m.AdvancePeriod(update.STH.PeriodNum); err != nil {
+		fmt.Println(err)
+		return
+	}
 	// retrieve te state machine first
-	index, _ := def.MapIDtoInt(def.CTngID(update.STH.LID))
-	var fsmlogger *FSMLoggerEEA
-	fsmlogger = m.FSMLoggerEEAs[index]
+	fsmlogger, err := m.GetFSMLogger(def.CTngID(update.STH.LID), update.STH.PeriodNum)
+	if err != nil {
+		fmt.Println("Failed to locate Logger state:", err)
+		return
+	}
 	current_state, _ := fsmlogger.GetField("State")
 
 	if current_state == def.INIT {
@@ -232,69 +243,66 @@ func process_logger_update(m *MonitorEEA, update def.Update_Logger) {
 
 }
 
-func logger_update_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
-	// Create a counter to track the number of bytes read
-	var byteCounter int64
-
-	// Create a TeeReader to count bytes while reading from r.Body
-	counterReader := io.TeeReader(r.Body, &countWriter{count: &byteCounter})
+func logger_update_handler(m *MonitorEEA, data []byte) error {
 
 	// Parse the update
 	var update def.Update_Logger
-	if err := json.NewDecoder(counterReader).Decode(&update); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &update); err != nil {
+		return errors.New("Failed to decode update")
 	}
 
-	// Retrieve the FSMLogger corresponding to the STH LID in the update
-	index, _ := def.MapIDtoInt(def.CTngID(update.STH.LID))
-	fsmlogger := m.FSMLoggerEEAs[index]
+	// Process the logger update
+	process_logger_update(m, update)
+
+	// Retrieve the FSMLogger corresponding to the STH LID and period in the update
+	fsmlogger, err := m.GetFSMLogger(def.CTngID(update.STH.LID), update.STH.PeriodNum)
+	if err != nil {
+		return nil
+	}
 
 	if update.File != nil && len(update.File) > 0 {
 		fsmlogger.lock.Lock()
-		fsmlogger.TrafficCount = fsmlogger.TrafficCount + int(byteCounter)
+		fsmlogger.TrafficCount = fsmlogger.TrafficCount + len(data)
 		fsmlogger.UpdateCount = fsmlogger.UpdateCount + 1
 		fsmlogger.lock.Unlock()
 	}
-
-	// Process the logger update
-	process_logger_update(m, update)
+	return nil
 }
 
-func default_transparency_request_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func default_transparency_request_handler(m *MonitorEEA, data []byte) error {
 	var new_note def.Notification
-	if err := json.NewDecoder(r.Body).Decode(&new_note); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &new_note); err != nil {
+		return errors.New("Failed to decode update")
+	}
+	fsmlogger, err := m.GetFSMLogger(new_note.Originator, new_note.Period)
+	if err != nil {
+		return nil
 	}
-	loggerindex, _ := def.MapIDtoInt(new_note.Originator)
-	//fmt.Println(new_note.Originator, loggerindex)
-	fsmlogger := m.FSMLoggerEEAs[loggerindex]
 	//fmt.Println(fsmlogger.State)
 
 	//update, err := fsmlogger.GetUpdate(def.CTngID(new_note.Monitor))
-	data, err := fsmlogger.GetField("Data")
+	file, err := fsmlogger.GetField("Data")
 	if err != nil {
-		return
+		return nil
 	}
 
 	// Assert that data is of type [][]byte
-	dataBytes, ok := data.([][]byte)
+	dataBytes, ok := file.([][]byte)
 	if !ok {
 		// Handle the case where the type assertion fails
-		return //fmt.Errorf("failed to assert type of Data")
+		return nil //fmt.Errorf("failed to assert type of Data")
 	}
 
 	sth, err := fsmlogger.GetField("STH")
 	if err != nil {
-		return
+		return nil
 	}
 
 	// Assert that sth is of type def.STH
 	sthStruct, ok := sth.(def.STH)
 	if !ok {
 		// Handle the case where the type assertion fails
-		return //fmt.Errorf("failed to assert type of STH")
+		return nil //fmt.Errorf("failed to assert type of STH")
 	}
 	//fmt.Println(update.MonitorID)
 	update := def.Update_Logger{
@@ -305,24 +313,36 @@ func default_transparency_request_handler(m *MonitorEEA, w http.ResponseWriter,
 	if err != nil {
 		log.Fatalf("Failed to marshal update: %v", err)
 	}
-	url := "http://" + new_note.Sender + "/monitor/logger_update_EEA"
-	_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(update_json))
+	err = m.Transport.Send(new_note.Sender, "/monitor/logger_update_EEA", update_json)
 	if err != nil {
 		//fmt.Println("Failed to send update: ", err)
 	}
+	return nil
 }
-func default_transparency_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func default_transparency_notification_handler(m *MonitorEEA, data []byte) error {
 	var new_note def.Notification
-	if err := json.NewDecoder(r.Body).Decode(&new_note); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &new_note); err != nil {
+		return errors.New("Failed to decode update")
 	}
 	//fmt.Println(new_note)
 	new_note_fork := new_note
 	new_note_fork.Sender = m.Self_ip_port
 	// locate the corresponding FSMLoggerEEA
-	loggerindex, _ := def.MapIDtoInt(new_note.Originator)
-	fsmlogger := m.FSMLoggerEEAs[loggerindex]
+	fsmlogger, err := m.GetFSMLogger(new_note.Originator, new_note.Period)
+	if err != nil {
+		// the period has not started here yet, the update carries the signed STH
+		if new_note.Period > m.GetPeriod() {
+			new_note_json, err := json.Marshal(new_note_fork)
+			if err != nil {
+				log.Fatalf("Failed to marshal update: %v", err)
+			}
+			err = m.Transport.Send(new_note.Sender, "/monitor/default_transparency_request", new_note_json)
+			if err != nil {
+				//fmt.Println("Failed to send update: ", err)
+			}
+		}
+		return nil
+	}
 	if m.Settings.Broadcasting_Mode == def.MIN_WT {
 		//existing_update, _ := fsmlogger.GetUpdate(new_note.Monitor)
 		//return if we already have the update
@@ -331,17 +351,16 @@ func default_transparency_notification_handler(m *MonitorEEA, w http.ResponseWri
 		//}
 		data, err := fsmlogger.GetField("Data")
 		if err != nil {
-			return
+			return nil
 		}
 		if !reflect.DeepEqual(data, [][]byte{}) {
-			return
+			return nil
 		}
-		url := "http://" + new_note.Sender + "/monitor/transparency_request"
 		new_note_json, err := json.Marshal(new_note_fork)
 		if err != nil {
 			log.Fatalf("Failed to marshal update: %v", err)
 		}
-		_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+		err = m.Transport.Send(new_note.Sender, "/monitor/transparency_request", new_note_json)
 		if err != nil {
 			//fmt.Println("Failed to send update: ", err)
 		}
@@ -351,12 +370,11 @@ func default_transparency_notification_handler(m *MonitorEEA, w http.ResponseWri
 		// loggerindex, _ := def.MapIDtoInt(new_note.Originator)
 		// fsmlogger := m.FSMLoggerEEAs[loggerindex]
 		if fsmlogger.GetFirstNotification() == nil {
-			url := "http://" + new_note.Sender + "/monitor/transparency_request"
 			new_note_json, err := json.Marshal(new_note_fork)
 			if err != nil {
 				log.Fatalf("Failed to marshal update: %v", err)
 			}
-			_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+			err = m.Transport.Send(new_note.Sender, "/monitor/transparency_request", new_note_json)
 			if err != nil {
 				fmt.Println("Failed to send update: ", err)
 			}
@@ -364,7 +382,7 @@ func default_transparency_notification_handler(m *MonitorEEA, w http.ResponseWri
 				Label:   def.WAKE_TR,
 				Content: new_note,
 			}
-			time.AfterFunc(time.Duration(m.Settings.Response_Wait_time)*time.Second, func() {
+			m.Clock.AfterFunc(time.Duration(m.Settings.Response_Wait_time)*time.Second, func() {
 				defaultLSMWakeup(m, fsmlogger, NewContext)
 
 				//monitorindex, _ := def.MapIDtoInt(def.CTngID(new_note.Monitor))
@@ -374,8 +392,7 @@ func default_transparency_notification_handler(m *MonitorEEA, w http.ResponseWri
 				if dataCheckValue == false {
 					notifications := fsmlogger.GetNotifications()
 					for _, notification := range notifications {
-						url := "http://" + notification.Sender + "/monitor/transparency_request"
-						_, err := m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+						err := m.Transport.Send(notification.Sender, "/monitor/transparency_request", new_note_json)
 						if err != nil {
 							//fmt.Println("Failed to send update: ", err)
 						}
@@ -386,29 +403,31 @@ func default_transparency_notification_handler(m *MonitorEEA, w http.ResponseWri
 		}
 		fsmlogger.AddNotification(new_note)
 	}
-
+	return nil
 }
 
-func default_transparency_partial_signature_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func default_transparency_partial_signature_handler(m *MonitorEEA, data []byte) error {
 	//fmt.Println("MSD received")
 	var msd MonitorSignedData
-	if err := json.NewDecoder(r.Body).Decode(&msd); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &msd); err != nil {
+		return errors.New("Failed to decode update")
+	}
+	fsmlogger, err := m.GetFSMLogger(msd.CTngID, msd.Period)
+	if err != nil {
+		fmt.Println("Failed to locate Logger state:", err)
+		return nil
 	}
-	index, _ := def.MapIDtoInt(def.CTngID(msd.CTngID))
-	fsmlogger := m.FSMLoggerEEAs[index]
 
 	sth, err := fsmlogger.GetField("STH")
 	if err != nil {
 		fmt.Println("Error retrieving STH field:", err)
-		return
+		return nil
 	}
 	sth_fork, ok := sth.(def.STH)
 	if !ok {
 		// Handle the case where the assertion fails
 		fmt.Println("sth_fork is not of type STH")
-		return
+		return nil
 	}
 	sth_fork.Signature = def.RSASig{}
 	sthBytes, err := json.Marshal(sth_fork)
@@ -420,28 +439,32 @@ func default_transparency_partial_signature_handler(m *MonitorEEA, w http.Respon
 	err = m.FragmentVerify(string(sthBytes), sigfrag)
 	if err != nil {
 		fmt.Println("partial Signature verification failed: ", err)
-		return
+		return nil
 	}
 
+	// no threshold signature on the head of a convicted entity
+	if state, _ := fsmlogger.GetField("State"); state == def.POM {
+		return nil
+	}
 	if fsmlogger.IsSignatureFragmentPresent(sigfrag) {
 		//fmt.Println("partial Signature duplicates.")
-		return
+		return nil
 	}
-	if fsmlogger.IsSignaturePresent() || fsmlogger.GetSignatureListLength() >= m.Settings.Mal+1 {
+	if fsmlogger.IsSignaturePresent() || fsmlogger.GetSignatureListLength() >= m.Crypto.Threshold {
 		//fmt.Println("Threshold Signature already exists.")
-		return
+		return nil
 	}
 	fsmlogger.AddSignatureFragment(sigfrag)
 	fmt.Println("number of partial Signatures: ", fsmlogger.GetSignatureListLength())
 	//fmt.Println("number of partial Signatures: ", fsmlogger.Signaturelist)
-	if fsmlogger.GetSignatureListLength() == m.Settings.Mal+1 {
+	if fsmlogger.GetSignatureListLength() == m.Crypto.Threshold {
 		sig := m.Aggregate(fsmlogger.Signaturelist)
 		fsmlogger.SetField("Signature", sig)
 		//fsmlogger.Signature = sig
 		// Retrieve the start time
 		startTime := fsmlogger.GetStartTime()
 		// Calculate the elapsed time
-		elapsedTime := time.Since(startTime)
+		elapsedTime := m.Clock.Now().Sub(startTime)
 		fsmlogger.SetField("Convergetime", elapsedTime)
 		//fsmlogger.ConvergeTime = elapsedTime
 		// Print or log the elapsed time
@@ -452,4 +475,5 @@ func default_transparency_partial_signature_handler(m *MonitorEEA, w http.Respon
 		log.Fatalf("Failed to marshal update: %v", err)
 	}
 	broadcastEEA(m, "/monitor/default_transparency_partial_signature", msd_json)
+	return nil
 }
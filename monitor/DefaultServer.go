@@ -9,35 +9,42 @@ import (
 
 	"github.com/gorilla/mux"
 	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
 )
 
-func handleRequests(m *MonitorEEA) {
-	// MUX which routes HTTP directories to functions.
-	gorillaRouter := mux.NewRouter().StrictSlash(true)
-	//endpoints
+// RegisterDefaultHandlers routes the protocol messages of a default monitor on its transport
+func RegisterDefaultHandlers(m *MonitorEEA) {
+	t := m.Transport
 	//---------------------------------Shared------------------------------------------------------------------------
-	gorillaRouter.HandleFunc("/monitor/PoM", bindContext(m, PoM_handler)).Methods("POST")
+	t.Register("/monitor/PoM", bindMessage(m, PoM_handler))
+	t.Register("/monitor/accusation", bindMessage(m, accusation_handler))
 	//---------------------------------Transparency Updates----------------------------------------------------------
-	gorillaRouter.HandleFunc("/monitor/logger_update", bindContext(m, logger_update_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/default_transparency_notification", bindContext(m, default_transparency_notification_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/default_transparency_request", bindContext(m, default_transparency_request_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/default_transparency_partial_signature", bindContext(m, default_transparency_partial_signature_handler)).Methods("POST")
+	t.Register("/monitor/logger_update", bindMessage(m, logger_update_handler))
+	t.Register("/monitor/default_transparency_notification", bindMessage(m, default_transparency_notification_handler))
+	t.Register("/monitor/default_transparency_request", bindMessage(m, default_transparency_request_handler))
+	t.Register("/monitor/default_transparency_partial_signature", bindMessage(m, default_transparency_partial_signature_handler))
 	//---------------------------------Revocation Updates----------------------------------------------------------
 	// Use the EEA version for the CA regardless since we did not implement the base version
-	//gorillaRouter.HandleFunc("/monitor/ca_update_EEA", bindContext(m, ca_update_EEA_handler)).Methods("POST")
-	//gorillaRouter.HandleFunc("/monitor/SRH", bindContext(m, ca_srh_handler)).Methods("POST")
-	//gorillaRouter.HandleFunc("/monitor/revocation_notification", bindContext(m, revocation_notification_handler)).Methods("POST")
-	//gorillaRouter.HandleFunc("/monitor/revocation_request", bindContext(m, revocation_request_handler)).Methods("POST")
-	//gorillaRouter.HandleFunc("/monitor/revocation_partial_signature", bindContext(m, revocation_partial_signature_handler)).Methods("POST")
-	// gorillaRouter.HandleFunc("/monitor/ca_update_EEA", bindContext(m, ca_update_EEA_handler)).Methods("POST")
-	// gorillaRouter.HandleFunc("/monitor/SRH", bindContext(m, ca_srh_handler)).Methods("POST")
-	// gorillaRouter.HandleFunc("/monitor/revocation_notification", bindContext(m, revocation_notification_handler)).Methods("POST")
-	// gorillaRouter.HandleFunc("/monitor/revocation_request", bindContext(m, revocation_request_handler)).Methods("POST")
-	// gorillaRouter.HandleFunc("/monitor/revocation_partial_signature", bindContext(m, revocation_partial_signature_handler)).Methods("POST")
+	//t.Register("/monitor/ca_update_EEA", bindMessage(m, ca_update_EEA_handler))
+	//t.Register("/monitor/SRH", bindMessage(m, ca_srh_handler))
+	//t.Register("/monitor/revocation_notification", bindMessage(m, revocation_notification_handler))
+	//t.Register("/monitor/revocation_request", bindMessage(m, revocation_request_handler))
+	//t.Register("/monitor/revocation_partial_signature", bindMessage(m, revocation_partial_signature_handler))
+}
+
+// NewDefaultRouter makes a default monitor send its messages over HTTP with the client, the router serves every endpoint
+func NewDefaultRouter(m *MonitorEEA, client *http.Client) *mux.Router {
+	t := newHTTP(m, client)
+	m.Transport = injectFaults(m, newSealingTransport(m, t))
+	RegisterDefaultHandlers(m)
+	registerQueries(m, t.Router)
+	return t.Router
+}
+
+func handleRequests(m *MonitorEEA, gorillaRouter *mux.Router) {
 	// Start the HTTP server.
-	http.Handle("/", gorillaRouter)
 	fmt.Println(def.BLUE+"(default) Listening on port:", m.Settings.Portmap[m.CTngID], def.RESET)
-	err := http.ListenAndServe(":"+m.Settings.Portmap[m.CTngID], nil)
+	err := transport.ListenAndServe(m.Settings.Portmap[m.CTngID], gorillaRouter, tlsConfig(m))
 	// We wont get here unless there's an error.
 	log.Fatal("ListenAndServe: ", err)
 	os.Exit(1)
@@ -50,17 +57,21 @@ func StartMonitorServer(m *MonitorEEA) {
 		WriteBufferSize:     1024 * 1024, // 1MB
 		ReadBufferSize:      1024 * 1024, // 1MB
 	}
-	m.Client = &http.Client{
+	gorillaRouter := NewDefaultRouter(m, &http.Client{
 		Transport: tr,
+	})
+	restoreFromStorage(m)
+	// HTTP Server Loop, it serves the key generation before the periods start
+	go handleRequests(m, gorillaRouter)
+	if err := runDKG(m); err != nil {
+		log.Fatalf("Key generation failed: %v", err)
 	}
-	// HTTP Server Loop
-	//go PeriodicTasks(m)
-	filename := m.CTngID.String() + ".json"
-	m.DumpConvergeTimesToFile(filename)
-	f := func() {
-		m.DumpConvergeTimesToFile(filename)
+	if m.Settings.Reshare {
+		if err := runResharing(m, m.Settings, m.Settings.Mal+1); err != nil {
+			log.Fatalf("Resharing failed: %v", err)
+		}
 	}
-	time.AfterFunc(time.Duration(m.Settings.MUD)*time.Second, f)
+	PeriodicTasks(m)
 	fmt.Println("Current Time:", time.Now().Format(time.RFC3339))
-	handleRequests(m)
+	select {}
 }
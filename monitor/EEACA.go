@@ -3,15 +3,14 @@ package monitor
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"reflect"
 	"time"
 
+	bitset "github.com/bits-and-blooms/bitset"
 	def "github.com/jik18001/CTngV3/def"
-	rs "github.com/klauspost/reedsolomon"
 )
 
 func CSMWakeup(m *MonitorEEA, fsmca *FSMCAEEA, c def.Context) {
@@ -21,7 +20,7 @@ func CSMWakeup(m *MonitorEEA, fsmca *FSMCAEEA, c def.Context) {
 
 	case def.WAKE_TM:
 		fmt.Println("WAKE_TM event triggered.")
-		if !reflect.DeepEqual(fsmca.APoM, def.APoM{}) || !reflect.DeepEqual(fsmca.CPoM, def.CPoM{}) {
+		if !reflect.DeepEqual(fsmca.APoM, def.APoM{}) || !reflect.DeepEqual(fsmca.CPoM, def.CPoM{}) || !reflect.DeepEqual(fsmca.BPoM, def.BPoM{}) {
 			fmt.Println("PoM present")
 			return
 		}
@@ -36,6 +35,7 @@ func CSMWakeup(m *MonitorEEA, fsmca *FSMCAEEA, c def.Context) {
 		monitor_signed_data := MonitorSignedData{
 			Type:      "SRH",
 			CTngID:    def.CTngID(srh_fork.CAID),
+			Period:    srh_fork.PeriodNum,
 			Signature: sigstring,
 		}
 		msd_json, err := json.Marshal(monitor_signed_data)
@@ -55,13 +55,12 @@ func CSMWakeup(m *MonitorEEA, fsmca *FSMCAEEA, c def.Context) {
 		fmt.Println("WAKE_TR event triggered.")
 		// Attempt to retrieve missing data fragments as done in logger code
 		if content, ok := c.Content.(def.Notification); ok {
-			// Map Originator (CA ID) to index
-			caindex, err := def.MapIDtoInt(content.Originator)
+			// Locate the CA state machine of the notified period
+			fsmca, err := m.GetFSMCA(content.Originator, content.Period)
 			if err != nil {
-				log.Printf("Failed to map Originator ID to int: %v", err)
+				log.Printf("Failed to locate CA state: %v", err)
 				return
 			}
-			fsmca := m.FSMCAEEAs[caindex]
 
 			// Determine the data fragment index from the Monitor ID
 			dataFragmentIndex, err := def.MapIDtoInt(def.CTngID(content.Monitor))
@@ -92,15 +91,11 @@ func CSMWakeup(m *MonitorEEA, fsmca *FSMCAEEA, c def.Context) {
 				// we will try contacting all known notification senders.
 				notifications := fsmca.GetNotifications()
 				for _, notification := range notifications {
-					url := "http://" + notification.Sender + "/monitor/revocation_request"
-					response, err := m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+					err := m.Transport.Send(notification.Sender, "/monitor/revocation_request", new_note_json)
 					if err != nil {
 						log.Printf("Failed to send revocation request to %s: %v", notification.Sender, err)
 						continue
 					}
-					if response.Body != nil {
-						response.Body.Close()
-					}
 				}
 			} else {
 				// Data fragment already available, no action needed
@@ -125,15 +120,25 @@ func process_ca_update_EEA(m *MonitorEEA, srh def.SRH, update def.Update_CA_EEA)
 		return
 	}
 
-	index, _ := def.MapIDtoInt(def.CTngID(srh.CAID))
-	fsmca := m.FSMCAEEAs[index]
+	// A valid SRH of a new period moves the monitor into that period
+	if err := m.AdvancePeriod(srh.PeriodNum); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fsmca, err := m.GetFSMCA(def.CTngID(srh.CAID), srh.PeriodNum)
+	if err != nil {
+		fmt.Println("Failed to locate CA state:", err)
+		return
+	}
 	srh2, _ := fsmca.GetField("SRH")
 
 	// Check for conflicting SRH (PoM)
 	if !reflect.DeepEqual(srh2, def.SRH{}) {
-		srhsigbytes, _ := json.Marshal(srh)
-		srhsigbytes2, _ := json.Marshal(srh2)
-		if !reflect.DeepEqual(srhsigbytes, srhsigbytes2) {
+		// signatures are randomized, so only the signed contents are compared
+		stored, _ := srh2.(def.SRH)
+		stored.Signature = def.RSASig{}
+		srhsigbytes2, _ := json.Marshal(stored)
+		if !bytes.Equal(srhBytes, srhsigbytes2) {
 			cPoM := &def.CPoM{
 				Entity_Convicted: def.CTngID(SRH_fork.CAID),
 				MetaData1:        srh,
@@ -142,25 +147,11 @@ func process_ca_update_EEA(m *MonitorEEA, srh def.SRH, update def.Update_CA_EEA)
 
 			// Attempt to add the CPoM to the FSMCAEEA instance
 			err := fsmca.AddCPoM(*cPoM)
-			// If this is the first CPoM, change the state and broadcast a minimal update
+			// If this is the first CPoM, change the state and gossip the CPoM
 			if err == nil {
-				fsmca.SetField("State", def.POM)
+				convict(m, fsmca)
 				fmt.Println("Switched to PoM State")
-
-				// Create a minimal CA update with just the SRH and no file shares
-				SRH_only_update := def.Update_CA_EEA{
-					SRH:       srh,
-					FileShare: []byte{},
-					Head_rs:   []byte{},
-					PoI:       def.PoI{},
-				}
-				srh_json, err := json.Marshal(SRH_only_update)
-				if err != nil {
-					log.Fatalf("Failed to marshal update: %v", err)
-				}
-
-				// Broadcast the minimal CA update to inform all monitors
-				broadcastEEA(m, "/monitor/ca_update_EEA", srh_json)
+				broadcastCPoM(m, *cPoM)
 			}
 			return
 		}
@@ -172,16 +163,22 @@ func process_ca_update_EEA(m *MonitorEEA, srh def.SRH, update def.Update_CA_EEA)
 		return
 	}
 
-	// Verify PoI for the fragment
-	ok, _ := def.VerifyPOI2(update.Head_rs, update.PoI.Proof, update.FileShare)
-	if !ok {
+	// Only the SRH signs Head_rs, the fragment is checked against the root it commits to
+	if !srh.CommitsTo(update.Head_rs) {
+		fmt.Println("Head_rs not signed in the SRH")
+		return
+	}
+	head_rs := update.Head_rs
+	// Verify PoI for the fragment, at the position of the monitor it was sent to
+	monitorindex, err := def.MapIDtoInt(def.CTngID(update.MonitorID))
+	ok, _ := def.VerifyPOI2(head_rs, update.PoI.Proof, update.FileShare)
+	if err != nil || !ok || def.PoIIndex(update.PoI) != monitorindex {
 		fmt.Println("Data Fragment Verification Failed")
 		return
 	}
 
 	// Store the update and add the data fragment
 	fsmca.StoreUpdate(update.MonitorID, update)
-	monitorindex, _ := def.MapIDtoInt(def.CTngID(update.MonitorID))
 	frag, _ := fsmca.GetDataFragment(monitorindex)
 	if reflect.DeepEqual(frag, update.FileShare) {
 		return
@@ -191,42 +188,43 @@ func process_ca_update_EEA(m *MonitorEEA, srh def.SRH, update def.Update_CA_EEA)
 	// Check if we have enough fragments to reconstruct
 	counter := fsmca.GetDataFragmentCounter()
 	fmt.Println("Number of data fragments collected:", counter)
-	//required := m.Settings.Num_Monitors - m.Settings.Mal
-	required := m.Settings.Mal + 1
-	if counter == required {
-		dec, err := rs.New(required, m.Settings.Num_Monitors-required)
-		if err != nil {
-			log.Fatalf("Error initializing Reed-Solomon decoder: %v", err)
-		}
-		fileShares := fsmca.GetDataFragments()
-
-		// Reconstruct the data
-		err = dec.Reconstruct(fileShares)
-		if err != nil {
-			log.Fatalf("Error during Reed-Solomon decoding: %v", err)
+	// The SRH carries the erasure coding parameters, a CA that signs unusable ones never passes the data check
+	params, paramsErr := def.HeadErasure(srh.Erasure, m.Settings.Num_Monitors, m.Settings.Mal)
+	if paramsErr != nil {
+		fmt.Println("Unusable erasure coding parameters:", paramsErr)
+	}
+	// Decode from any k verified shares, a failed attempt is retried with every later share
+	value, _ := fsmca.GetField("DataCheck")
+	decoded, _ := value.(bool)
+	if paramsErr == nil && counter >= params.K && !decoded {
+		// The data shards concatenated hold the compressed DCRV (just like the CA used dcrv)
+		shares, err := decodeShares(params, fsmca.GetDataFragments(), head_rs)
+		concatenatedData := bytes.Join(shares, nil)
+		if err == nil && (update.OriginalLen < 0 || update.OriginalLen > len(concatenatedData)) {
+			err = fmt.Errorf("DCRV length %d exceeds the %d decoded bytes", update.OriginalLen, len(concatenatedData))
 		}
-
-		// Concatenate the first 'required' shards to get the compressed DCRV (just like the CA used dcrv)
-		concatenatedData := []byte{}
-		for _, shard := range fileShares[:required] {
-			concatenatedData = append(concatenatedData, shard...)
-		}
-
-		// After reconstructing and concatenating the shards:
-		concatenatedData = concatenatedData[:update.OriginalLen]
-
-		// Compute hcrv and hdcrv as CA did by hashing the exact original dcrv
-		hcrv, _ := def.GenerateSHA256(concatenatedData)
-		hdcrv, _ := def.GenerateSHA256(concatenatedData)
-
-		// Recreate SRH.Head as the CA did: hcrv || hdcrv || update.Head_rs
-		recreatedHead := append(hcrv, hdcrv...)
-		recreatedHead = append(recreatedHead, update.Head_rs...)
-
-		// Compare recreatedHead with srh.Head from the CA
-		if !reflect.DeepEqual(recreatedHead, srh.Head) {
-			fmt.Println("SRH.Head mismatch! Data verification failed.")
+		var crv *bitset.BitSet
+		if err == errBadEncoding {
+			bpom := def.BPoM{Entity_Convicted: def.CTngID(srh.CAID), SRH: &srh, Head_rs: head_rs}
+			proveBadEncoding(m, fsmca, bpom, params, func(index int) ([]byte, def.PoI, bool) {
+				u, err := fsmca.GetUpdate(monitorAt(index))
+				return u.FileShare, u.PoI, err == nil
+			})
+		} else if err != nil {
+			fmt.Println("Failed to reconstruct the DCRV, shares withheld, badly encoded or not enough yet:", err)
+		} else if crv, err = checkRevocationData(m, srh, concatenatedData[:update.OriginalLen], head_rs); err != nil {
+			fmt.Println("SRH.Head mismatch! Data verification failed:", err)
+			// Peers that verified the CRV against this hcrv resynchronize this monitor, the CA is accused meanwhile
+			if err == errCRVMismatch {
+				requestCRV(m, def.CTngID(srh.CAID), srh.PeriodNum)
+			}
 		} else {
+			// The CRV is fetched from the peers if this monitor missed the previous period
+			if crv != nil {
+				m.setCRV(fsmca, crv, bytesRecord("dcrv", concatenatedData[:update.OriginalLen]))
+			} else {
+				requestCRV(m, def.CTngID(srh.CAID), srh.PeriodNum)
+			}
 			// If verification passes
 			fsmca.SetField("DataCheck", true)
 			fmt.Println("Data reconstruction and verification succeeded. DataCheck set to true.")
@@ -246,6 +244,7 @@ func process_ca_update_EEA(m *MonitorEEA, srh def.SRH, update def.Update_CA_EEA)
 		Type:       def.TUEEA,
 		Originator: def.CTngID(update.SRH.CAID),
 		Monitor:    update.MonitorID,
+		Period:     update.SRH.PeriodNum,
 		Sender:     m.Self_ip_port,
 	}
 	new_note_json, err := json.Marshal(new_note)
@@ -264,97 +263,132 @@ func process_ca_update_EEA(m *MonitorEEA, srh def.SRH, update def.Update_CA_EEA)
 			log.Fatalf("Failed to marshal update: %v", err)
 		}
 		broadcastEEA(m, "/monitor/SRH", srh_json)
+		startSRHTimer(m, fsmca)
+	}
+}
 
-		NewContext := def.Context{
-			Label: def.WAKE_TM,
+// startSRHTimer waits for the data of the period, then signs the SRH or accuses the CA.
+// It runs once per period, from the start of the period, or from the first SRH in the first period.
+func startSRHTimer(m *MonitorEEA, fsmca *FSMCAEEA) {
+	if !fsmca.claimTimer() {
+		return
+	}
+	NewContext := def.Context{
+		Label: def.WAKE_TM,
+	}
+	m.Clock.AfterFunc(time.Duration(m.Settings.Mature_Wait_time+m.Settings.Verification_Wait_time)*time.Second, func() {
+		fsmca.SetField("TimeCheck", true)
+		value, _ := fsmca.GetField("DataCheck")
+		dataCheckValue, _ := value.(bool)
+		if dataCheckValue {
+			CSMWakeup(m, fsmca, NewContext)
+		} else {
+			accuse(m, fsmca.CTngID, fsmca.Period)
 		}
-		go func() {
-			time.AfterFunc(time.Duration(m.Settings.Mature_Wait_time+m.Settings.Verification_Wait_time)*time.Second, func() {
-				fsmca.SetField("TimeCheck", true)
-				value, _ := fsmca.GetField("DataCheck")
-				dataCheckValue, _ := value.(bool)
-				if dataCheckValue {
-					CSMWakeup(m, fsmca, NewContext)
-				} else {
-					fmt.Println("Place holder for accusation.")
-				}
-			})
-		}()
+	})
+}
+
+// errCRVMismatch is a CRV that does not hash to the hcrv of the SRH, its previous CRV being verified
+var errCRVMismatch = errors.New("CRV does not match the SRH")
+
+// checkRevocationData checks the reconstructed DCRV against the SRH head (hcrv || hdcrv || Head_rs)
+// and returns the CRV after the period, nil when the CRV of the previous period is unknown.
+func checkRevocationData(m *MonitorEEA, srh def.SRH, dcrvbytes []byte, head_rs []byte) (*bitset.BitSet, error) {
+	hdcrv, _ := def.GenerateSHA256(dcrvbytes)
+	if len(srh.Head) != 2*len(hdcrv)+len(head_rs) {
+		return nil, errors.New("malformed SRH head")
 	}
+	hcrv := srh.Head[:len(hdcrv)]
+	if !bytes.Equal(srh.Head[len(hcrv):], append(hdcrv, head_rs...)) {
+		return nil, errors.New("DCRV does not match the SRH")
+	}
+	dcrv, err := def.DecompressDCRV(dcrvbytes)
+	if err != nil {
+		return nil, err
+	}
+	prev, known := previousCRV(m, def.CTngID(srh.CAID), srh.PeriodNum)
+	if !known {
+		return nil, nil
+	}
+	crv := def.ApplyDCRV(prev, dcrv)
+	if !bytes.Equal(def.CRVHash(crv), hcrv) {
+		return nil, errCRVMismatch
+	}
+	return crv, nil
 }
 
-func ca_srh_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func ca_srh_handler(m *MonitorEEA, data []byte) error {
 	var srh def.SRH
-	var byteCounter int64
-	counterReader := io.TeeReader(r.Body, &countWriter{count: &byteCounter})
 
-	if err := json.NewDecoder(counterReader).Decode(&srh); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &srh); err != nil {
+		return errors.New("Failed to decode update")
 	}
 
 	process_ca_update_EEA(m, srh, def.Update_CA_EEA{})
+	return nil
 }
 
-func ca_update_EEA_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
-	var byteCounter int64
-	counterReader := io.TeeReader(r.Body, &countWriter{count: &byteCounter})
-
+func ca_update_EEA_handler(m *MonitorEEA, data []byte) error {
 	var update def.Update_CA_EEA
-	if err := json.NewDecoder(counterReader).Decode(&update); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &update); err != nil {
+		return errors.New("Failed to decode update")
 	}
 
-	index, _ := def.MapIDtoInt(def.CTngID(update.SRH.CAID))
-	fsmca := m.FSMCAEEAs[index]
 	// Print the Logger ID (LID) and Monitor ID (MID)
 	fmt.Printf("Processing update from CA ID (CAID): %s, Monitor ID (MID): %s\n", update.SRH.CAID, update.MonitorID)
+	fmt.Println("Update received, originally assigned to: ", update.MonitorID)
+	// The state machine of a new period only exists once the SRH of that period is verified
+	process_ca_update_EEA(m, update.SRH, update)
+
+	fsmca, err := m.GetFSMCA(def.CTngID(update.SRH.CAID), update.SRH.PeriodNum)
+	if err != nil {
+		return errors.New("Invalid CA ID or period in SRH")
+	}
 	trafficcountInterface, _ := fsmca.GetField("TrafficCount")
 	trafficcount := trafficcountInterface.(int)
 
-	newcount := trafficcount + int(byteCounter)
+	newcount := trafficcount + len(data)
 	fsmca.SetField("TrafficCount", newcount)
 
 	updatecountInterface, _ := fsmca.GetField("UpdateCount")
 	updatecount := updatecountInterface.(int)
 	newucount := updatecount + 1
 	fsmca.SetField("UpdateCount", newucount)
-	fmt.Println("Update received, originally assigned to: ", update.MonitorID)
-	process_ca_update_EEA(m, update.SRH, update)
+	return nil
 }
 
-func revocation_request_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func revocation_request_handler(m *MonitorEEA, data []byte) error {
 	fmt.Println("request received")
 	var new_note def.Notification
-	if err := json.NewDecoder(r.Body).Decode(&new_note); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &new_note); err != nil {
+		return errors.New("Failed to decode update")
+	}
+	fsmca, err := m.GetFSMCA(new_note.Originator, new_note.Period)
+	if err != nil {
+		fmt.Println("Failed to locate CA state:", err)
+		return nil
 	}
-	caindex, _ := def.MapIDtoInt(new_note.Originator)
-	fsmca := m.FSMCAEEAs[caindex]
 
 	update, err := fsmca.GetUpdate(def.CTngID(new_note.Monitor))
 	if err != nil {
 		fmt.Println("Failed to fetch update.", err)
-		return
+		return nil
 	}
 	update_json, err := json.Marshal(update)
 	if err != nil {
 		log.Fatalf("Failed to marshal update: %v", err)
 	}
-	url := "http://" + new_note.Sender + "/monitor/ca_update_EEA"
-	_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(update_json))
+	err = m.Transport.Send(new_note.Sender, "/monitor/ca_update_EEA", update_json)
 	if err != nil {
 		fmt.Println("Failed to send update: ", err)
 	}
+	return nil
 }
 
-func revocation_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func revocation_notification_handler(m *MonitorEEA, data []byte) error {
 	var new_note def.Notification
-	if err := json.NewDecoder(r.Body).Decode(&new_note); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &new_note); err != nil {
+		return errors.New("Failed to decode update")
 	}
 	fmt.Println("Notification received, originally assigned to: ", new_note.Monitor, " with CAID = ", new_note.Originator)
 
@@ -362,22 +396,30 @@ func revocation_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *ht
 	new_note_fork := new_note
 	new_note_fork.Sender = m.Self_ip_port
 
-	// Map Originator ID (CA ID) to CA index
-	caindex, err := def.MapIDtoInt(new_note.Originator)
+	// Retrieve the corresponding FSMCAEEA of the notified period
+	fsmca, err := m.GetFSMCA(new_note.Originator, new_note.Period)
 	if err != nil {
-		http.Error(w, "Failed to map Originator ID to CA index", http.StatusBadRequest)
-		return
+		// The period has not started here yet, request the update directly since it carries the signed SRH
+		if new_note.Period <= m.GetPeriod() {
+			return errors.New("Failed to locate CA state")
+		}
+		new_note_json, err := json.Marshal(new_note_fork)
+		if err != nil {
+			log.Fatalf("Failed to marshal notification: %v", err)
+		}
+		err = m.Transport.Send(new_note.Sender, "/monitor/revocation_request", new_note_json)
+		if err != nil {
+			fmt.Println("Failed to send revocation request:", err)
+		}
+		return nil
 	}
-
-	// Retrieve the corresponding FSMCAEEA
-	fsmca := m.FSMCAEEAs[caindex]
+	caindex := def.GetIndex(new_note.Originator)
 
 	// Map Monitor ID to data fragment index
 	dataFragmentIndex, err := def.MapIDtoInt(new_note.Monitor)
 
 	if err != nil {
-		http.Error(w, "Failed to map Monitor ID to data fragment index", http.StatusBadRequest)
-		return
+		return errors.New("Failed to map Monitor ID to data fragment index")
 	}
 
 	// Retrieve the Bmode for this fragment
@@ -385,26 +427,25 @@ func revocation_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *ht
 	if err != nil {
 		// If no Bmode for this fragment, handle gracefully or set a default
 		fmt.Println("Failed to get Bmode for fragment:", err)
-		return
+		return nil
 	}
 	// Check if we already have the update
 	existing_update, _ := fsmca.GetUpdate(new_note.Monitor)
 	if !reflect.DeepEqual(existing_update, def.Update_CA_EEA{}) {
 		// We already have this update, so no need to request it again
 		//fmt.Println("dup update")
-		return
+		return nil
 	}
 
 	// Logic depends on the current broadcasting mode
 	if bmode == def.MIN_WT {
 		// If Bmode is MIN_WT, we send a revocation request immediately
-		url := "http://" + new_note.Sender + "/monitor/revocation_request"
 		new_note_json, err := json.Marshal(new_note_fork)
 		if err != nil {
 			log.Fatalf("Failed to marshal notification: %v", err)
 		}
 
-		_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+		err = m.Transport.Send(new_note.Sender, "/monitor/revocation_request", new_note_json)
 		if err != nil {
 			fmt.Println("Failed to send revocation request:", err)
 		}
@@ -420,13 +461,12 @@ func revocation_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *ht
 		if firstNotification == nil {
 			// If no first notification, send a revocation request and schedule WAKE_TR
 			fmt.Println("request sent, TR started")
-			url := "http://" + new_note.Sender + "/monitor/revocation_request"
 			new_note_json, err := json.Marshal(new_note_fork)
 			if err != nil {
 				log.Fatalf("Failed to marshal notification: %v", err)
 			}
 
-			_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+			err = m.Transport.Send(new_note.Sender, "/monitor/revocation_request", new_note_json)
 			if err != nil {
 				fmt.Println("Failed to send revocation request:", err)
 			}
@@ -436,7 +476,7 @@ func revocation_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *ht
 				Label:   def.WAKE_TR,
 				Content: new_note,
 			}
-			time.AfterFunc(time.Duration(m.Settings.Update_Wait_time)*time.Second, func() {
+			m.Clock.AfterFunc(time.Duration(m.Settings.Update_Wait_time)*time.Second, func() {
 				CSMWakeup(m, fsmca, NewContext)
 			})
 		}
@@ -447,27 +487,30 @@ func revocation_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *ht
 			log.Fatalf("Failed to add notification to fragment: %v", err)
 		}
 	}
+	return nil
 }
 
-func revocation_partial_signature_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func revocation_partial_signature_handler(m *MonitorEEA, data []byte) error {
 	//fmt.Println("MSD received")
 	var msd MonitorSignedData
-	if err := json.NewDecoder(r.Body).Decode(&msd); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &msd); err != nil {
+		return errors.New("Failed to decode update")
+	}
+	fsmca, err := m.GetFSMCA(msd.CTngID, msd.Period)
+	if err != nil {
+		fmt.Println("Failed to locate CA state:", err)
+		return nil
 	}
-	index, _ := def.MapIDtoInt(def.CTngID(msd.CTngID))
-	fsmca := m.FSMCAEEAs[index]
 
 	srh, err := fsmca.GetField("SRH")
 	if err != nil {
 		fmt.Println("Error retrieving SRH field:", err)
-		return
+		return nil
 	}
 	srh_fork, ok := srh.(def.SRH)
 	if !ok {
 		fmt.Println("srh_fork is not of type SRH")
-		return
+		return nil
 	}
 	srh_fork.Signature = def.RSASig{}
 	srhBytes, err := json.Marshal(srh_fork)
@@ -478,23 +521,27 @@ func revocation_partial_signature_handler(m *MonitorEEA, w http.ResponseWriter,
 	err = m.FragmentVerify(string(srhBytes), sigfrag)
 	if err != nil {
 		fmt.Println("partial Signature verification failed: ", err)
-		return
+		return nil
 	}
 
+	// no threshold signature on the head of a convicted entity
+	if state, _ := fsmca.GetField("State"); state == def.POM {
+		return nil
+	}
 	if fsmca.IsSignatureFragmentPresent(sigfrag) {
-		return
+		return nil
 	}
-	if fsmca.IsSignaturePresent() || fsmca.GetSignatureListLength() >= m.Settings.Mal+1 {
-		return
+	if fsmca.IsSignaturePresent() || fsmca.GetSignatureListLength() >= m.Crypto.Threshold {
+		return nil
 	}
 	fsmca.AddSignatureFragment(sigfrag)
 	fmt.Println("number of partial Signatures: ", fsmca.GetSignatureListLength())
-	if fsmca.GetSignatureListLength() == m.Settings.Mal+1 {
+	if fsmca.GetSignatureListLength() == m.Crypto.Threshold {
 		sig := m.Aggregate(fsmca.Signaturelist)
 		fsmca.SetField("Signature", sig)
 
 		startTime := fsmca.GetStartTime()
-		elapsedTime := time.Since(startTime)
+		elapsedTime := m.Clock.Now().Sub(startTime)
 		// Use SetField for concurrency safety
 		fsmca.SetField("Convergetime", elapsedTime)
 
@@ -505,4 +552,5 @@ func revocation_partial_signature_handler(m *MonitorEEA, w http.ResponseWriter,
 		log.Fatalf("Failed to marshal update: %v", err)
 	}
 	broadcastEEA(m, "/monitor/revocation_partial_signature", msd_json)
+	return nil
 }
@@ -1,11 +1,13 @@
 package monitor
 
 import (
+	"encoding/json"
 	"errors"
 	"reflect"
 	"sync"
 	"time"
 
+	bitset "github.com/bits-and-blooms/bitset"
 	def "github.com/jik18001/CTngV3/def"
 )
 
@@ -21,17 +23,24 @@ type FSMCAEEA struct {
 	DataFragment_Counter int
 	DataCheck            bool
 	TimeCheck            bool
+	timerStarted         bool // The verification timer of the period is running or ran
+	pruned               bool // The shares are released and no longer persisted
 	Signaturelist        []def.SigFragment
 	Signature            def.ThresholdSig
+	Accusationlist       []def.SigFragment
 	APoM                 def.APoM
 	CPoM                 def.CPoM
+	BPoM                 def.BPoM
 	TrafficCount         int
 	UpdateCount          int
 	StartTime            time.Time
 	ConvergeTime         time.Duration
+	PoMTime              time.Duration // Time it takes to hold the first PoM against this CA
 	Bmode                string
 	Bmodes               []string
 	EEA_Notifications    [][]def.Notification
+	CRV                  *bitset.BitSet // Cumulative CRV after this period, only set once it matched the SRH and until pruned
+	store                Storage        // Persists every change, nil keeps the state in memory only
 }
 
 // NewFSMCAEEA creates a new instance of FSMCAEEA with initialized maps and slices
@@ -86,6 +95,12 @@ func (ca *FSMCAEEA) SetField(field string, value interface{}) error {
 		} else {
 			return errors.New("invalid type for CPoM")
 		}
+	case "BPoM":
+		if v, ok := value.(def.BPoM); ok {
+			ca.BPoM = v
+		} else {
+			return errors.New("invalid type for BPoM")
+		}
 	case "APoM":
 		if v, ok := value.(def.APoM); ok {
 			ca.APoM = v
@@ -128,6 +143,12 @@ func (ca *FSMCAEEA) SetField(field string, value interface{}) error {
 		} else {
 			return errors.New("invalid type for Convergetime")
 		}
+	case "PoMtime":
+		if v, ok := value.(time.Duration); ok {
+			ca.PoMTime = v
+		} else {
+			return errors.New("invalid type for PoMtime")
+		}
 	case "Bmode":
 		if v, ok := value.(string); ok {
 			ca.Bmode = v
@@ -137,6 +158,9 @@ func (ca *FSMCAEEA) SetField(field string, value interface{}) error {
 	default:
 		return errors.New("unknown field")
 	}
+	if rec, ok := fieldRecord(field, value); ok {
+		ca.persist(rec)
+	}
 	return nil
 }
 
@@ -154,6 +178,8 @@ func (ca *FSMCAEEA) GetField(field string) (interface{}, error) {
 		return ca.Signature, nil
 	case "CPoM":
 		return ca.CPoM, nil
+	case "BPoM":
+		return ca.BPoM, nil
 	case "APoM":
 		return ca.APoM, nil
 	case "DataCheck":
@@ -168,6 +194,8 @@ func (ca *FSMCAEEA) GetField(field string) (interface{}, error) {
 		return ca.Period, nil
 	case "Convergetime":
 		return ca.ConvergeTime, nil
+	case "PoMtime":
+		return ca.PoMTime, nil
 	case "Bmode":
 		return ca.Bmode, nil
 	default:
@@ -228,6 +256,9 @@ func (ca *FSMCAEEA) StoreUpdate(monitorID def.CTngID, update def.Update_CA_EEA)
 		ca.Updates = make(map[def.CTngID]def.Update_CA_EEA)
 	}
 	ca.Updates[monitorID] = update
+	if raw, err := json.Marshal(update); err == nil {
+		ca.persist(Record{Kind: "update", Value: raw})
+	}
 }
 
 func (ca *FSMCAEEA) GetUpdate(monitorID def.CTngID) (def.Update_CA_EEA, error) {
@@ -280,6 +311,7 @@ func (ca *FSMCAEEA) AddSignatureFragment(signatureFragment def.SigFragment) {
 		}
 	}
 	ca.Signaturelist = append(ca.Signaturelist, signatureFragment)
+	ca.persist(sigFragmentRecord("sigfrag", signatureFragment))
 }
 
 func (ca *FSMCAEEA) IsSignatureFragmentPresent(signatureFragment def.SigFragment) bool {
@@ -411,6 +443,24 @@ func (ca *FSMCAEEA) AddCPoM(cpom def.CPoM) error {
 	}
 
 	ca.CPoM = cpom
+	if rec, ok := fieldRecord("CPoM", cpom); ok {
+		ca.persist(rec)
+	}
+	return nil
+}
+
+func (ca *FSMCAEEA) AddBPoM(bpom def.BPoM) error {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+
+	if !reflect.DeepEqual(ca.BPoM, def.BPoM{}) {
+		return errors.New("BPoM already present")
+	}
+
+	ca.BPoM = bpom
+	if rec, ok := fieldRecord("BPoM", bpom); ok {
+		ca.persist(rec)
+	}
 	return nil
 }
 
@@ -423,5 +473,67 @@ func (ca *FSMCAEEA) AddAPoM(apom def.APoM) error {
 	}
 
 	ca.APoM = apom
+	if rec, ok := fieldRecord("APoM", apom); ok {
+		ca.persist(rec)
+	}
 	return nil
 }
+
+func (ca *FSMCAEEA) AddAccusationFragment(accusationFragment def.SigFragment) bool {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+	for _, existingFragment := range ca.Accusationlist {
+		if reflect.DeepEqual(existingFragment, accusationFragment) {
+			return false
+		}
+	}
+	ca.Accusationlist = append(ca.Accusationlist, accusationFragment)
+	ca.persist(sigFragmentRecord("accusation", accusationFragment))
+	return true
+}
+
+func (ca *FSMCAEEA) GetAccusationList() []def.SigFragment {
+	ca.lock.RLock()
+	defer ca.lock.RUnlock()
+	accusationsCopy := make([]def.SigFragment, len(ca.Accusationlist))
+	copy(accusationsCopy, ca.Accusationlist)
+	return accusationsCopy
+}
+
+// Method to claim the verification timer of the period, false if it already started or ran before a restart
+func (ca *FSMCAEEA) claimTimer() bool {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+	if ca.timerStarted || ca.TimeCheck {
+		return false
+	}
+	ca.timerStarted = true
+	return true
+}
+
+// Prune releases the shares, notifications and CRV of a finished period.
+// The SRH, signatures and PoMs are kept as the record of the period.
+func (ca *FSMCAEEA) Prune() {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+	ca.Updates = make(map[def.CTngID]def.Update_CA_EEA)
+	ca.DataFragments = make([][]byte, len(ca.DataFragments))
+	ca.EEA_Notifications = make([][]def.Notification, len(ca.EEA_Notifications))
+	ca.Notifications = make([]def.Notification, 0)
+	ca.CRV = nil
+	ca.pruned = true
+}
+
+// Method to record the CRV of the period once its hash matched the SRH, see MonitorEEA.setCRV which persists it
+func (ca *FSMCAEEA) SetCRV(crv *bitset.BitSet) {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+	ca.CRV = crv
+}
+
+// Method to retrieve the CRV of the period, nil if it is not known to this monitor
+func (ca *FSMCAEEA) GetCRV() *bitset.BitSet {
+	ca.lock.RLock()
+	defer ca.lock.RUnlock()
+	return ca.CRV
+}
@@ -1,12 +1,14 @@
 package monitor
 
 import (
+	"encoding/json"
 	"errors"
 	"reflect"
 	"sync"
 	"time"
 
 	def "github.com/jik18001/CTngV3/def"
+	merkletree "github.com/txaty/go-merkletree"
 )
 
 type FSMLoggerEEA struct {
@@ -21,16 +23,26 @@ type FSMLoggerEEA struct {
 	EEA_Notifications    [][]def.Notification                 // Notifications for each data fragment
 	DataFragment_Counter int                                  // Count of Data Fragments
 	Data                 [][]byte                             // The entire certificate file
+	CertHashes           [][]byte                             // Leaf hashes of the logged certificates, kept to serve PoIs
+	Head_cert            []byte                               // Root of the certificate tree
+	Head_rs              []byte                               // Root of the share tree, only in EEA mode
+	certTree             *merkletree.MerkleTree               // Built on the first PoI request
+	store                Storage                              // Persists every change, nil keeps the state in memory only
 	DataCheck            bool                                 // Compare against the head_cert
 	TimeCheck            bool
+	timerStarted         bool               // The verification timer of the period is running or ran
+	pruned               bool               // The shares are released and no longer persisted
 	Signaturelist        []def.SigFragment  // Precommit and Post Commit State, sign over the STH
 	Signature            def.ThresholdSig   // Done state (Serialized signature)
+	Accusationlist       []def.SigFragment  // Accusation fragments against this Logger
 	APoM                 def.APoM           // APoM record against this Logger, if any
 	CPoM                 def.CPoM           // CPoM record against this Logger, if any
+	BPoM                 def.BPoM           // Bad encoding proof against this Logger, if any
 	TrafficCount         int                // Count of traffic
 	UpdateCount          int                // Count of updates received
 	StartTime            time.Time          // Time when the FSMLoggerEEA was started
 	ConvergeTime         time.Duration      // Time it takes to generate Threshold Signature
+	PoMTime              time.Duration      // Time it takes to hold the first PoM against this Logger
 	Bmode                string             // Only used in the base version (Non-EEA)
 	Notifications        []def.Notification // Only used in the base version (Non-EEA)
 
@@ -73,6 +85,12 @@ func (l *FSMLoggerEEA) SetField(field string, value interface{}) error {
 		} else {
 			return errors.New("invalid type for CPoM")
 		}
+	case "BPoM":
+		if v, ok := value.(def.BPoM); ok {
+			l.BPoM = v
+		} else {
+			return errors.New("invalid type for BPoM")
+		}
 	case "APoM":
 		if v, ok := value.(def.APoM); ok {
 			l.APoM = v
@@ -110,6 +128,12 @@ func (l *FSMLoggerEEA) SetField(field string, value interface{}) error {
 		} else {
 			return errors.New("invalid type for DataCheck")
 		}
+	case "PoMtime":
+		if v, ok := value.(time.Duration); ok {
+			l.PoMTime = v
+		} else {
+			return errors.New("invalid type for PoMtime")
+		}
 	case "Data":
 		if v, ok := value.([][]byte); ok {
 			l.Data = v
@@ -119,6 +143,9 @@ func (l *FSMLoggerEEA) SetField(field string, value interface{}) error {
 	default:
 		return errors.New("unknown field")
 	}
+	if rec, ok := fieldRecord(field, value); ok {
+		l.persist(rec)
+	}
 	return nil
 }
 
@@ -136,6 +163,8 @@ func (l *FSMLoggerEEA) GetField(field string) (interface{}, error) {
 		return l.Signature, nil
 	case "CPoM":
 		return l.CPoM, nil
+	case "BPoM":
+		return l.BPoM, nil
 	case "APoM":
 		return l.APoM, nil
 	case "DataCheck":
@@ -148,6 +177,8 @@ func (l *FSMLoggerEEA) GetField(field string) (interface{}, error) {
 		return l.UpdateCount, nil
 	case "Data":
 		return l.Data, nil
+	case "PoMtime":
+		return l.PoMTime, nil
 	default:
 		return nil, errors.New("unknown field")
 	}
@@ -204,6 +235,9 @@ func (l *FSMLoggerEEA) StoreUpdate(monitorID def.CTngID, update def.Update_Logge
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	l.Updates[monitorID] = update
+	if raw, err := json.Marshal(update); err == nil {
+		l.persist(Record{Kind: "update", Value: raw})
+	}
 }
 
 // Method to get an update from the FSMLoggerEEA
@@ -261,6 +295,7 @@ func (l *FSMLoggerEEA) AddSignatureFragment(signatureFragment def.SigFragment) {
 		}
 	}
 	l.Signaturelist = append(l.Signaturelist, signatureFragment)
+	l.persist(sigFragmentRecord("sigfrag", signatureFragment))
 }
 
 // Method to check if a signature fragment is already present in the Signaturelist
@@ -302,6 +337,9 @@ func (l *FSMLoggerEEA) AddAPoM(apom def.APoM) error {
 	}
 
 	l.APoM = apom
+	if rec, ok := fieldRecord("APoM", apom); ok {
+		l.persist(rec)
+	}
 	return nil
 }
 
@@ -316,9 +354,51 @@ func (l *FSMLoggerEEA) AddCPoM(cpom def.CPoM) error {
 	}
 
 	l.CPoM = cpom
+	if rec, ok := fieldRecord("CPoM", cpom); ok {
+		l.persist(rec)
+	}
 	return nil
 }
 
+// Method to securely add a BPoM to the FSMLoggerEEA, only the first one is kept
+func (l *FSMLoggerEEA) AddBPoM(bpom def.BPoM) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !reflect.DeepEqual(l.BPoM, def.BPoM{}) {
+		return errors.New("BPoM already present")
+	}
+
+	l.BPoM = bpom
+	if rec, ok := fieldRecord("BPoM", bpom); ok {
+		l.persist(rec)
+	}
+	return nil
+}
+
+// Method to add an accusation fragment to the Accusationlist, returns false for duplicates
+func (l *FSMLoggerEEA) AddAccusationFragment(accusationFragment def.SigFragment) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, existingFragment := range l.Accusationlist {
+		if reflect.DeepEqual(existingFragment, accusationFragment) {
+			return false
+		}
+	}
+	l.Accusationlist = append(l.Accusationlist, accusationFragment)
+	l.persist(sigFragmentRecord("accusation", accusationFragment))
+	return true
+}
+
+// Method to retrieve a copy of the Accusationlist
+func (l *FSMLoggerEEA) GetAccusationList() []def.SigFragment {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	accusationsCopy := make([]def.SigFragment, len(l.Accusationlist))
+	copy(accusationsCopy, l.Accusationlist)
+	return accusationsCopy
+}
+
 func (l *FSMLoggerEEA) SetBmodeForFragment(index int, bmode string) error {
 	l.lock.Lock()
 	defer l.lock.Unlock()
@@ -437,3 +517,62 @@ func (l *FSMLoggerEEA) GetFirstNotificationForFragment(index int) (*def.Notifica
 	notificationCopy := notifications[0]
 	return &notificationCopy, nil
 }
+
+// Method to claim the verification timer of the period, false if it already started or ran before a restart
+func (l *FSMLoggerEEA) claimTimer() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.timerStarted || l.TimeCheck {
+		return false
+	}
+	l.timerStarted = true
+	return true
+}
+
+// Method to release the shares and notifications of a finished period.
+// The STH, signatures and PoMs are kept as the record of the period.
+func (l *FSMLoggerEEA) Prune() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.Updates = make(map[def.CTngID]def.Update_Logger_EEA)
+	l.DataFragments = make([][]byte, len(l.DataFragments))
+	l.EEA_Notifications = make([][]def.Notification, len(l.EEA_Notifications))
+	l.Notifications = make([]def.Notification, 0)
+	l.Data = make([][]byte, 0)
+	l.certTree = nil
+	l.pruned = true
+}
+
+// Method to record the certificates of a period once they matched the signed head
+func (l *FSMLoggerEEA) SetCertificates(certs [][]byte, head_rs []byte, head_cert []byte) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.CertHashes = def.CertificateHashes(certs)
+	l.Head_rs = head_rs
+	l.Head_cert = head_cert
+	l.certTree = nil
+	if raw, err := json.Marshal(certificatesRecord{l.CertHashes, head_rs, head_cert}); err == nil {
+		l.persist(Record{Kind: "certificates", Value: raw})
+	}
+}
+
+// Method to build the PoI of a certificate, given its leaf hash, up to the STH of the period
+func (l *FSMLoggerEEA) InclusionProof(hash []byte) (def.InclusionProof, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.CertHashes) == 0 {
+		return def.InclusionProof{}, errors.New("no verified certificates for this period")
+	}
+	index := def.CertificateIndex(l.CertHashes, hash)
+	if index < 0 {
+		return def.InclusionProof{}, errors.New("certificate not logged in this period")
+	}
+	if l.certTree == nil {
+		tree, err := def.CertificateHashTree(l.CertHashes)
+		if err != nil {
+			return def.InclusionProof{}, err
+		}
+		l.certTree = tree
+	}
+	return def.BuildInclusionProof(l.STH, l.Head_rs, l.Head_cert, l.certTree, index, hash)
+}
@@ -3,16 +3,13 @@ package monitor
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"reflect"
 	"time"
 
 	def "github.com/jik18001/CTngV3/def"
-	rs "github.com/klauspost/reedsolomon"
-	merkletree "github.com/txaty/go-merkletree"
 )
 
 func LSMWakeup(m *MonitorEEA, lsm *FSMLoggerEEA, c def.Context) {
@@ -24,7 +21,7 @@ func LSMWakeup(m *MonitorEEA, lsm *FSMLoggerEEA, c def.Context) {
 
 	case def.WAKE_TM:
 		fmt.Println("WAKE_TM event triggered.")
-		if !reflect.DeepEqual(lsm.APoM, def.APoM{}) || !reflect.DeepEqual(lsm.CPoM, def.CPoM{}) {
+		if !reflect.DeepEqual(lsm.APoM, def.APoM{}) || !reflect.DeepEqual(lsm.CPoM, def.CPoM{}) || !reflect.DeepEqual(lsm.BPoM, def.BPoM{}) {
 			fmt.Println("PoM present")
 			return
 		}
@@ -40,6 +37,7 @@ func LSMWakeup(m *MonitorEEA, lsm *FSMLoggerEEA, c def.Context) {
 		monitor_signed_data := MonitorSignedData{
 			Type:      "STH",
 			CTngID:    def.CTngID(sth_fork.LID),
+			Period:    sth_fork.PeriodNum,
 			Signature: sigstring,
 		}
 		msd_json, err := json.Marshal(monitor_signed_data)
@@ -50,13 +48,12 @@ func LSMWakeup(m *MonitorEEA, lsm *FSMLoggerEEA, c def.Context) {
 		fmt.Println("transparency_partial_signature broadcasted")
 	case def.WAKE_TR:
 		if content, ok := c.Content.(def.Notification); ok {
-			// Map Originator ID to logger index
-			loggerIndex, err := def.MapIDtoInt(def.CTngID(content.Originator))
+			// Locate the Logger state machine of the notified period
+			fsmlogger, err := m.GetFSMLogger(content.Originator, content.Period)
 			if err != nil {
-				log.Printf("Failed to map Originator ID to int: %v", err)
+				log.Printf("Failed to locate Logger state: %v", err)
 				return
 			}
-			fsmlogger := m.FSMLoggerEEAs[loggerIndex]
 
 			// Determine the data fragment index
 			dataFragmentIndex, err := def.MapIDtoInt(def.CTngID(content.Monitor))
@@ -92,19 +89,12 @@ func LSMWakeup(m *MonitorEEA, lsm *FSMLoggerEEA, c def.Context) {
 				}
 
 				for _, notification := range notifications {
-					url := "http://" + notification.Sender + "/monitor/transparency_request"
-
-					response, err := m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+					err := m.Transport.Send(notification.Sender, "/monitor/transparency_request", new_note_json)
 					if err != nil {
 						log.Printf("Failed to send transparency request to %s: %v", notification.Sender, err)
 						continue
 					}
 
-					// Close the response body immediately after processing it
-					if response.Body != nil {
-						response.Body.Close()
-					}
-
 					// Optionally log success
 					// log.Printf("Transparency request successfully sent to %s", notification.Sender)
 				}
@@ -147,17 +137,26 @@ func process_logger_update_EEA(m *MonitorEEA, sth def.STH, update def.Update_Log
 	}
 	//fmt.Println("Signature Verification Passed")
 	// proceed only if we pass the verification
+	// a valid STH of a new period moves the monitor into that period
+	if err := m.AdvancePeriod(sth.PeriodNum); err != nil {
+		fmt.Println(err)
+		return
+	}
 	// retrieve existing STH
-	index, _ := def.MapIDtoInt(def.CTngID(sth.LID))
-	var fsmlogger *FSMLoggerEEA
-	fsmlogger = m.FSMLoggerEEAs[index]
+	fsmlogger, err := m.GetFSMLogger(def.CTngID(sth.LID), sth.PeriodNum)
+	if err != nil {
+		fmt.Println("Failed to locate Logger state:", err)
+		return
+	}
 	sth2, _ := fsmlogger.GetField("STH")
 	// if we already have an existing STH
 	if !reflect.DeepEqual(sth2, def.STH{}) {
 		// we compare the sth against the existing record and broadcast a cPoM when needed
-		sthsigbytes, _ := json.Marshal(sth)
-		sthsigbytes2, _ := json.Marshal(sth2)
-		if !reflect.DeepEqual(sthsigbytes, sthsigbytes2) {
+		// signatures are randomized, so only the signed contents are compared
+		stored, _ := sth2.(def.STH)
+		stored.Signature = def.RSASig{}
+		sthsigbytes2, _ := json.Marshal(stored)
+		if !bytes.Equal(sthBytes, sthsigbytes2) {
 			cPoM := &def.CPoM{
 				Entity_Convicted: def.CTngID(sth.LID),
 				MetaData1:        sth,
@@ -166,30 +165,10 @@ func process_logger_update_EEA(m *MonitorEEA, sth def.STH, update def.Update_Log
 			err := fsmlogger.AddCPoM(*cPoM)
 			//This means the cPoM is the first to be added
 			if err == nil {
-				fsmlogger.SetField("State", def.POM)
+				convict(m, fsmlogger)
 				fmt.Println("Switched to PoM State")
-				STH_only_update := def.Update_Logger{
-					STH:  sth,
-					File: [][]byte{},
-				}
-				sth_json, err := json.Marshal(STH_only_update)
-				if err != nil {
-					log.Fatalf("Failed to marshal update: %v", err)
-				}
-				broadcastEEA(m, "/monitor/logger_update", sth_json)
+				broadcastCPoM(m, *cPoM)
 			}
-			/*
-				cPoM := &def.CPoM{
-					Entity_Convicted: def.CTngID(STH_fork.LID),
-					MetaData1:        sth,
-					MetaData2:        sth2,
-				}
-				cPoM_json, err := json.Marshal(cPoM)
-				if err != nil {
-					log.Fatalf("Failed to marshal update: %v", err)
-				}
-				broadcastEEA(m, "/monitor/PoM", cPoM_json)
-			*/
 			return
 		}
 	}
@@ -199,9 +178,17 @@ func process_logger_update_EEA(m *MonitorEEA, sth def.STH, update def.Update_Log
 	if reflect.DeepEqual(update, update2) {
 		return
 	}
-	//validate data fragment
-	ok, _ := def.VerifyPOI2(update.Head_rs, update.PoI.Proof, update.FileShare)
-	if !ok {
+	// Head_rs and Head_cert come with the update, only the STH signs them.
+	// Checking the fragment against an unsigned root would let a relaying monitor store a share of its own.
+	if !sth.CommitsTo(update.Head_rs, update.Head_cert) {
+		fmt.Println("Head_rs and Head_cert not signed in the STH")
+		return
+	}
+	head_rs, head_cert := update.Head_rs, update.Head_cert
+	//validate data fragment, proven at the position of the monitor it was sent to
+	monitorindex, err := def.MapIDtoInt(def.CTngID(update.MonitorID))
+	ok, _ := def.VerifyPOI2(head_rs, update.PoI.Proof, update.FileShare)
+	if err != nil || !ok || def.PoIIndex(update.PoI) != monitorindex || len(update.FileShare) != sth.ShardSize {
 		fmt.Println("Data Fragment Verification Failed")
 		return
 	}
@@ -209,60 +196,58 @@ func process_logger_update_EEA(m *MonitorEEA, sth def.STH, update def.Update_Log
 	// Store the Update
 	fmt.Println(update.MonitorID)
 	fsmlogger.StoreUpdate(update.MonitorID, update)
-	monitorindex, _ := def.MapIDtoInt(def.CTngID(update.MonitorID))
 	frag, _ := fsmlogger.GetDataFragment(monitorindex)
 	if reflect.DeepEqual(frag, update.FileShare) {
 		return
 	}
 	fsmlogger.AddDataFragment(monitorindex, update.FileShare)
 	counter := fsmlogger.GetDataFragmentCounter()
-	required := m.Settings.Num_Monitors / 2 // Number of data shards (floor division)
-	if counter == required {
-		dec, err := rs.New(required, m.Settings.Num_Monitors-required)
-		if err != nil {
-			log.Fatalf("Error initializing Reed-Solomon decoder: %v", err)
-		}
-		fileShares := fsmlogger.GetDataFragments()
-		err = dec.Reconstruct(fileShares)
-		if err != nil {
-			log.Fatalf("Error during Reed-Solomon decoding: %v", err)
-		}
-		fmt.Println(len(fileShares[0]))
-		fmt.Println(len(fileShares[1]))
-		fmt.Println(len(fileShares[2]))
-		fmt.Println(len(fileShares[3]))
-		//fmt.Println(fileShares[0])
-		//fmt.Println(fileShares[1])
-		//fmt.Println(fileShares[2])
-		//fmt.Println(fileShares[3])
-		var dataBlocks []merkletree.DataBlock
-		for i := range fileShares[:(counter)] {
-			for j := 0; j < len(fileShares[i]); j += m.Settings.Certificate_size {
-				end := j + m.Settings.Certificate_size
-				if end > len(fileShares[i]) {
-					end = len(fileShares[i])
-				}
-				dataBlocks = append(dataBlocks, &def.LeafBlock{Content: fileShares[i][j:end]})
-			}
+	// The STH carries the erasure coding parameters, a Logger that signs unusable ones never passes the data check
+	params, paramsErr := def.HeadErasure(sth.Erasure, m.Settings.Num_Monitors, m.Settings.Mal)
+	if paramsErr != nil {
+		fmt.Println("Unusable erasure coding parameters:", paramsErr)
+	}
+	// Decode from any k verified shares, a failed attempt is retried with every later share
+	value, _ := fsmlogger.GetField("DataCheck")
+	decoded, _ := value.(bool)
+	if paramsErr == nil && counter >= params.K && !decoded {
+		// The data shares hold the certificate file, whichever shares were received
+		shares, err := decodeShares(params, fsmlogger.GetDataFragments(), head_rs)
+		isRootHashValid := false
+		var certs [][]byte
+		if err == errBadEncoding {
+			bpom := def.BPoM{Entity_Convicted: def.CTngID(sth.LID), STH: &sth, Head_rs: head_rs, Head_cert: head_cert}
+			proveBadEncoding(m, fsmlogger, bpom, params, func(index int) ([]byte, def.PoI, bool) {
+				u, err := fsmlogger.GetUpdate(monitorAt(index))
+				return u.FileShare, u.PoI, err == nil
+			})
+		} else if err != nil {
+			fmt.Println("Failed to reconstruct the certificate file, shares withheld, badly encoded or not enough yet:", err)
+		} else if file, err := sth.FileOf(shares); err != nil {
+			fmt.Println("Certificate file does not match the STH:", err)
+		} else if certs, err = def.DecodeCertificateFile(file); err != nil {
+			fmt.Println("Failed to decode certificate file:", err)
+		} else {
+			// Generate Merkle Tree over the certificates
+			tree, err := def.GenerateMerkleTree(def.CertificateBlocks(certs))
+			def.HandleError(err, "MT Generation")
+			rootHash := def.GenerateRootHash(tree)
+			isRootHashValid = reflect.DeepEqual(rootHash, head_cert) && len(certs) == sth.Size
 		}
-		// Generate Merkle Tree
-		tree, err := def.GenerateMerkleTree(dataBlocks)
-		def.HandleError(err, "MT Generation")
-		rootHash := def.GenerateRootHash(tree)
-		isRootHashValid := reflect.DeepEqual(rootHash, update.Head_cert)
 		//fmt.Println(rootHash)
 		//fmt.Println(update.Head_cert)
 		//fmt.Println("RootHash comparison result:", isRootHashValid)
 		if isRootHashValid {
+			fsmlogger.SetCertificates(certs, head_rs, head_cert)
 			fsmlogger.SetField("DataCheck", true)
-		}
-		value, _ := fsmlogger.GetField("TimeCheck")
-		noconf, _ := value.(bool)
-		if noconf {
-			NewContext := def.Context{
-				Label: def.WAKE_TM,
+			value, _ := fsmlogger.GetField("TimeCheck")
+			noconf, _ := value.(bool)
+			if noconf {
+				NewContext := def.Context{
+					Label: def.WAKE_TM,
+				}
+				LSMWakeup(m, fsmlogger, NewContext)
 			}
-			LSMWakeup(m, fsmlogger, NewContext)
 		}
 		//value, _ := fsmlogger.GetField("DataCheck")
 		//dataCheckValue, _ := value.(bool)
@@ -277,6 +262,7 @@ func process_logger_update_EEA(m *MonitorEEA, sth def.STH, update def.Update_Log
 		Type:       def.TUEEA,
 		Originator: def.CTngID(update.STH.LID),
 		Monitor:    update.MonitorID,
+		Period:     update.STH.PeriodNum,
 		Sender:     m.Self_ip_port,
 	}
 	//fmt.Println(new_note)
@@ -298,40 +284,39 @@ func process_logger_update_EEA(m *MonitorEEA, sth def.STH, update def.Update_Log
 			log.Fatalf("Failed to marshal update: %v", err)
 		}
 		broadcastEEA(m, "/monitor/STH", sth_json)
-		NewContext := def.Context{
-			Label: def.WAKE_TM,
-		}
-		go func() {
-			time.AfterFunc(time.Duration(m.Settings.Verification_Wait_time)*time.Second, func() {
-				fsmlogger.SetField("TimeCheck", true)
-				value, _ := fsmlogger.GetField("DataCheck")
-				dataCheckValue, _ := value.(bool)
-				//fmt.Println(dataCheckValue)
-				if dataCheckValue {
-					LSMWakeup(m, fsmlogger, NewContext)
-				} else {
-					fmt.Println("Place holder for accusation.")
-				}
-			})
-			return
-		}()
+		startSTHTimer(m, fsmlogger)
 	}
 
 }
 
-func logger_sth_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
-	var sth def.STH
-
-	// Create a counter to track the number of bytes read
-	var byteCounter int64
+// startSTHTimer waits for the data of the period, then signs the STH or accuses the Logger.
+// It runs once per period, from the start of the period, or from the first STH in the first period.
+func startSTHTimer(m *MonitorEEA, fsmlogger *FSMLoggerEEA) {
+	if !fsmlogger.claimTimer() {
+		return
+	}
+	NewContext := def.Context{
+		Label: def.WAKE_TM,
+	}
+	m.Clock.AfterFunc(time.Duration(m.Settings.Verification_Wait_time)*time.Second, func() {
+		fsmlogger.SetField("TimeCheck", true)
+		value, _ := fsmlogger.GetField("DataCheck")
+		dataCheckValue, _ := value.(bool)
+		//fmt.Println(dataCheckValue)
+		if dataCheckValue {
+			LSMWakeup(m, fsmlogger, NewContext)
+		} else {
+			accuse(m, fsmlogger.CTngID, fsmlogger.Period)
+		}
+	})
+}
 
-	// Create a TeeReader to count bytes while reading from r.Body
-	counterReader := io.TeeReader(r.Body, &countWriter{count: &byteCounter})
+func logger_sth_handler(m *MonitorEEA, data []byte) error {
+	var sth def.STH
 
 	// Decode the STH from the request body
-	if err := json.NewDecoder(counterReader).Decode(&sth); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &sth); err != nil {
+		return errors.New("Failed to decode update")
 	}
 
 	// Retrieve the FSMLogger corresponding to the STH LID
@@ -343,11 +328,12 @@ func logger_sth_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
 	// trafficcount := trafficcountInterface.(int) // Assert as int
 
 	// Update the traffic count by adding the size of the request body
-	// newcount := trafficcount + int(byteCounter)
+	// newcount := trafficcount + len(data)
 	// fsmlogger.SetField("TrafficCount", newcount)
 
 	// Process the logger update
 	process_logger_update_EEA(m, sth, def.Update_Logger_EEA{})
+	return nil
 }
 
 // this function handles the update (Erasure Encoding Version) from the logger
@@ -365,37 +351,33 @@ func logger_sth_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
 
 }*/
 
-func logger_update_EEA_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
-	// Create a counter to track the number of bytes read
-	var byteCounter int64
-
-	// Create a TeeReader to count bytes while reading from r.Body
-	counterReader := io.TeeReader(r.Body, &countWriter{count: &byteCounter})
+func logger_update_EEA_handler(m *MonitorEEA, data []byte) error {
 
 	// Parse the update
 	var update def.Update_Logger_EEA
-	if err := json.NewDecoder(counterReader).Decode(&update); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &update); err != nil {
+		return errors.New("Failed to decode update")
 	}
 
-	// Retrieve the FSMLogger corresponding to the STH LID in the update
-	loggerIndex, err := def.MapIDtoInt(def.CTngID(update.STH.LID))
-	if err != nil {
-		http.Error(w, "Invalid Logger ID in STH", http.StatusBadRequest)
-		return
-	}
-	fsmlogger := m.FSMLoggerEEAs[loggerIndex]
-
 	// Print the Logger ID (LID) and Monitor ID (MID)
 	fmt.Printf("Processing update from Logger ID (LID): %s, Monitor ID (MID): %s\n", update.STH.LID, update.MonitorID)
 
+	// Process the logger update
+	// The state machine of a new period only exists once the STH of that period is verified
+	process_logger_update_EEA(m, update.STH, update)
+
+	// Retrieve the FSMLogger corresponding to the STH LID and period in the update
+	fsmlogger, err := m.GetFSMLogger(def.CTngID(update.STH.LID), update.STH.PeriodNum)
+	if err != nil {
+		return errors.New("Invalid Logger ID or period in STH")
+	}
+
 	// Retrieve the current traffic count
 	//trafficcountInterface, _ := fsmlogger.GetField("TrafficCount")
 	//trafficcount := trafficcountInterface.(int) // Assert as int
 
 	// Update the traffic count by adding the size of the request body
-	//newcount := trafficcount + int(byteCounter)
+	//newcount := trafficcount + len(data)
 	//fsmlogger.SetField("TrafficCount", newcount)
 
 	// Retrieve the current update count
@@ -412,88 +394,91 @@ func logger_update_EEA_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Req
 
 	//if update.File != nil && len(update.File) > 0 {
 	fsmlogger.lock.Lock()
-	fsmlogger.TrafficCount = fsmlogger.TrafficCount + int(byteCounter)
+	fsmlogger.TrafficCount = fsmlogger.TrafficCount + len(data)
 	fsmlogger.UpdateCount = fsmlogger.UpdateCount + 1
 	fsmlogger.lock.Unlock()
 	//}
-
-	// Process the logger update
-	process_logger_update_EEA(m, update.STH, update)
+	return nil
 }
 
-func transparency_request_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func transparency_request_handler(m *MonitorEEA, data []byte) error {
 	var new_note def.Notification
-	if err := json.NewDecoder(r.Body).Decode(&new_note); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &new_note); err != nil {
+		return errors.New("Failed to decode update")
+	}
+	fsmlogger, err := m.GetFSMLogger(new_note.Originator, new_note.Period)
+	if err != nil {
+		return nil
 	}
-	loggerindex, _ := def.MapIDtoInt(new_note.Originator)
-	//fmt.Println(new_note.Originator, loggerindex)
-	fsmlogger := m.FSMLoggerEEAs[loggerindex]
 	//fmt.Println(fsmlogger.State)
 
 	update, err := fsmlogger.GetUpdate(def.CTngID(new_note.Monitor))
 	if err != nil {
-		return
+		return nil
 	}
 	//fmt.Println(update.MonitorID)
 	update_json, err := json.Marshal(update)
 	if err != nil {
 		log.Fatalf("Failed to marshal update: %v", err)
 	}
-	url := "http://" + new_note.Sender + "/monitor/logger_update_EEA"
-	_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(update_json))
+	err = m.Transport.Send(new_note.Sender, "/monitor/logger_update_EEA", update_json)
 	if err != nil {
 		//fmt.Println("Failed to send update: ", err)
 	}
+	return nil
 }
 
-func transparency_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func transparency_notification_handler(m *MonitorEEA, data []byte) error {
 	var new_note def.Notification
-	if err := json.NewDecoder(r.Body).Decode(&new_note); err != nil {
-		http.Error(w, "Failed to decode notification", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &new_note); err != nil {
+		return errors.New("Failed to decode notification")
 	}
 
-	// Map the Originator ID (Logger ID) to logger index
-	loggerIndex, err := def.MapIDtoInt(new_note.Originator)
-	if err != nil {
-		http.Error(w, "Failed to map Originator ID to logger index", http.StatusBadRequest)
-		return
-	}
-
-	// Locate the corresponding FSMLoggerEEA using the logger index
-	fsmlogger := m.FSMLoggerEEAs[loggerIndex]
-
 	// Create a copy of the notification and set the sender
 	new_note_fork := new_note
 	new_note_fork.Sender = m.Self_ip_port
 
+	// Locate the corresponding FSMLoggerEEA of the notified period
+	fsmlogger, err := m.GetFSMLogger(new_note.Originator, new_note.Period)
+	if err != nil {
+		// The period has not started here yet, request the update directly since it carries the signed STH
+		if new_note.Period <= m.GetPeriod() {
+			return errors.New("Failed to locate Logger state")
+		}
+		new_note_json, err := json.Marshal(new_note_fork)
+		if err != nil {
+			log.Fatalf("Failed to marshal notification: %v", err)
+		}
+		err = m.Transport.Send(new_note.Sender, "/monitor/transparency_request", new_note_json)
+		if err != nil {
+			log.Printf("Failed to send transparency request: %v", err)
+		}
+		return nil
+	}
+
 	// return if the file has already been reconstructed
 	value, _ := fsmlogger.GetField("DataCheck")
 	dataCheckValue, _ := value.(bool)
 	if dataCheckValue {
-		return
+		return nil
 	}
 	// Map the Monitor ID to data fragment index
 	dataFragmentIndex, err := def.MapIDtoInt(new_note.Monitor)
 	if err != nil {
-		http.Error(w, "Failed to map Monitor ID to data fragment index", http.StatusBadRequest)
-		return
+		return errors.New("Failed to map Monitor ID to data fragment index")
 	}
 
 	existing_update, _ := fsmlogger.GetUpdate(new_note.Monitor)
 	// Return if we already have the update
 	if !reflect.DeepEqual(existing_update, def.Update_Logger_EEA{}) {
-		return
+		return nil
 	}
 
 	// Access the per-fragment Bmode with fallback to global Bmode
 	fsmlogger.lock.RLock()
 	if dataFragmentIndex >= len(fsmlogger.Bmodes) {
 		fsmlogger.lock.RUnlock()
-		http.Error(w, "Data fragment index out of range", http.StatusBadRequest)
-		return
+		return errors.New("Data fragment index out of range")
 	}
 	fragmentBmode := fsmlogger.Bmodes[dataFragmentIndex]
 	if fragmentBmode == "" {
@@ -507,16 +492,14 @@ func transparency_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *
 		// Map Monitor ID to IP address
 		monitorIP, ok := m.Broadcast_targets[def.CTngID(new_note.Monitor)]
 		if !ok {
-			http.Error(w, "Monitor IP not found for Monitor ID", http.StatusBadRequest)
-			return
+			return errors.New("Monitor IP not found for Monitor ID")
 		}
 
-		url := "http://" + monitorIP + "/monitor/transparency_request"
 		new_note_json, err := json.Marshal(new_note_fork)
 		if err != nil {
 			log.Fatalf("Failed to marshal notification: %v", err)
 		}
-		_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+		err = m.Transport.Send(monitorIP, "/monitor/transparency_request", new_note_json)
 		if err != nil {
 			log.Printf("Failed to send transparency request: %v", err)
 		}
@@ -534,16 +517,14 @@ func transparency_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *
 			// Map Monitor ID to IP address
 			monitorIP, ok := m.Broadcast_targets[def.CTngID(new_note.Monitor)]
 			if !ok {
-				http.Error(w, "Monitor IP not found for Monitor ID", http.StatusBadRequest)
-				return
+				return errors.New("Monitor IP not found for Monitor ID")
 			}
 
-			url := "http://" + monitorIP + "/monitor/transparency_request"
 			new_note_json, err := json.Marshal(new_note_fork)
 			if err != nil {
 				log.Fatalf("Failed to marshal notification: %v", err)
 			}
-			_, err = m.Client.Post(url, "application/json", bytes.NewBuffer(new_note_json))
+			err = m.Transport.Send(monitorIP, "/monitor/transparency_request", new_note_json)
 			if err != nil {
 				log.Printf("Failed to send transparency request: %v", err)
 			}
@@ -551,11 +532,9 @@ func transparency_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *
 				Label:   def.WAKE_TR,
 				Content: new_note,
 			}
-			go func() {
-				time.AfterFunc(time.Duration(m.Settings.Update_Wait_time)*time.Second, func() {
-					LSMWakeup(m, fsmlogger, NewContext)
-				})
-			}()
+			m.Clock.AfterFunc(time.Duration(m.Settings.Update_Wait_time)*time.Second, func() {
+				LSMWakeup(m, fsmlogger, NewContext)
+			})
 		}
 
 		// Add the notification to the specific data fragment
@@ -564,28 +543,31 @@ func transparency_notification_handler(m *MonitorEEA, w http.ResponseWriter, r *
 			log.Fatalf("Failed to add notification: %v", err)
 		}
 	}
+	return nil
 }
 
-func transparency_partial_signature_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func transparency_partial_signature_handler(m *MonitorEEA, data []byte) error {
 	//fmt.Println("MSD received")
 	var msd MonitorSignedData
-	if err := json.NewDecoder(r.Body).Decode(&msd); err != nil {
-		http.Error(w, "Failed to decode update", http.StatusBadRequest)
-		return
+	if err := json.Unmarshal(data, &msd); err != nil {
+		return errors.New("Failed to decode update")
+	}
+	fsmlogger, err := m.GetFSMLogger(msd.CTngID, msd.Period)
+	if err != nil {
+		fmt.Println("Failed to locate Logger state:", err)
+		return nil
 	}
-	index, _ := def.MapIDtoInt(def.CTngID(msd.CTngID))
-	fsmlogger := m.FSMLoggerEEAs[index]
 
 	sth, err := fsmlogger.GetField("STH")
 	if err != nil {
 		fmt.Println("Error retrieving STH field:", err)
-		return
+		return nil
 	}
 	sth_fork, ok := sth.(def.STH)
 	if !ok {
 		// Handle the case where the assertion fails
 		fmt.Println("sth_fork is not of type STH")
-		return
+		return nil
 	}
 	sth_fork.Signature = def.RSASig{}
 	sthBytes, err := json.Marshal(sth_fork)
@@ -597,28 +579,32 @@ func transparency_partial_signature_handler(m *MonitorEEA, w http.ResponseWriter
 	err = m.FragmentVerify(string(sthBytes), sigfrag)
 	if err != nil {
 		fmt.Println("partial Signature verification failed: ", err)
-		return
+		return nil
 	}
 
+	// no threshold signature on the head of a convicted entity
+	if state, _ := fsmlogger.GetField("State"); state == def.POM {
+		return nil
+	}
 	if fsmlogger.IsSignatureFragmentPresent(sigfrag) {
 		//fmt.Println("partial Signature duplicates.")
-		return
+		return nil
 	}
-	if fsmlogger.IsSignaturePresent() || fsmlogger.GetSignatureListLength() >= m.Settings.Mal+1 {
+	if fsmlogger.IsSignaturePresent() || fsmlogger.GetSignatureListLength() >= m.Crypto.Threshold {
 		//fmt.Println("Threshold Signature already exists.")
-		return
+		return nil
 	}
 	fsmlogger.AddSignatureFragment(sigfrag)
 	fmt.Println("number of partial Signatures: ", fsmlogger.GetSignatureListLength())
 	//fmt.Println("number of partial Signatures: ", fsmlogger.Signaturelist)
-	if fsmlogger.GetSignatureListLength() == m.Settings.Mal+1 {
+	if fsmlogger.GetSignatureListLength() == m.Crypto.Threshold {
 		sig := m.Aggregate(fsmlogger.Signaturelist)
 		fsmlogger.SetField("Signature", sig)
 		//fsmlogger.Signature = sig
 		// Retrieve the start time
 		startTime := fsmlogger.GetStartTime()
 		// Calculate the elapsed time
-		elapsedTime := time.Since(startTime)
+		elapsedTime := m.Clock.Now().Sub(startTime)
 		fsmlogger.SetField("Convergetime", elapsedTime)
 		//fsmlogger.ConvergeTime = elapsedTime
 		// Print or log the elapsed time
@@ -629,4 +615,5 @@ func transparency_partial_signature_handler(m *MonitorEEA, w http.ResponseWriter
 		log.Fatalf("Failed to marshal update: %v", err)
 	}
 	broadcastEEA(m, "/monitor/transparency_partial_signature", msd_json)
+	return nil
 }
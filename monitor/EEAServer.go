@@ -1,8 +1,8 @@
 package monitor
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gorilla/mux"
 	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
 )
 
 func bindContext(context *MonitorEEA, fn func(context *MonitorEEA, w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
@@ -19,28 +20,51 @@ func bindContext(context *MonitorEEA, fn func(context *MonitorEEA, w http.Respon
 	}
 }
 
-func handleRequests_EEA(m *MonitorEEA) {
-	// MUX which routes HTTP directories to functions.
-	gorillaRouter := mux.NewRouter().StrictSlash(true)
-	//endpoints
+func bindMessage(context *MonitorEEA, fn func(context *MonitorEEA, data []byte) error) transport.Handler {
+	return func(from def.CTngID, data []byte) error {
+		if err := authenticate(context, from, data); err != nil {
+			return err
+		}
+		return fn(context, data)
+	}
+}
+
+// RegisterEEAHandlers routes the protocol messages of an EEA monitor on its transport
+func RegisterEEAHandlers(m *MonitorEEA) {
+	t := m.Transport
 	//---------------------------------Shared------------------------------------------------------------------------
-	gorillaRouter.HandleFunc("/monitor/PoM", bindContext(m, PoM_handler)).Methods("POST")
+	t.Register("/monitor/PoM", bindMessage(m, PoM_handler))
+	t.Register("/monitor/BPoM", bindMessage(m, BPoM_handler))
+	t.Register("/monitor/accusation", bindMessage(m, accusation_handler))
 	//---------------------------------Transparency Updates----------------------------------------------------------
-	gorillaRouter.HandleFunc("/monitor/logger_update_EEA", bindContext(m, logger_update_EEA_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/STH", bindContext(m, logger_sth_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/transparency_notification", bindContext(m, transparency_notification_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/transparency_request", bindContext(m, transparency_request_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/transparency_partial_signature", bindContext(m, transparency_partial_signature_handler)).Methods("POST")
+	t.Register("/monitor/logger_update_EEA", bindMessage(m, logger_update_EEA_handler))
+	t.Register("/monitor/STH", bindMessage(m, logger_sth_handler))
+	t.Register("/monitor/transparency_notification", bindMessage(m, transparency_notification_handler))
+	t.Register("/monitor/transparency_request", bindMessage(m, transparency_request_handler))
+	t.Register("/monitor/transparency_partial_signature", bindMessage(m, transparency_partial_signature_handler))
 	//---------------------------------Revocation Updates----------------------------------------------------------
-	gorillaRouter.HandleFunc("/monitor/ca_update_EEA", bindContext(m, ca_update_EEA_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/SRH", bindContext(m, ca_srh_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/revocation_notification", bindContext(m, revocation_notification_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/revocation_request", bindContext(m, revocation_request_handler)).Methods("POST")
-	gorillaRouter.HandleFunc("/monitor/revocation_partial_signature", bindContext(m, revocation_partial_signature_handler)).Methods("POST")
+	t.Register("/monitor/ca_update_EEA", bindMessage(m, ca_update_EEA_handler))
+	t.Register("/monitor/SRH", bindMessage(m, ca_srh_handler))
+	t.Register("/monitor/revocation_notification", bindMessage(m, revocation_notification_handler))
+	t.Register("/monitor/revocation_request", bindMessage(m, revocation_request_handler))
+	t.Register("/monitor/revocation_partial_signature", bindMessage(m, revocation_partial_signature_handler))
+	t.Register("/monitor/crv_request", bindMessage(m, crv_request_handler))
+	t.Register("/monitor/crv", bindMessage(m, crv_handler))
+}
+
+// NewEEARouter makes an EEA monitor send its messages over HTTP with the client, the router serves every endpoint
+func NewEEARouter(m *MonitorEEA, client *http.Client) *mux.Router {
+	t := newHTTP(m, client)
+	m.Transport = injectFaults(m, newSealingTransport(m, t))
+	RegisterEEAHandlers(m)
+	registerQueries(m, t.Router)
+	return t.Router
+}
+
+func handleRequests_EEA(m *MonitorEEA, gorillaRouter *mux.Router) {
 	// Start the HTTP server.
-	http.Handle("/", gorillaRouter)
 	fmt.Println(def.BLUE+"Listening on port:", m.Settings.Portmap[m.CTngID], def.RESET)
-	err := http.ListenAndServe(":"+m.Settings.Portmap[m.CTngID], nil)
+	err := transport.ListenAndServe(m.Settings.Portmap[m.CTngID], gorillaRouter, tlsConfig(m))
 	// We wont get here unless there's an error.
 	log.Fatal("ListenAndServe: ", err)
 	os.Exit(1)
@@ -48,33 +72,114 @@ func handleRequests_EEA(m *MonitorEEA) {
 
 func broadcastEEA(m *MonitorEEA, endpoint string, data []byte) {
 	monitors := def.GetMonitorURL(*m.Settings)
+	addresses := make([]string, 0, len(monitors))
 	for _, monitor := range monitors {
-		url := "http://" + monitor + endpoint
-		_, err := m.Client.Post(url, "application/json", bytes.NewBuffer(data))
+		addresses = append(addresses, monitor)
+	}
+	m.Transport.Broadcast(addresses, endpoint, data)
+}
+
+func broadcastCPoM(m *MonitorEEA, cpom def.CPoM) {
+	cPoM_json, err := json.Marshal(cpom)
+	if err != nil {
+		log.Fatalf("Failed to marshal CPoM: %v", err)
+	}
+	broadcastEEA(m, "/monitor/PoM", cPoM_json)
+}
+
+// PoM_handler accepts a CPoM gossiped by another monitor, moves the matching state machine to PoM and gossips it once.
+func PoM_handler(m *MonitorEEA, data []byte) error {
+	cpom, err := def.DecodeCPoM(data)
+	if err != nil {
+		return errors.New("Failed to decode PoM")
+	}
+	period, err := m.Crypto.VerifyCPoM(cpom)
+	if err != nil {
+		fmt.Println("PoM verification failed:", err)
+		return errors.New("Invalid PoM")
+	}
+	// both heads are validly signed, so the period can be trusted
+	if err := m.AdvancePeriod(period); err != nil {
+		return err
+	}
+	switch cpom.Entity_Convicted[0] {
+	case 'L':
+		fsmlogger, err := m.GetFSMLogger(cpom.Entity_Convicted, period)
 		if err != nil {
-			//fmt.Println("Failed to send update: ", err)
+			fmt.Println("Failed to locate Logger state:", err)
+			return nil
+		}
+		// only the first CPoM is kept and gossiped
+		if fsmlogger.AddCPoM(cpom) != nil {
+			return nil
 		}
+		convict(m, fsmlogger)
+	case 'C':
+		fsmca, err := m.GetFSMCA(cpom.Entity_Convicted, period)
+		if err != nil {
+			fmt.Println("Failed to locate CA state:", err)
+			return nil
+		}
+		if fsmca.AddCPoM(cpom) != nil {
+			return nil
+		}
+		convict(m, fsmca)
 	}
+	fmt.Println("Switched to PoM State, CPoM received against", cpom.Entity_Convicted)
+	broadcastEEA(m, "/monitor/PoM", data)
+	return nil
 }
 
-func PoM_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+func broadcastBPoM(m *MonitorEEA, bpom def.BPoM) {
+	bPoM_json, err := json.Marshal(bpom)
+	if err != nil {
+		log.Fatalf("Failed to marshal BPoM: %v", err)
+	}
+	broadcastEEA(m, "/monitor/BPoM", bPoM_json)
+}
 
+// BPoM_handler accepts a bad encoding proof gossiped by another monitor, moves the matching state machine to PoM and gossips it once.
+func BPoM_handler(m *MonitorEEA, data []byte) error {
+	var bpom def.BPoM
+	if err := json.Unmarshal(data, &bpom); err != nil {
+		return errors.New("Failed to decode BPoM")
+	}
+	period, err := m.Crypto.VerifyBPoM(bpom)
+	if err != nil {
+		fmt.Println("BPoM verification failed:", err)
+		return errors.New("Invalid BPoM")
+	}
+	// the head is validly signed, so the period can be trusted
+	if err := m.AdvancePeriod(period); err != nil {
+		return err
+	}
+	fsm, err := m.getAccusable(bpom.Entity_Convicted, period)
+	if err != nil {
+		fmt.Println("Failed to locate state:", err)
+		return nil
+	}
+	// only the first BPoM is kept and gossiped
+	if fsm.AddBPoM(bpom) != nil {
+		return nil
+	}
+	convict(m, fsm)
+	fmt.Println("Switched to PoM State, BPoM received against", bpom.Entity_Convicted)
+	broadcastEEA(m, "/monitor/BPoM", data)
+	return nil
 }
 
-/*
+// PeriodicTasks dumps the converge times of every period seen so far, once every MUD.
 func PeriodicTasks(m *MonitorEEA) {
 	// Immediately queue up the next task to run at next MUD.
 	// Doing this first means: no matter how long the rest of the function takes,
 	// the next call will always occur after the correct amount of time.
-	fmt.Println("Entering Periodic Task")
-	filename := m.CTngID.String() + ".json"
-	m.DumpConvergeTimesToFile(filename)
 	f := func() {
 		PeriodicTasks(m)
 	}
-	time.AfterFunc(time.Duration(m.Settings.MUD)*time.Second, f)
+	m.Clock.AfterFunc(time.Duration(m.Settings.MUD)*time.Second, f)
+	filename := m.CTngID.String() + ".json"
+	m.DumpConvergeTimesToFile(filename)
 }
-*/
 
 func StartMonitorEEAServer(m *MonitorEEA) {
 	tr := &http.Transport{
@@ -83,19 +188,23 @@ func StartMonitorEEAServer(m *MonitorEEA) {
 		WriteBufferSize:     1024 * 1024, // 1MB
 		ReadBufferSize:      1024 * 1024, // 1MB
 	}
-	m.Client = &http.Client{
+	gorillaRouter := NewEEARouter(m, &http.Client{
 		Transport: tr,
+	})
+	restoreFromStorage(m)
+	// HTTP Server Loop, it serves the key generation before the periods start
+	go handleRequests_EEA(m, gorillaRouter)
+	if err := runDKG(m); err != nil {
+		log.Fatalf("Key generation failed: %v", err)
 	}
-	// HTTP Server Loop
-	//go PeriodicTasks(m)
-	filename := m.CTngID.String() + ".json"
-	m.DumpConvergeTimesToFile(filename)
-	f := func() {
-		m.DumpConvergeTimesToFile(filename)
+	if m.Settings.Reshare {
+		if err := runResharing(m, m.Settings, m.Settings.Mal+1); err != nil {
+			log.Fatalf("Resharing failed: %v", err)
+		}
 	}
-	time.AfterFunc(time.Duration(m.Settings.MUD)*time.Second, f)
+	PeriodicTasks(m)
 	fmt.Println("Current Time:", time.Now().Format(time.RFC3339))
-	handleRequests_EEA(m)
+	select {}
 }
 
 // ConvergeTimeRecord holds the ID and converge time of each FSMLoggerEEA and FSMCAEEA
@@ -103,56 +212,82 @@ type ConvergeTimeRecord struct {
 	MonitorID    string  `json:"monitor_id"`
 	EntityID     string  `json:"entity_id"`   // Can represent LoggerID or CAID
 	EntityType   string  `json:"entity_type"` // "Logger" or "CA"
+	Period       int     `json:"period"`
 	ConvergeTime float64 `json:"converge_time"`
 	Traffic      string  `json:"traffic"`
 	UpdateCount  int     `json:"update_count"`
+	PoMTime      float64 `json:"pom_time,omitempty"` // Seconds until the first PoM against the entity, 0 without any
+}
+
+// ConvergeTimes returns the records of every period seen so far, in period order
+func (m *MonitorEEA) ConvergeTimes() []ConvergeTimeRecord {
+	var convergeTimes []ConvergeTimeRecord
+
+	for _, period := range m.GetPeriods() {
+		m.lock.RLock()
+		fsmLoggers := m.LoggerHistory[period]
+		fsmCAs := m.CAHistory[period]
+		m.lock.RUnlock()
+		convergeTimes = append(convergeTimes, periodConvergeTimes(m, period, fsmLoggers, fsmCAs)...)
+	}
+	return convergeTimes
 }
 
 func (m *MonitorEEA) DumpConvergeTimesToFile(filename string) error {
+	convergeTimes := m.ConvergeTimes()
+
+	// Marshal to JSON
+	jsonData, err := json.MarshalIndent(convergeTimes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal converge times: %v", err)
+	}
+
+	// Write to file
+	err = os.WriteFile(filename, jsonData, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write converge times to file: %v", err)
+	}
+
+	fmt.Printf("Converge times successfully dumped to %s\n", filename)
+	return nil
+}
+
+// periodConvergeTimes collects the records of the state machines of a single period
+func periodConvergeTimes(m *MonitorEEA, period int, fsmLoggers []*FSMLoggerEEA, fsmCAs []*FSMCAEEA) []ConvergeTimeRecord {
 	var convergeTimes []ConvergeTimeRecord
 
 	// Iterate over all FSMLoggers
-	for _, fsmLogger := range m.FSMLoggerEEAs {
+	for _, fsmLogger := range fsmLoggers {
 		fsmLogger.lock.RLock()
 		convergeTimes = append(convergeTimes, ConvergeTimeRecord{
 			EntityID:     fsmLogger.CTngID.String(),
 			MonitorID:    m.CTngID.String(),
 			EntityType:   "Logger",
+			Period:       period,
 			ConvergeTime: fsmLogger.ConvergeTime.Seconds(),
 			Traffic:      formatTraffic(fsmLogger.TrafficCount),
 			UpdateCount:  fsmLogger.UpdateCount,
+			PoMTime:      fsmLogger.PoMTime.Seconds(),
 		})
 		fsmLogger.lock.RUnlock()
 	}
 
 	// Iterate over all FSMCAs
-	for _, fsmCA := range m.FSMCAEEAs {
+	for _, fsmCA := range fsmCAs {
 		fsmCA.lock.RLock()
 		convergeTimes = append(convergeTimes, ConvergeTimeRecord{
 			EntityID:     fsmCA.CTngID.String(),
 			MonitorID:    m.CTngID.String(),
 			EntityType:   "CA",
+			Period:       period,
 			ConvergeTime: fsmCA.ConvergeTime.Seconds(),
 			Traffic:      formatTraffic(fsmCA.TrafficCount),
 			UpdateCount:  fsmCA.UpdateCount,
+			PoMTime:      fsmCA.PoMTime.Seconds(),
 		})
 		fsmCA.lock.RUnlock()
 	}
-
-	// Marshal to JSON
-	jsonData, err := json.MarshalIndent(convergeTimes, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal converge times: %v", err)
-	}
-
-	// Write to file
-	err = os.WriteFile(filename, jsonData, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write converge times to file: %v", err)
-	}
-
-	fmt.Printf("Converge times successfully dumped to %s\n", filename)
-	return nil
+	return convergeTimes
 }
 
 // formatTraffic converts traffic in bytes to a human-readable format (KB, MB, GB, etc.)
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+
+	def "github.com/jik18001/CTngV3/def"
+)
+
+var errBadEncoding = errors.New("the shares committed in Head_rs are not a codeword")
+
+// decodeShares rebuilds every share from the received ones, checks they form the share tree Head_rs
+// and returns the k data shares.
+// Any shares of a codeword that decode rebuild the same shares, so a different tree means the sender encoded badly.
+func decodeShares(params def.ErasureParams, shares [][]byte, head_rs []byte) ([][]byte, error) {
+	full, _, err := params.Reencode(shares)
+	if err != nil {
+		return nil, err
+	}
+	root, err := def.ShareRoot(full)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(root, head_rs) {
+		return nil, errBadEncoding
+	}
+	return full[:params.K], nil
+}
+
+// proveBadEncoding completes the BPoM with the received shares and their PoIs, the head and Head_rs being set by the caller.
+// The proof is only kept and gossiped if it verifies, shares of another Head_rs never do.
+func proveBadEncoding(m *MonitorEEA, fsm accusable, bpom def.BPoM, params def.ErasureParams, share func(index int) ([]byte, def.PoI, bool)) {
+	if existing, _ := fsm.GetField("BPoM"); !reflect.DeepEqual(existing, def.BPoM{}) {
+		return
+	}
+	for i := 0; i < params.K+params.M; i++ {
+		if data, poi, ok := share(i); ok && len(data) > 0 {
+			bpom.Shares = append(bpom.Shares, data)
+			bpom.PoIs = append(bpom.PoIs, poi)
+		}
+	}
+	if _, err := m.Crypto.VerifyBPoM(bpom); err != nil {
+		fmt.Println("Failed to prove the bad encoding of", bpom.Entity_Convicted, ":", err)
+		return
+	}
+	if fsm.AddBPoM(bpom) != nil {
+		return
+	}
+	convict(m, fsm)
+	fmt.Println(def.RED+"Switched to PoM State, bad encoding by", bpom.Entity_Convicted, def.RESET)
+	broadcastBPoM(m, bpom)
+}
+
+// monitorAt returns the monitor holding the share at index
+func monitorAt(index int) def.CTngID {
+	return def.CTngID(fmt.Sprintf("M%d", index+1))
+}
@@ -0,0 +1,128 @@
+package monitor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+// Endpoints of the gossip between monitors, their messages travel in signed envelopes
+var sealedEndpoints = map[string]bool{
+	"/monitor/accusation":                             true,
+	"/monitor/transparency_notification":              true,
+	"/monitor/transparency_request":                   true,
+	"/monitor/transparency_partial_signature":         true,
+	"/monitor/revocation_notification":                true,
+	"/monitor/revocation_request":                     true,
+	"/monitor/revocation_partial_signature":           true,
+	"/monitor/crv_request":                            true,
+	"/monitor/crv":                                    true,
+	"/monitor/default_transparency_notification":      true,
+	"/monitor/default_transparency_request":           true,
+	"/monitor/default_transparency_partial_signature": true,
+}
+
+// sealingTransport signs the gossip the monitor sends and opens the envelopes it receives.
+// A relayed message is sealed again by the relaying monitor, the signer of an envelope is always its sender.
+type sealingTransport struct {
+	transport.Transport
+	m    *MonitorEEA
+	lock sync.Mutex
+	seen map[int]map[string]bool // Nonces received, keyed by the period of their envelope
+}
+
+func newSealingTransport(m *MonitorEEA, t transport.Transport) *sealingTransport {
+	return &sealingTransport{Transport: t, m: m, seen: make(map[int]map[string]bool)}
+}
+
+func (t *sealingTransport) seal(endpoint string, data []byte) ([]byte, error) {
+	envelope, err := t.m.Crypto.Seal(t.m.CTngID, endpoint, t.m.GetPeriod(), data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope)
+}
+
+func (t *sealingTransport) Send(to string, endpoint string, data []byte) error {
+	if sealedEndpoints[endpoint] {
+		sealed, err := t.seal(endpoint, data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+	return t.Transport.Send(to, endpoint, data)
+}
+
+// Broadcast seals once for every receiver, each one tracks the nonce on its own
+func (t *sealingTransport) Broadcast(to []string, endpoint string, data []byte) {
+	if sealedEndpoints[endpoint] {
+		sealed, err := t.seal(endpoint, data)
+		if err != nil {
+			fmt.Println(def.RED+"Failed to seal message:", err, def.RESET)
+			return
+		}
+		data = sealed
+	}
+	t.Transport.Broadcast(to, endpoint, data)
+}
+
+func (t *sealingTransport) Register(endpoint string, handler transport.Handler) {
+	if !sealedEndpoints[endpoint] {
+		t.Transport.Register(endpoint, handler)
+		return
+	}
+	t.Transport.Register(endpoint, func(from def.CTngID, data []byte) error {
+		var envelope def.Envelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return errors.New("Failed to decode envelope")
+		}
+		if err := t.open(from, endpoint, envelope); err != nil {
+			return err
+		}
+		// the handler checks the content against the signer
+		return handler(envelope.Sender, envelope.Payload)
+	})
+}
+
+// open verifies the envelope and rejects replays.
+// Envelopes older than the previous period or past the next one are refused, so only the nonces of recent periods are kept.
+func (t *sealingTransport) open(from def.CTngID, endpoint string, envelope def.Envelope) error {
+	if len(envelope.Sender) == 0 || envelope.Sender[0] != 'M' {
+		return fmt.Errorf("Envelope from %s, only monitors gossip", envelope.Sender)
+	}
+	if from != "" && from != envelope.Sender {
+		return fmt.Errorf("%s forwarded an envelope of %s", from, envelope.Sender)
+	}
+	if err := t.m.Crypto.Open(envelope, endpoint); err != nil {
+		return err
+	}
+	current := t.m.GetPeriod()
+	if envelope.Period < current-1 {
+		return fmt.Errorf("Stale envelope from period %d", envelope.Period)
+	}
+	if envelope.Period > current+1 {
+		return fmt.Errorf("Envelope from future period %d", envelope.Period)
+	}
+	nonce := envelope.Sender.String() + "/" + hex.EncodeToString(envelope.Nonce)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for period := range t.seen {
+		if period < current-1 {
+			delete(t.seen, period)
+		}
+	}
+	if t.seen[envelope.Period] == nil {
+		t.seen[envelope.Period] = make(map[string]bool)
+	}
+	if t.seen[envelope.Period][nonce] {
+		return errors.New("Replayed envelope")
+	}
+	t.seen[envelope.Period][nonce] = true
+	return nil
+}
@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+// Endpoints carrying partial signatures, the only messages a faulty monitor tampers with
+var partialSignatureEndpoints = map[string]bool{
+	"/monitor/transparency_partial_signature":         true,
+	"/monitor/revocation_partial_signature":           true,
+	"/monitor/default_transparency_partial_signature": true,
+}
+
+// faultyTransport drops, delays or forges the partial signatures of a monitor configured as faulty
+type faultyTransport struct {
+	transport.Transport
+	m       *MonitorEEA
+	faults  []def.Fault
+	monitor map[string]def.CTngID // Monitor IDs, keyed by address
+}
+
+// injectFaults wraps the transport of the monitor when the settings make it misbehave
+func injectFaults(m *MonitorEEA, t transport.Transport) transport.Transport {
+	faults := def.GetFaults(*m.Settings, m.CTngID)
+	if len(faults) == 0 {
+		return t
+	}
+	monitors := make(map[string]def.CTngID)
+	for id, addr := range def.GetMonitorURL(*m.Settings) {
+		monitors[addr] = id
+	}
+	fmt.Println(def.RED+"Monitor", m.CTngID, "is faulty:", faults, def.RESET)
+	return &faultyTransport{Transport: t, m: m, faults: faults, monitor: monitors}
+}
+
+func (t *faultyTransport) Send(to string, endpoint string, data []byte) error {
+	if !partialSignatureEndpoints[endpoint] {
+		return t.Transport.Send(to, endpoint, data)
+	}
+	var msd MonitorSignedData
+	if err := json.Unmarshal(data, &msd); err != nil {
+		return t.Transport.Send(to, endpoint, data)
+	}
+	// relayed partial signatures of other monitors are left alone
+	if sigfrag, err := def.SigFragmentFromString(msd.Signature); err != nil || sigfrag.ID != t.m.CTngID {
+		return t.Transport.Send(to, endpoint, data)
+	}
+	var delay time.Duration
+	for _, fault := range t.faults {
+		if !fault.Active(msd.Period) || !fault.Affects(t.monitor[to]) {
+			continue
+		}
+		switch fault.Behavior {
+		case def.FAULT_DROP:
+			return nil
+		case def.FAULT_DELAY:
+			delay += time.Duration(fault.Delay) * time.Millisecond
+		case def.FAULT_BOGUS_SIG:
+			// a well formed fragment that fails the verification of the receivers
+			msd.Signature = t.m.ThresholdSign(fmt.Sprintf("bogus %s %d", msd.CTngID, msd.Period)).String()
+			bogus, err := json.Marshal(msd)
+			if err != nil {
+				return err
+			}
+			data = bogus
+		}
+	}
+	if delay > 0 {
+		t.m.Clock.AfterFunc(delay, func() {
+			t.Transport.Send(to, endpoint, data)
+		})
+		return nil
+	}
+	return t.Transport.Send(to, endpoint, data)
+}
+
+// Broadcast goes through Send so every target is looked up on its own
+func (t *faultyTransport) Broadcast(to []string, endpoint string, data []byte) {
+	for _, addr := range to {
+		t.Send(addr, endpoint, data)
+	}
+}
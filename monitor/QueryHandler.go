@@ -0,0 +1,188 @@
+package monitor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// registerQueries routes the relying party queries, they are served over HTTP whatever carries the protocol messages
+func registerQueries(m *MonitorEEA, gorillaRouter *mux.Router) {
+	gorillaRouter.HandleFunc("/monitor/sth/{LID}/{period}", bindContext(m, sth_query_handler)).Methods("GET")
+	gorillaRouter.HandleFunc("/monitor/inclusion/{LID}/{period}/{hash}", bindContext(m, inclusion_query_handler)).Methods("GET")
+	gorillaRouter.HandleFunc("/monitor/srh/{CAID}/{period}", bindContext(m, srh_query_handler)).Methods("GET")
+	gorillaRouter.HandleFunc("/monitor/revocation/{CAID}/{period}/{index}", bindContext(m, revocation_query_handler)).Methods("GET")
+	gorillaRouter.HandleFunc("/monitor/pom/{id}", bindContext(m, pom_query_handler)).Methods("GET")
+}
+
+// Read-only endpoints for relying parties, every response is a threshold-signed view agreed on by the monitors.
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func queryPeriod(w http.ResponseWriter, r *http.Request) (int, bool) {
+	period, err := strconv.Atoi(mux.Vars(r)["period"])
+	if err != nil {
+		http.Error(w, "Invalid period", http.StatusBadRequest)
+		return 0, false
+	}
+	return period, true
+}
+
+func sth_query_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+	period, ok := queryPeriod(w, r)
+	if !ok {
+		return
+	}
+	fsmlogger, err := m.GetFSMLogger(def.CTngID(mux.Vars(r)["LID"]), period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !fsmlogger.IsSignaturePresent() {
+		http.Error(w, "STH not threshold signed", http.StatusNotFound)
+		return
+	}
+	sth, _ := fsmlogger.GetField("STH")
+	sig, _ := fsmlogger.GetField("Signature")
+	sigstring, err := sig.(def.ThresholdSig).String()
+	if err != nil {
+		http.Error(w, "Failed to serialize signature", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, def.SignedSTH{STH: sth.(def.STH), Signature: sigstring})
+}
+
+func srh_query_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+	period, ok := queryPeriod(w, r)
+	if !ok {
+		return
+	}
+	fsmca, err := m.GetFSMCA(def.CTngID(mux.Vars(r)["CAID"]), period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !fsmca.IsSignaturePresent() {
+		http.Error(w, "SRH not threshold signed", http.StatusNotFound)
+		return
+	}
+	srh, _ := fsmca.GetField("SRH")
+	sig, _ := fsmca.GetField("Signature")
+	sigstring, err := sig.(def.ThresholdSig).String()
+	if err != nil {
+		http.Error(w, "Failed to serialize signature", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, def.SignedSRH{SRH: srh.(def.SRH), Signature: sigstring})
+}
+
+// pom_query_handler returns the PoMs against an entity over every period this monitor keeps
+func pom_query_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+	id := def.CTngID(mux.Vars(r)["id"])
+	records := []def.PoMRecord{}
+	for _, period := range m.GetPeriods() {
+		fsm, err := m.getAccusable(id, period)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		record := def.PoMRecord{Entity_Convicted: id, Period: period}
+		apom, _ := fsm.GetField("APoM")
+		if v := apom.(def.APoM); !reflect.DeepEqual(v, def.APoM{}) {
+			record.APoM = &v
+		}
+		cpom, _ := fsm.GetField("CPoM")
+		if v := cpom.(def.CPoM); !reflect.DeepEqual(v, def.CPoM{}) {
+			record.CPoM = &v
+		}
+		bpom, _ := fsm.GetField("BPoM")
+		if v := bpom.(def.BPoM); !reflect.DeepEqual(v, def.BPoM{}) {
+			record.BPoM = &v
+		}
+		if record.APoM != nil || record.CPoM != nil || record.BPoM != nil {
+			records = append(records, record)
+		}
+	}
+	writeJSON(w, records)
+}
+
+// inclusion_query_handler returns the PoI of a certificate, identified by the hex SHA256 of its DER, up to the STH of the period
+func inclusion_query_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+	period, ok := queryPeriod(w, r)
+	if !ok {
+		return
+	}
+	hash, err := hex.DecodeString(mux.Vars(r)["hash"])
+	if err != nil {
+		http.Error(w, "Invalid certificate hash", http.StatusBadRequest)
+		return
+	}
+	fsmlogger, err := m.GetFSMLogger(def.CTngID(mux.Vars(r)["LID"]), period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	proof, err := fsmlogger.InclusionProof(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, proof)
+}
+
+// revocation_query_handler answers whether a serial index of a CA is revoked as of a period, with the signed SRH as evidence
+func revocation_query_handler(m *MonitorEEA, w http.ResponseWriter, r *http.Request) {
+	period, ok := queryPeriod(w, r)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(mux.Vars(r)["index"])
+	if err != nil || index < 0 {
+		http.Error(w, "Invalid serial index", http.StatusBadRequest)
+		return
+	}
+	fsmca, err := m.GetFSMCA(def.CTngID(mux.Vars(r)["CAID"]), period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !fsmca.IsSignaturePresent() {
+		http.Error(w, "SRH not threshold signed", http.StatusNotFound)
+		return
+	}
+	crv := m.crvAt(fsmca)
+	if crv == nil {
+		http.Error(w, "CRV not known for this period", http.StatusNotFound)
+		return
+	}
+	srh, _ := fsmca.GetField("SRH")
+	sig, _ := fsmca.GetField("Signature")
+	sigstring, err := sig.(def.ThresholdSig).String()
+	if err != nil {
+		http.Error(w, "Failed to serialize signature", http.StatusInternalServerError)
+		return
+	}
+	// the client checks the bit against the CRV signed in the SRH
+	compressed, err := def.CompressDCRV(crv)
+	if err != nil {
+		http.Error(w, "Failed to compress the CRV", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, def.RevocationStatus{
+		SRH:       srh.(def.SRH),
+		Signature: sigstring,
+		Index:     index,
+		Revoked:   crv.Test(uint(index)),
+		CRV:       compressed,
+	})
+}
@@ -0,0 +1,522 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	bitset "github.com/bits-and-blooms/bitset"
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// A monitor persists every change to its state machines as a Record.
+// Replaying the records in order rebuilds the state after a restart.
+// Metrics (traffic, converge time) and notifications are not persisted, they only matter while the period is live.
+
+type Storage interface {
+	Append(rec Record) error
+	Load() ([]Record, error)
+	// Compact drops the records keep rejects
+	Compact(keep func(Record) bool) error
+	Close() error
+}
+
+type Record struct {
+	Kind   string          `json:"kind"` // period, field, update, sigfrag, accusation, certificates, crv or dcrv
+	Entity def.CTngID      `json:"entity,omitempty"`
+	Period int             `json:"period"`
+	Field  string          `json:"field,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// Fields of the state machines worth keeping across a restart
+var persistedFields = map[string]bool{
+	"STH":       true,
+	"SRH":       true,
+	"State":     true,
+	"Signature": true,
+	"APoM":      true,
+	"CPoM":      true,
+	"BPoM":      true,
+	"DataCheck": true,
+	"TimeCheck": true,
+	"Data":      true,
+}
+
+// Records a monitor must not lose in a crash: the period it reached and what it signed, so it never signs twice differently
+var syncedKinds = map[string]bool{
+	"period":     true,
+	"sigfrag":    true,
+	"accusation": true,
+}
+
+// FileStorage is an append-only log of JSON records
+type FileStorage struct {
+	lock sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileStorage(path string) (*FileStorage, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStorage{path: path, file: file}, nil
+}
+
+// Append writes the record, and syncs the log for the period and signature records
+func (s *FileStorage) Append(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if syncedKinds[rec.Kind] || (rec.Kind == "field" && rec.Field == "Signature") {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+// Load reads every record in the log.
+// A record cut short by a crash is dropped and the log truncated, so later appends stay readable.
+func (s *FileStorage) Load() ([]Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.load()
+}
+
+func (s *FileStorage) load() ([]Record, error) {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var records []Record
+	decoder := json.NewDecoder(s.file)
+	var valid int64
+	for {
+		var rec Record
+		err := decoder.Decode(&rec)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			fmt.Println("Dropping the incomplete tail of the monitor log:", err)
+			return records, s.file.Truncate(valid)
+		}
+		records = append(records, rec)
+		valid = decoder.InputOffset()
+	}
+}
+
+// Compact rewrites the log with the records keep accepts.
+// The new log is synced before it replaces the old one, a crash leaves either of them whole.
+func (s *FileStorage) Compact(keep func(Record) bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.Create(s.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	encoder := json.NewEncoder(tmp)
+	for _, rec := range records {
+		if !keep(rec) {
+			continue
+		}
+		if err := encoder.Encode(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	return nil
+}
+
+func (s *FileStorage) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+func persist(store Storage, rec Record) {
+	if store == nil {
+		return
+	}
+	if err := store.Append(rec); err != nil {
+		fmt.Println("Failed to persist monitor state:", err)
+	}
+}
+
+func fieldRecord(field string, value interface{}) (Record, bool) {
+	if !persistedFields[field] {
+		return Record{}, false
+	}
+	// BLS signatures only serialize through their string form
+	if sig, ok := value.(def.ThresholdSig); ok {
+		if sig.Sign == nil {
+			return Record{}, false
+		}
+		str, err := sig.String()
+		if err != nil {
+			return Record{}, false
+		}
+		value = str
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return Record{}, false
+	}
+	return Record{Kind: "field", Field: field, Value: raw}, true
+}
+
+func decodeField(field string, raw json.RawMessage) (interface{}, error) {
+	var err error
+	switch field {
+	case "STH":
+		var v def.STH
+		err = json.Unmarshal(raw, &v)
+		return v, err
+	case "SRH":
+		var v def.SRH
+		err = json.Unmarshal(raw, &v)
+		return v, err
+	case "State":
+		var v string
+		err = json.Unmarshal(raw, &v)
+		return v, err
+	case "Signature":
+		var v string
+		if err = json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		return def.ThresholdSigFromString(v)
+	case "APoM":
+		var v def.APoM
+		err = json.Unmarshal(raw, &v)
+		return v, err
+	case "CPoM":
+		return def.DecodeCPoM(raw)
+	case "BPoM":
+		var v def.BPoM
+		err = json.Unmarshal(raw, &v)
+		return v, err
+	case "DataCheck", "TimeCheck":
+		var v bool
+		err = json.Unmarshal(raw, &v)
+		return v, err
+	case "Data":
+		var v [][]byte
+		err = json.Unmarshal(raw, &v)
+		return v, err
+	default:
+		return nil, errors.New("unknown field")
+	}
+}
+
+func sigFragmentRecord(kind string, sigfrag def.SigFragment) Record {
+	raw, _ := json.Marshal(sigfrag.String())
+	return Record{Kind: kind, Value: raw}
+}
+
+func decodeSigFragment(raw json.RawMessage) (def.SigFragment, error) {
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return def.SigFragment{}, err
+	}
+	return def.SigFragmentFromString(str)
+}
+
+// Leaf hashes and heads of a Logger period, see FSMLoggerEEA.SetCertificates
+type certificatesRecord struct {
+	CertHashes [][]byte `json:"cert_hashes"`
+	Head_rs    []byte   `json:"head_rs,omitempty"`
+	Head_cert  []byte   `json:"head_cert"`
+}
+
+// bulky reports the records only needed until their period is pruned
+func bulky(rec Record) bool {
+	return rec.Kind == "update" || (rec.Kind == "field" && rec.Field == "Data")
+}
+
+// compactStorage drops the bulky records of the pruned periods, up to and including pruned
+func compactStorage(store Storage, pruned int) {
+	if store == nil {
+		return
+	}
+	err := store.Compact(func(rec Record) bool {
+		return rec.Period > pruned || !bulky(rec)
+	})
+	if err != nil {
+		fmt.Println("Failed to compact the monitor log:", err)
+	}
+}
+
+func (l *FSMLoggerEEA) persist(rec Record) {
+	if l.pruned && bulky(rec) {
+		return
+	}
+	rec.Entity = l.CTngID
+	rec.Period = l.Period
+	persist(l.store, rec)
+}
+
+func (ca *FSMCAEEA) persist(rec Record) {
+	if ca.pruned && bulky(rec) {
+		return
+	}
+	rec.Entity = ca.CTngID
+	rec.Period = ca.Period
+	persist(ca.store, rec)
+}
+
+// Method to apply a persisted record to the FSMLoggerEEA
+func (l *FSMLoggerEEA) apply(rec Record) error {
+	switch rec.Kind {
+	case "field":
+		value, err := decodeField(rec.Field, rec.Value)
+		if err != nil {
+			return err
+		}
+		return l.SetField(rec.Field, value)
+	case "update":
+		var update def.Update_Logger_EEA
+		if err := json.Unmarshal(rec.Value, &update); err != nil {
+			return err
+		}
+		l.StoreUpdate(update.MonitorID, update)
+		index, err := def.MapIDtoInt(update.MonitorID)
+		if err != nil {
+			return err
+		}
+		return l.AddDataFragment(index, update.FileShare)
+	case "sigfrag", "accusation":
+		sigfrag, err := decodeSigFragment(rec.Value)
+		if err != nil {
+			return err
+		}
+		if rec.Kind == "sigfrag" {
+			l.AddSignatureFragment(sigfrag)
+		} else {
+			l.AddAccusationFragment(sigfrag)
+		}
+		return nil
+	case "certificates":
+		var v certificatesRecord
+		if err := json.Unmarshal(rec.Value, &v); err != nil {
+			return err
+		}
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.CertHashes, l.Head_rs, l.Head_cert = v.CertHashes, v.Head_rs, v.Head_cert
+		return nil
+	default:
+		return fmt.Errorf("unknown record kind %s", rec.Kind)
+	}
+}
+
+// Method to apply a persisted record to the FSMCAEEA
+func (ca *FSMCAEEA) apply(rec Record) error {
+	switch rec.Kind {
+	case "field":
+		value, err := decodeField(rec.Field, rec.Value)
+		if err != nil {
+			return err
+		}
+		return ca.SetField(rec.Field, value)
+	case "update":
+		var update def.Update_CA_EEA
+		if err := json.Unmarshal(rec.Value, &update); err != nil {
+			return err
+		}
+		ca.StoreUpdate(update.MonitorID, update)
+		index, err := def.MapIDtoInt(update.MonitorID)
+		if err != nil {
+			return err
+		}
+		return ca.AddDataFragment(index, update.FileShare)
+	case "sigfrag", "accusation":
+		sigfrag, err := decodeSigFragment(rec.Value)
+		if err != nil {
+			return err
+		}
+		if rec.Kind == "sigfrag" {
+			ca.AddSignatureFragment(sigfrag)
+		} else {
+			ca.AddAccusationFragment(sigfrag)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown record kind %s", rec.Kind)
+	}
+}
+
+// OpenStorage replays the records of store into the monitor, then persists every later change to it.
+// It must be called before the monitor starts serving requests.
+func (m *MonitorEEA) OpenStorage(store Storage) error {
+	records, err := store.Load()
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := m.replay(rec); err != nil {
+			return fmt.Errorf("replaying %s record of %s, period %d: %v", rec.Kind, rec.Entity, rec.Period, err)
+		}
+	}
+	m.lock.Lock()
+	m.store = store
+	for _, fsmlist := range m.LoggerHistory {
+		for _, fsmlogger := range fsmlist {
+			fsmlogger.lock.Lock()
+			fsmlogger.store = store
+			fsmlogger.lock.Unlock()
+		}
+	}
+	for _, fsmlist := range m.CAHistory {
+		for _, fsmca := range fsmlist {
+			fsmca.lock.Lock()
+			fsmca.store = store
+			fsmca.lock.Unlock()
+		}
+	}
+	m.lock.Unlock()
+	if len(records) > 0 {
+		fmt.Println(def.BLUE+"Monitor", m.CTngID, "restored", len(records), "records up to period", m.GetPeriod(), def.RESET)
+	}
+	return nil
+}
+
+func (m *MonitorEEA) replay(rec Record) error {
+	switch rec.Kind {
+	case "period":
+		// the timers of the restored period are resumed once the whole log is replayed
+		_, err := m.advancePeriod(rec.Period)
+		return err
+	case "crv", "dcrv":
+		return m.replayCRV(rec)
+	}
+	switch {
+	case strings.HasPrefix(rec.Entity.String(), "L"):
+		fsmlogger, err := m.GetFSMLogger(rec.Entity, rec.Period)
+		if err != nil {
+			return err
+		}
+		return fsmlogger.apply(rec)
+	case strings.HasPrefix(rec.Entity.String(), "C"):
+		fsmca, err := m.GetFSMCA(rec.Entity, rec.Period)
+		if err != nil {
+			return err
+		}
+		return fsmca.apply(rec)
+	default:
+		return errors.New("unknown entity")
+	}
+}
+
+// replayCRV restores the CRV of a CA from a full CRV, or rolls it forward from the previous one with a DCRV
+func (m *MonitorEEA) replayCRV(rec Record) error {
+	fsmca, err := m.GetFSMCA(rec.Entity, rec.Period)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if err := json.Unmarshal(rec.Value, &data); err != nil {
+		return err
+	}
+	crv := new(bitset.BitSet)
+	if rec.Kind == "crv" {
+		if err := crv.UnmarshalBinary(data); err != nil {
+			return err
+		}
+	} else {
+		dcrv, err := def.DecompressDCRV(data)
+		if err != nil {
+			return err
+		}
+		prev, known := previousCRV(m, rec.Entity, rec.Period)
+		if !known {
+			return nil
+		}
+		crv = def.ApplyDCRV(prev, dcrv)
+	}
+	m.setCRV(fsmca, crv, rec)
+	return nil
+}
+
+// resumeTimers restarts the verification timers lost with the process, for the Loggers and CAs of the current period still waiting on them.
+// The full wait applies again, the monitor cannot tell how much of it passed before the restart.
+// The first period has no start to time from, only the STHs and SRHs already received resume their timers.
+func resumeTimers(m *MonitorEEA) {
+	m.lock.RLock()
+	period, fsmLoggers, fsmCAs := m.Period, m.FSMLoggerEEAs, m.FSMCAEEAs
+	m.lock.RUnlock()
+	if period > 1 {
+		startPeriodTimers(m, fsmLoggers, fsmCAs)
+		return
+	}
+	var started []*FSMLoggerEEA
+	for _, fsmlogger := range fsmLoggers {
+		if state, _ := fsmlogger.GetField("State"); state != def.INIT {
+			started = append(started, fsmlogger)
+		}
+	}
+	var startedCAs []*FSMCAEEA
+	for _, fsmca := range fsmCAs {
+		if state, _ := fsmca.GetField("State"); state != def.INIT {
+			startedCAs = append(startedCAs, fsmca)
+		}
+	}
+	startPeriodTimers(m, started, startedCAs)
+}
+
+// restoreFromStorage opens the log of the monitor under Settings.Storage_Dir, if set, and resumes from the state it holds
+func restoreFromStorage(m *MonitorEEA) {
+	if m.Settings.Storage_Dir == "" {
+		return
+	}
+	if err := os.MkdirAll(m.Settings.Storage_Dir, 0755); err != nil {
+		log.Fatalf("Failed to create storage directory: %v", err)
+	}
+	store, err := NewFileStorage(filepath.Join(m.Settings.Storage_Dir, m.CTngID.String()+".log"))
+	if err != nil {
+		log.Fatalf("Failed to open monitor log: %v", err)
+	}
+	if err := m.OpenStorage(store); err != nil {
+		log.Fatalf("Failed to restore monitor state: %v", err)
+	}
+	resumeTimers(m)
+}
+
+// bytesRecord wraps raw bytes as the value of a record
+func bytesRecord(kind string, data []byte) Record {
+	raw, _ := json.Marshal(data)
+	return Record{Kind: kind, Value: raw}
+}
@@ -1,17 +1,49 @@
 package monitor
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
+	bitset "github.com/bits-and-blooms/bitset"
+	"github.com/gorilla/mux"
+	ca "github.com/jik18001/CTngV3/ca"
 	def "github.com/jik18001/CTngV3/def"
+	logger "github.com/jik18001/CTngV3/logger"
+	transport "github.com/jik18001/CTngV3/transport"
+	merkletree "github.com/txaty/go-merkletree"
 )
 
+// testconfig is a crypto config generated for the test settings with its key files, the committed one holds no private keys
+var testconfig string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ctng")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testconfig = filepath.Join(dir, "testconfig.json")
+	settings := new(def.Settings)
+	def.LoadData(settings, "../def/testsettings.json")
+	if err := def.GenerateKeyFiles(*settings, testconfig); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestMonitorCryptoFunctionality(t *testing.T) {
 	// Initialize a Monitor with 2 FSMCAs and 2 FSMLoggers
-	m1 := NewMonitorEEA(def.CTngID("M1"), "../def/testconfig.json", "../def/testsettings.json")
-	m2 := NewMonitorEEA(def.CTngID("M2"), "../def/testconfig.json", "../def/testsettings.json")
-	m3 := NewMonitorEEA(def.CTngID("M3"), "../def/testconfig.json", "../def/testsettings.json")
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	m2 := NewMonitorEEA(def.CTngID("M2"), testconfig, "../def/testsettings.json")
+	m3 := NewMonitorEEA(def.CTngID("M3"), testconfig, "../def/testsettings.json")
 	fmt.Println(m1.Settings.Ipmap[m1.CTngID])
 	// Example test data
 	testData := "test data"
@@ -33,3 +65,513 @@ func TestMonitorCryptoFunctionality(t *testing.T) {
 	testint, _ = def.MapIDtoInt(def.CTngID("L1"))
 	fmt.Println(testint)
 }
+
+func TestSTHQuery(t *testing.T) {
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	router := mux.NewRouter()
+	router.HandleFunc("/monitor/sth/{LID}/{period}", bindContext(m1, sth_query_handler)).Methods("GET")
+	router.HandleFunc("/monitor/pom/{id}", bindContext(m1, pom_query_handler)).Methods("GET")
+
+	fsmlogger, err := m1.GetFSMLogger(def.CTngID("L1"), 1)
+	if err != nil {
+		t.Fatalf("Failed to locate Logger state: %v", err)
+	}
+	sth := def.STH{LID: "L1", PeriodNum: 1, Head: []byte("head")}
+	fsmlogger.SetField("STH", sth)
+
+	// not threshold signed yet
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/monitor/sth/L1/1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unsigned STH, got %d", rec.Code)
+	}
+
+	sthBytes, _ := json.Marshal(sth)
+	var siglist []def.SigFragment
+	for _, id := range []string{"M1", "M2", "M3"} {
+		m := NewMonitorEEA(def.CTngID(id), testconfig, "../def/testsettings.json")
+		siglist = append(siglist, m.ThresholdSign(string(sthBytes)))
+	}
+	fsmlogger.SetField("Signature", m1.Aggregate(siglist))
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/monitor/sth/L1/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var signed def.SignedSTH
+	if err := json.NewDecoder(rec.Body).Decode(&signed); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	sig, err := def.ThresholdSigFromString(signed.Signature)
+	if err != nil {
+		t.Fatalf("Failed to decode signature: %v", err)
+	}
+	if err := m1.ThresholdVerify(string(sthBytes), sig); err != nil {
+		t.Errorf("Verification failed: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/monitor/pom/L1", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "[]\n" {
+		t.Errorf("Expected no PoMs, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRevocationData(t *testing.T) {
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	newCA := func() *ca.CA {
+		c := ca.NewCA(def.CTngID("C1"), testconfig, "../def/testsettings.json")
+		c.Settings.Distribution_Mode = def.EEA
+		c.Settings.CRV_size = 10000
+		c.CRV = bitset.New(10000)
+		return c
+	}
+	c1 := newCA()
+	for period := 1; period <= 2; period++ {
+		c1.PeriodNum = period
+		m1.AdvancePeriod(period)
+		serial := fmt.Sprint(period)
+		c1.Issue(serial)
+		if _, _, err := c1.Revoke(serial); err != nil {
+			t.Fatalf("Revoke failed: %v", err)
+		}
+		dcrv := c1.GenerateUpdateEEA()
+		update := c1.Updates_EEA[def.CTngID("M1")]
+		crv, err := checkRevocationData(m1, update.SRH, dcrv, update.Head_rs)
+		if err != nil {
+			t.Fatalf("Period %d: %v", period, err)
+		}
+		if crv == nil || !crv.Equal(c1.CRV) {
+			t.Fatalf("Period %d: CRV differs from the CA", period)
+		}
+		fsmca, _ := m1.GetFSMCA(def.CTngID("C1"), period)
+		m1.setCRV(fsmca, crv, Record{})
+	}
+
+	// a CA whose CRV dropped the revocations of period 1
+	c2 := newCA()
+	c2.PeriodNum = 2
+	dcrv := c2.GenerateUpdateEEA()
+	update := c2.Updates_EEA[def.CTngID("M1")]
+	if _, err := checkRevocationData(m1, update.SRH, dcrv, update.Head_rs); err == nil {
+		t.Errorf("CRV accepted without the revocations of the previous period")
+	}
+
+	// without the previous CRV only the DCRV can be checked
+	c2.PeriodNum = 4
+	m1.AdvancePeriod(3)
+	m1.AdvancePeriod(4)
+	dcrv = c2.GenerateUpdateEEA()
+	update = c2.Updates_EEA[def.CTngID("M1")]
+	crv, err := checkRevocationData(m1, update.SRH, dcrv, update.Head_rs)
+	if err != nil || crv != nil {
+		t.Errorf("Expected an unknown CRV, got %v, %v", crv, err)
+	}
+
+	// only the latest CRV outlives the pruned periods
+	if fsmca, _ := m1.GetFSMCA(def.CTngID("C1"), 1); fsmca.GetCRV() != nil {
+		t.Errorf("CRV of a pruned period kept")
+	}
+	if fsmca, _ := m1.GetFSMCA(def.CTngID("C1"), 2); !m1.crvAt(fsmca).Equal(c1.CRV) {
+		t.Errorf("Latest CRV dropped")
+	}
+
+	// a peer resynchronizes the CRV, if it matches the SRH
+	fsmca, _ := m1.GetFSMCA(def.CTngID("C1"), 4)
+	fsmca.SetField("SRH", update.SRH)
+	transfer := func(crv *bitset.BitSet) []byte {
+		crvbytes, _ := crv.MarshalBinary()
+		data, _ := json.Marshal(CRVTransfer{CAID: "C1", Period: 4, CRV: crvbytes})
+		return data
+	}
+	if err := crv_handler(m1, transfer(c1.CRV)); err == nil || m1.crvAt(fsmca) != nil {
+		t.Errorf("CRV of another CA accepted")
+	}
+	if err := crv_handler(m1, transfer(c2.CRV)); err != nil || !m1.crvAt(fsmca).Equal(c2.CRV) {
+		t.Errorf("CRV not resynchronized: %v", err)
+	}
+}
+
+func TestAdvancePeriod(t *testing.T) {
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	if err := m1.AdvancePeriod(1000000000); err == nil || m1.GetPeriod() != 1 {
+		t.Fatalf("Moved to period %d", m1.GetPeriod())
+	}
+	if err := m1.AdvancePeriod(2); err != nil || m1.GetPeriod() != 2 {
+		t.Fatalf("Failed to move to the next period: %v", err)
+	}
+}
+
+func TestForgedShareRoot(t *testing.T) {
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	m1.Transport = transport.NewNetwork().Transport(m1.Settings.Ipmap[m1.CTngID])
+	l1 := logger.NewLogger(def.CTngID("L1"), testconfig, "../def/testsettings.json")
+	l1.Settings.Distribution_Mode = def.EEA
+	l1.GenerateUpdate()
+	update := *l1.Updates_EEA[def.CTngID("M1")]
+
+	// a share proven at the right position, but under a root the STH does not sign
+	forged := update
+	forged.FileShare = def.CorruptShare(update.FileShare)
+	blocks := make([]merkletree.DataBlock, m1.Settings.Num_Monitors)
+	for i := range blocks {
+		blocks[i] = &def.LeafBlock{Content: []byte{byte(i)}}
+	}
+	blocks[0] = &def.LeafBlock{Content: forged.FileShare}
+	tree, err := def.GenerateMerkleTree(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Head_rs = def.GenerateRootHash(tree)
+	forged.PoI, _ = def.GeneratePOI(nil, blocks, 0)
+	process_logger_update_EEA(m1, forged.STH, forged)
+	fsmlogger, _ := m1.GetFSMLogger(def.CTngID("L1"), update.STH.PeriodNum)
+	if frag, _ := fsmlogger.GetDataFragment(0); len(frag) > 0 {
+		t.Fatalf("Stored a share under an unsigned Head_rs")
+	}
+
+	// as is the share of M2 claimed for M1
+	swapped := *l1.Updates_EEA[def.CTngID("M2")]
+	swapped.MonitorID = def.CTngID("M1")
+	process_logger_update_EEA(m1, swapped.STH, swapped)
+	if frag, _ := fsmlogger.GetDataFragment(0); len(frag) > 0 {
+		t.Fatalf("Stored the share of M2 as the share of M1")
+	}
+	process_logger_update_EEA(m1, update.STH, update)
+	if frag, _ := fsmlogger.GetDataFragment(0); !reflect.DeepEqual(frag, update.FileShare) {
+		t.Errorf("Honest share not stored")
+	}
+}
+
+func TestEmptyPeriod(t *testing.T) {
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	m1.Transport = transport.NewNetwork().Transport(m1.Settings.Ipmap[m1.CTngID])
+	l1 := logger.NewLogger(def.CTngID("L1"), testconfig, "../def/testsettings.json")
+	l1.Settings.Distribution_Mode = def.EEA
+	l1.GenerateUpdate()
+	for _, update := range l1.Updates_EEA {
+		process_logger_update_EEA(m1, update.STH, *update)
+	}
+	fsmlogger, _ := m1.GetFSMLogger(def.CTngID("L1"), 1)
+	sth, _ := fsmlogger.GetField("STH")
+	if check, _ := fsmlogger.GetField("DataCheck"); check != true || sth.(def.STH).Size != 0 {
+		t.Errorf("STH of an empty period not verified: %v", sth)
+	}
+}
+
+func TestResignedSTH(t *testing.T) {
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	m1.Transport = transport.NewNetwork().Transport(m1.Settings.Ipmap[m1.CTngID])
+	l1 := logger.NewLogger(def.CTngID("L1"), testconfig, "../def/testsettings.json")
+	l1.Settings.Distribution_Mode = def.EEA
+	// ECDSA signatures are randomized, signing the same STH twice gives two signatures
+	key, err := def.Signers[def.DSS_ECDSA].GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*def.GlobalCrypto{m1.Crypto, l1.Crypto} {
+		if c.DSS_scheme_map == nil {
+			c.DSS_scheme_map = map[def.CTngID]string{}
+		}
+		if c.Signer_public_map == nil {
+			c.Signer_public_map = def.SignerPublicMap{}
+		}
+		if c.Signer_private_map == nil {
+			c.Signer_private_map = def.SignerPrivateMap{}
+		}
+		c.DSS_scheme_map["L1"] = def.DSS_ECDSA
+		c.Signer_public_map["L1"] = key.Public()
+		c.Signer_private_map["L1"] = key
+	}
+	l1.GenerateUpdate()
+	update := *l1.Updates_EEA[def.CTngID("M1")]
+	process_logger_update_EEA(m1, update.STH, update)
+
+	resigned := *l1.Updates_EEA[def.CTngID("M2")]
+	unsigned := resigned.STH
+	unsigned.Signature = def.RSASig{}
+	msg, _ := json.Marshal(unsigned)
+	resigned.STH.Signature, err = l1.Crypto.Sign(msg, "L1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(resigned.STH.Signature, update.STH.Signature) {
+		t.Fatalf("Signing twice gave the same signature")
+	}
+	process_logger_update_EEA(m1, resigned.STH, resigned)
+	fsmlogger, _ := m1.GetFSMLogger(def.CTngID("L1"), update.STH.PeriodNum)
+	if cpom, _ := fsmlogger.GetField("CPoM"); !reflect.DeepEqual(cpom, def.CPoM{}) {
+		t.Errorf("Convicted L1 of two signatures of the same STH")
+	}
+}
+
+func TestStorageRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "M1.log")
+	store, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	if err := m1.OpenStorage(store); err != nil {
+		t.Fatalf("Failed to open empty storage: %v", err)
+	}
+
+	sth := def.STH{LID: "L1", PeriodNum: 1, Head: []byte("head")}
+	fsmlogger, _ := m1.GetFSMLogger(def.CTngID("L1"), 1)
+	fsmlogger.SetField("STH", sth)
+	fsmlogger.SetField("State", def.PRECOMMIT)
+	fsmlogger.StoreUpdate(def.CTngID("M2"), def.Update_Logger_EEA{MonitorID: "M2", STH: sth, FileShare: []byte("share")})
+	fsmlogger.AddDataFragment(1, []byte("share"))
+	sthBytes, _ := json.Marshal(sth)
+	var siglist []def.SigFragment
+	for _, id := range []string{"M1", "M2", "M3"} {
+		m := NewMonitorEEA(def.CTngID(id), testconfig, "../def/testsettings.json")
+		sigfrag := m.ThresholdSign(string(sthBytes))
+		siglist = append(siglist, sigfrag)
+		fsmlogger.AddSignatureFragment(sigfrag)
+	}
+	fsmlogger.SetField("Signature", m1.Aggregate(siglist))
+
+	m1.AdvancePeriod(2)
+	fsmca, _ := m1.GetFSMCA(def.CTngID("C1"), 2)
+	crv := bitset.New(100).Set(7)
+	crvbytes, _ := crv.MarshalBinary()
+	m1.setCRV(fsmca, crv, bytesRecord("crv", crvbytes))
+	fsmca.AddAPoM(def.APoM{Entity_Convicted: "C1", Period: 2, Signature: "sig"})
+	store.Close()
+
+	// a record cut short by a crash
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(`{"kind":"field","entity":"L1"`)
+	file.Close()
+
+	store, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+	m2 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	if err := m2.OpenStorage(store); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if m2.GetPeriod() != 2 {
+		t.Errorf("Restored period %d, expected 2", m2.GetPeriod())
+	}
+	restored, _ := m2.GetFSMLogger(def.CTngID("L1"), 1)
+	if state, _ := restored.GetField("State"); state != def.PRECOMMIT {
+		t.Errorf("Restored state %v", state)
+	}
+	if got, _ := restored.GetField("STH"); !reflect.DeepEqual(got, sth) {
+		t.Errorf("Restored STH %v", got)
+	}
+	if restored.GetSignatureListLength() != 3 || !restored.IsSignaturePresent() {
+		t.Errorf("Signatures not restored")
+	}
+	sig, _ := restored.GetField("Signature")
+	if err := m2.ThresholdVerify(string(sthBytes), sig.(def.ThresholdSig)); err != nil {
+		t.Errorf("Restored signature does not verify: %v", err)
+	}
+	if share, _ := restored.GetDataFragment(1); string(share) != "share" {
+		t.Errorf("Data fragment not restored")
+	}
+	restoredCA, _ := m2.GetFSMCA(def.CTngID("C1"), 2)
+	if restoredCA.GetCRV() == nil || !restoredCA.GetCRV().Equal(crv) {
+		t.Errorf("CRV not restored")
+	}
+	if apom, _ := restoredCA.GetField("APoM"); apom.(def.APoM).Signature != "sig" {
+		t.Errorf("APoM not restored")
+	}
+
+	// the restored monitor keeps appending after the dropped record
+	restored.SetField("TimeCheck", true)
+	if records, err := store.Load(); err != nil || records[len(records)-1].Field != "TimeCheck" {
+		t.Errorf("Log not readable after restore: %v", err)
+	}
+}
+
+func TestStorageCompaction(t *testing.T) {
+	store, err := NewFileStorage(filepath.Join(t.TempDir(), "M1.log"))
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer store.Close()
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	if err := m1.OpenStorage(store); err != nil {
+		t.Fatalf("Failed to open empty storage: %v", err)
+	}
+	sth := def.STH{LID: "L1", PeriodNum: 1, Head: []byte("head")}
+	fsmlogger, _ := m1.GetFSMLogger(def.CTngID("L1"), 1)
+	fsmlogger.SetField("STH", sth)
+	fsmlogger.StoreUpdate(def.CTngID("M2"), def.Update_Logger_EEA{MonitorID: "M2", STH: sth, FileShare: []byte("share")})
+	m1.AdvancePeriod(2)
+	m1.AdvancePeriod(3)
+	// a late share of a pruned period is not persisted either
+	fsmlogger.StoreUpdate(def.CTngID("M3"), def.Update_Logger_EEA{MonitorID: "M3", STH: sth, FileShare: []byte("share")})
+
+	records, err := store.Load()
+	if err != nil {
+		t.Fatalf("Failed to load the compacted log: %v", err)
+	}
+	kinds := make(map[string]int)
+	for _, rec := range records {
+		kinds[rec.Kind]++
+	}
+	if kinds["update"] != 0 || kinds["field"] != 1 || kinds["period"] != 2 {
+		t.Errorf("Unexpected records after compaction: %v", kinds)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	m1 := NewMonitorEEA(def.CTngID("M1"), testconfig, "../def/testsettings.json")
+	monitors := def.GetMonitorURL(*m1.Settings)
+	marshal := func(v interface{}) []byte {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	note := marshal(def.Notification{Type: def.TUEEA, Originator: "L1", Monitor: "M3", Period: 1, Sender: monitors["M2"]})
+	update := marshal(def.Update_Logger_EEA{MonitorID: "M1", STH: def.STH{LID: "L1", PeriodNum: 1}})
+	cases := []struct {
+		from  def.CTngID
+		data  []byte
+		valid bool
+	}{
+		{"", note, true}, // unauthenticated transport
+		{"M2", note, true},
+		{"M3", note, false},  // M3 claims to be M2
+		{"M3", update, true}, // monitors forward the updates of others
+		{"L1", update, true},
+		{"L2", update, false},
+		{"L1", marshal(def.Update_Logger_EEA{MonitorID: "M2", STH: def.STH{LID: "L1"}}), false},
+		{"L1", note, false}, // Loggers do not gossip
+		{"M9", note, false},
+	}
+	for _, c := range cases {
+		if err := authenticate(m1, c.from, c.data); (err == nil) != c.valid {
+			t.Errorf("%s sending %s: %v", c.from, c.data, err)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	crypto := def.CTngKeyGen(2, 2, 4, 3)
+	settings := def.Generate_IP_Json_template(
+		2, 2, 4, 2,
+		"127.0.0.", 10, "127.0.1.", 20, "127.0.2.", 30,
+		8000, 5, 0, 6, 10,
+		30, def.EEA, def.MIN_WT, 10000, 0.002, 2000, 20,
+	)
+	monitors := def.GetMonitorURL(*settings)
+	network := transport.NewNetwork()
+	m1 := InitMonitorEEA("M1", crypto, settings, def.RealClock{})
+	m2 := InitMonitorEEA("M2", crypto, settings, def.RealClock{})
+	t1 := newSealingTransport(m1, network.Transport(monitors["M1"]))
+	t2 := newSealingTransport(m2, network.Transport(monitors["M2"]))
+
+	endpoint := "/monitor/transparency_notification"
+	type message struct {
+		from def.CTngID
+		data string
+	}
+	received := make(chan message, 1)
+	t2.Register(endpoint, func(from def.CTngID, data []byte) error {
+		received <- message{from, string(data)}
+		return nil
+	})
+	if err := t1.Send(monitors["M2"], endpoint, []byte("note")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got.from != "M1" || got.data != "note" {
+		t.Fatalf("Received %v", got)
+	}
+
+	envelope, err := crypto.Seal("M1", endpoint, 1, []byte("note"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := t2.open("", endpoint, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if t2.open("", endpoint, envelope) == nil {
+		t.Error("Replayed envelope accepted")
+	}
+	fresh, _ := crypto.Seal("M1", endpoint, 1, []byte("note"))
+	if t2.open("M3", endpoint, fresh) == nil {
+		t.Error("Envelope accepted from a peer other than its sender")
+	}
+	logger, _ := crypto.Seal("L1", endpoint, 1, []byte("note"))
+	if t2.open("", endpoint, logger) == nil {
+		t.Error("Envelope of a Logger accepted")
+	}
+	m2.AdvancePeriod(2)
+	m2.AdvancePeriod(3)
+	if t2.open("", endpoint, fresh) == nil {
+		t.Error("Stale envelope accepted")
+	}
+	next, _ := crypto.Seal("M1", endpoint, 4, []byte("note"))
+	if err := t2.open("", endpoint, next); err != nil {
+		t.Errorf("Envelope of the next period refused: %v", err)
+	}
+	future, _ := crypto.Seal("M1", endpoint, 5, []byte("note"))
+	if t2.open("", endpoint, future) == nil {
+		t.Error("Envelope of a future period accepted")
+	}
+}
+
+func TestResharing(t *testing.T) {
+	network := transport.NewNetwork()
+	var monitors []*MonitorEEA
+	for _, id := range []def.CTngID{"M1", "M2", "M3", "M4"} {
+		m := NewMonitorEEA(id, testconfig, "../def/testsettings.json")
+		m.Settings.Response_Wait_time = 1
+		m.KeyFile = filepath.Join(t.TempDir(), id.String()+".json")
+		m.Transport = network.Transport(def.GetMonitorURL(*m.Settings)[id])
+		monitors = append(monitors, m)
+	}
+	groupKey := *monitors[0].Crypto.TSS_master_key
+	oldShare := monitors[0].Crypto.TSS_private_map["M1"]
+
+	errs := make(chan error, len(monitors))
+	for _, m := range monitors {
+		m := m
+		go func() {
+			errs <- runResharing(m, m.Settings, 3)
+		}()
+	}
+	for range monitors {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := "STH of period 1"
+	var siglist []def.SigFragment
+	for _, m := range monitors[1:] {
+		if !m.Crypto.TSS_master_key.IsEqual(&groupKey) {
+			t.Fatalf("Resharing changed the group key of %s", m.CTngID)
+		}
+		siglist = append(siglist, m.ThresholdSign(msg))
+	}
+	m1 := monitors[0]
+	if err := m1.ThresholdVerify(msg, m1.Aggregate(siglist)); err != nil {
+		t.Errorf("Reshared partial signatures do not aggregate: %v", err)
+	}
+	newShare := m1.Crypto.TSS_private_map["M1"]
+	if newShare.IsEqual(&oldShare) {
+		t.Error("Resharing kept the share")
+	}
+
+	// a restarted monitor loads the reshared keys from its key file
+	restarted := NewMonitorEEA("M1", testconfig, "../def/testsettings.json")
+	restarted.KeyFile = m1.KeyFile
+	if loaded, err := loadThresholdKeys(restarted); err != nil || !loaded {
+		t.Fatalf("Reshared keys not loaded: %v", err)
+	}
+	loadedShare := restarted.Crypto.TSS_private_map["M1"]
+	if !loadedShare.IsEqual(&newShare) {
+		t.Error("The key file does not hold the reshared share")
+	}
+}
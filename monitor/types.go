@@ -2,11 +2,13 @@ package monitor
 
 import (
 	"fmt"
-	"net/http"
+	"log"
+	"sort"
 	"sync"
 	"time"
 
 	def "github.com/jik18001/CTngV3/def"
+	transport "github.com/jik18001/CTngV3/transport"
 )
 
 type MonitorEEA struct {
@@ -15,56 +17,39 @@ type MonitorEEA struct {
 	Crypto            *def.GlobalCrypto
 	Settings          *def.Settings
 	Broadcast_targets map[def.CTngID]string
-	FSMCAEEAs         []*FSMCAEEA
-	FSMLoggerEEAs     []*FSMLoggerEEA
-	Client            *http.Client
+	Period            int                     // Latest period this monitor has seen
+	periodStart       time.Time               // When this monitor entered Period
+	FSMCAEEAs         []*FSMCAEEA             // State machines of the latest period
+	FSMLoggerEEAs     []*FSMLoggerEEA         // State machines of the latest period
+	CAHistory         map[int][]*FSMCAEEA     // State machines of every period, keyed by Period
+	LoggerHistory     map[int][]*FSMLoggerEEA // State machines of every period, keyed by Period
+	CRVs              map[def.CTngID]CRVState // CRV of each CA after the latest period it was verified
+	lock              sync.RWMutex            // Guards Period, the state machine history and the CRVs
+	store             Storage                 // Persists the state machines, nil keeps them in memory only
+	Clock             def.Clock
+	Transport         transport.Transport // Carries the protocol messages, the relying party queries are served over HTTP
+	KeyFile           string              // Secrets of this monitor, where the DKG stores its keys; empty keeps them in memory only
 }
 
 type MonitorSignedData struct {
 	Type      string
 	CTngID    def.CTngID
+	Period    int
 	Signature string
 }
 
-type countWriter struct {
-	count *int64
-}
-
-func (cw *countWriter) Write(p []byte) (int, error) {
-	n := len(p)
-	*cw.count += int64(n)
-	return n, nil
-}
-
-// Function to initialize a MonitorEEA with specified numbers of FSMCAEEA and FSMLoggerEEA instances
-func NewMonitorEEA(CTngID def.CTngID, cryptofile string, settingfile string) *MonitorEEA {
-	// Initialize a new StoredCrypto object.
-	restoredconfig := new(def.StoredCrypto)
-	// Initialize a new Settings object.
-	restoredsetting := new(def.Settings)
-	// Load the configuration from the files.
-	def.LoadData(&restoredsetting, settingfile)
-	def.LoadData(&restoredconfig, cryptofile)
-	config, err := def.DecodeCrypto(restoredconfig)
-	if err != nil {
-		def.HandleError(err, "DecodeCrypto")
-	}
-
-	numFSMCAEEAs := restoredsetting.Num_CAs
-	numFSMLoggerEEAs := restoredsetting.Num_Loggers
-	numMonitors := restoredsetting.Num_Monitors
-
+// Function to initialize the FSMCAEEA instances of a single period
+func newFSMCAEEAs(settings *def.Settings, period int, store Storage, now time.Time) []*FSMCAEEA {
+	numFSMCAEEAs := settings.Num_CAs
+	numMonitors := settings.Num_Monitors
 	fsmCAs := make([]*FSMCAEEA, numFSMCAEEAs)
-	fsmLoggers := make([]*FSMLoggerEEA, numFSMLoggerEEAs)
-
-	// Initialize FSMCAEEA instances
 	for i := 0; i < numFSMCAEEAs; i++ {
 		id := def.CTngID(fmt.Sprintf("C%d", i+1))
 		fsmCAs[i] = &FSMCAEEA{
 			CTngID:               id,
 			State:                def.INIT,
 			lock:                 sync.RWMutex{},
-			Period:               0,
+			Period:               period,
 			SRH:                  def.SRH{},
 			Updates:              make(map[def.CTngID]def.Update_CA_EEA),
 			Notifications:        make([]def.Notification, 0),
@@ -76,30 +61,38 @@ func NewMonitorEEA(CTngID def.CTngID, cryptofile string, settingfile string) *Mo
 			Signature:            def.ThresholdSig{},
 			APoM:                 def.APoM{},
 			CPoM:                 def.CPoM{},
+			BPoM:                 def.BPoM{},
 			TrafficCount:         0,
 			UpdateCount:          0,
-			StartTime:            time.Now(),
+			StartTime:            now,
 			ConvergeTime:         0,
 			Bmodes:               make([]string, numMonitors),
 			EEA_Notifications:    make([][]def.Notification, numMonitors),
+			store:                store,
 		}
 		for j := 0; j < numMonitors; j++ {
-			fsmCAs[i].Bmodes[j] = restoredsetting.Broadcasting_Mode
+			fsmCAs[i].Bmodes[j] = settings.Broadcasting_Mode
 		}
 	}
+	return fsmCAs
+}
 
-	// Initialize FSMLoggerEEA instances
+// Function to initialize the FSMLoggerEEA instances of a single period
+func newFSMLoggerEEAs(settings *def.Settings, period int, store Storage, now time.Time) []*FSMLoggerEEA {
+	numFSMLoggerEEAs := settings.Num_Loggers
+	numMonitors := settings.Num_Monitors
+	fsmLoggers := make([]*FSMLoggerEEA, numFSMLoggerEEAs)
 	for i := 0; i < numFSMLoggerEEAs; i++ {
 		id := def.CTngID(fmt.Sprintf("L%d", i+1))
 		fsmLoggers[i] = &FSMLoggerEEA{
 			CTngID:               id,
 			State:                def.INIT,
 			lock:                 sync.RWMutex{},
-			Period:               0,
+			Period:               period,
 			STH:                  def.STH{},
 			Updates:              make(map[def.CTngID]def.Update_Logger_EEA),
 			DataFragments:        make([][]byte, numMonitors),
-			Bmode:                restoredsetting.Broadcasting_Mode,
+			Bmode:                settings.Broadcasting_Mode,
 			Bmodes:               make([]string, numMonitors),
 			EEA_Notifications:    make([][]def.Notification, numMonitors),
 			DataFragment_Counter: 0,
@@ -110,19 +103,45 @@ func NewMonitorEEA(CTngID def.CTngID, cryptofile string, settingfile string) *Mo
 			Signature:            def.ThresholdSig{},
 			APoM:                 def.APoM{},
 			CPoM:                 def.CPoM{},
-			StartTime:            time.Now(),
+			BPoM:                 def.BPoM{},
+			StartTime:            now,
 			TrafficCount:         0,
 			UpdateCount:          0,
 			ConvergeTime:         0,
+			store:                store,
 		}
 
 		// Initialize Bmodes per fragment to the global Bmode
 		for j := 0; j < numMonitors; j++ {
-			fsmLoggers[i].Bmodes[j] = restoredsetting.Broadcasting_Mode
+			fsmLoggers[i].Bmodes[j] = settings.Broadcasting_Mode
 		}
 	}
+	return fsmLoggers
+}
+
+// Function to initialize a MonitorEEA with specified numbers of FSMCAEEA and FSMLoggerEEA instances
+func NewMonitorEEA(CTngID def.CTngID, cryptofile string, settingfile string) *MonitorEEA {
+	// Initialize a new Settings object.
+	restoredsetting := new(def.Settings)
+	// Load the settings, the public configuration and the keys of this monitor only.
+	def.LoadData(&restoredsetting, settingfile)
+	config, err := def.LoadCrypto(cryptofile, CTngID)
+	if err != nil {
+		log.Fatalf("Failed to load the keys of %s: %v", CTngID, err)
+	}
+
+	m := InitMonitorEEA(CTngID, config, restoredsetting, def.RealClock{})
+	m.KeyFile = def.KeyFile(cryptofile, CTngID)
+	return m
+}
 
-	allmonitors := def.GetMonitorURL(*restoredsetting)
+// InitMonitorEEA builds a monitor from a loaded configuration, the simulator passes its virtual clock
+func InitMonitorEEA(CTngID def.CTngID, config *def.GlobalCrypto, settings *def.Settings, clock def.Clock) *MonitorEEA {
+	// Loggers and CAs start from period 1
+	fsmCAs := newFSMCAEEAs(settings, 1, nil, clock.Now())
+	fsmLoggers := newFSMLoggerEEAs(settings, 1, nil, clock.Now())
+
+	allmonitors := def.GetMonitorURL(*settings)
 	targets := make(map[def.CTngID]string)
 	var ip_port string
 	for key, value := range allmonitors {
@@ -138,10 +157,138 @@ func NewMonitorEEA(CTngID def.CTngID, cryptofile string, settingfile string) *Mo
 		Self_ip_port:      ip_port,
 		Crypto:            config,
 		Broadcast_targets: targets,
-		Settings:          restoredsetting,
+		Settings:          settings,
+		Period:            1,
+		periodStart:       clock.Now(),
 		FSMCAEEAs:         fsmCAs,
 		FSMLoggerEEAs:     fsmLoggers,
+		CAHistory:         map[int][]*FSMCAEEA{1: fsmCAs},
+		LoggerHistory:     map[int][]*FSMLoggerEEA{1: fsmLoggers},
+		CRVs:              make(map[def.CTngID]CRVState),
+		Clock:             clock,
+	}
+}
+
+// AdvancePeriod rolls the state machines forward up to the given period.
+// It must only be called after the signature of an STH/SRH for that period has been verified.
+// The state machines of older periods are kept in the history; their bulky shares are released.
+// Periods past the next one, plus one per MUD spent in the current period, are rejected:
+// a single head of a faulty Logger or CA would otherwise allocate the state of every period up to its own.
+// Entering a new period starts the verification timers of every Logger and CA, so the silent ones are accused too.
+func (m *MonitorEEA) AdvancePeriod(period int) error {
+	advanced, err := m.advancePeriod(period)
+	if advanced {
+		m.lock.RLock()
+		fsmLoggers, fsmCAs := m.FSMLoggerEEAs, m.FSMCAEEAs
+		m.lock.RUnlock()
+		startPeriodTimers(m, fsmLoggers, fsmCAs)
+	}
+	return err
+}
+
+// advancePeriod rolls the state machines forward without starting timers, true if the monitor entered a new period
+func (m *MonitorEEA) advancePeriod(period int) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	latest := m.Period + 1
+	if mud := time.Duration(m.Settings.MUD) * time.Second; mud > 0 {
+		latest += int(m.Clock.Now().Sub(m.periodStart) / mud)
+	}
+	if period > latest {
+		return false, fmt.Errorf("Period %d is past period %d, the latest this monitor can be in", period, latest)
+	}
+	advanced := m.Period < period
+	for m.Period < period {
+		m.Period++
+		m.periodStart = m.Clock.Now()
+		m.FSMCAEEAs = newFSMCAEEAs(m.Settings, m.Period, m.store, m.Clock.Now())
+		m.FSMLoggerEEAs = newFSMLoggerEEAs(m.Settings, m.Period, m.store, m.Clock.Now())
+		persist(m.store, Record{Kind: "period", Period: m.Period})
+		m.CAHistory[m.Period] = m.FSMCAEEAs
+		m.LoggerHistory[m.Period] = m.FSMLoggerEEAs
+		// Monitors that are one period behind may still request shares of the previous period.
+		for _, fsmlogger := range m.LoggerHistory[m.Period-2] {
+			fsmlogger.Prune()
+		}
+		for _, fsmca := range m.CAHistory[m.Period-2] {
+			fsmca.Prune()
+		}
+		compactStorage(m.store, m.Period-2)
+		fmt.Println(def.BLUE+"Monitor", m.CTngID, "entering period", m.Period, def.RESET)
+	}
+	return advanced, nil
+}
+
+// startPeriodTimers starts the verification timers of the Loggers and CAs of a period, monitors in default mode only follow the Loggers
+func startPeriodTimers(m *MonitorEEA, fsmLoggers []*FSMLoggerEEA, fsmCAs []*FSMCAEEA) {
+	for _, fsmlogger := range fsmLoggers {
+		if m.Settings.Distribution_Mode == def.EEA {
+			startSTHTimer(m, fsmlogger)
+		} else {
+			startDefaultSTHTimer(m, fsmlogger)
+		}
+	}
+	if m.Settings.Distribution_Mode != def.EEA {
+		return
+	}
+	for _, fsmca := range fsmCAs {
+		startSRHTimer(m, fsmca)
+	}
+}
+
+// GetPeriod returns the latest period this monitor has seen.
+func (m *MonitorEEA) GetPeriod() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.Period
+}
+
+// GetFSMLogger returns the state machine of a Logger for the given period.
+func (m *MonitorEEA) GetFSMLogger(id def.CTngID, period int) (*FSMLoggerEEA, error) {
+	index, err := def.MapIDtoInt(id)
+	if err != nil {
+		return nil, err
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	fsmLoggers, ok := m.LoggerHistory[period]
+	if !ok {
+		return nil, fmt.Errorf("no state for period %d", period)
+	}
+	if index < 0 || index >= len(fsmLoggers) || fsmLoggers[index].CTngID != id {
+		return nil, fmt.Errorf("unknown Logger: %s", id)
+	}
+	return fsmLoggers[index], nil
+}
+
+// GetFSMCA returns the state machine of a CA for the given period.
+func (m *MonitorEEA) GetFSMCA(id def.CTngID, period int) (*FSMCAEEA, error) {
+	index, err := def.MapIDtoInt(id)
+	if err != nil {
+		return nil, err
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	fsmCAs, ok := m.CAHistory[period]
+	if !ok {
+		return nil, fmt.Errorf("no state for period %d", period)
+	}
+	if index < 0 || index >= len(fsmCAs) || fsmCAs[index].CTngID != id {
+		return nil, fmt.Errorf("unknown CA: %s", id)
+	}
+	return fsmCAs[index], nil
+}
+
+// GetPeriods returns all the periods with recorded state, in ascending order.
+func (m *MonitorEEA) GetPeriods() []int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	periods := make([]int, 0, len(m.LoggerHistory))
+	for period := range m.LoggerHistory {
+		periods = append(periods, period)
 	}
+	sort.Ints(periods)
+	return periods
 }
 
 func (m *MonitorEEA) ThresholdSign(msg string) def.SigFragment {
@@ -0,0 +1,160 @@
+package sim
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// Epoch is the virtual time every simulation starts from, so STH and SRH timestamps are reproducible
+var Epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// event is a callback waiting in the queue of the VirtualClock.
+// Events due at the same instant run in key order, then in the order they were scheduled.
+type event struct {
+	when      time.Time
+	key       string
+	seq       uint64
+	f         func()
+	cancelled bool
+	index     int
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].when.Equal(q[j].when) {
+		return q[i].when.Before(q[j].when)
+	}
+	if q[i].key != q[j].key {
+		return q[i].key < q[j].key
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x interface{}) {
+	e := x.(*event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// VirtualClock implements def.Clock on a discrete event queue.
+// Time only moves when the simulation runs it, and the callbacks run one at a time on the caller's goroutine.
+type VirtualClock struct {
+	lock  sync.Mutex
+	now   time.Time
+	seq   uint64
+	queue eventQueue
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) def.Timer {
+	return c.Schedule(c.Now().Add(d), "", f)
+}
+
+// Schedule queues f to run at the given virtual time, times in the past run at the current time.
+// The key orders events due at the same instant, the network uses the link so deliveries never depend on map order.
+func (c *VirtualClock) Schedule(at time.Time, key string, f func()) def.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if at.Before(c.now) {
+		at = c.now
+	}
+	c.seq++
+	e := &event{when: at, key: key, seq: c.seq, f: f}
+	heap.Push(&c.queue, e)
+	return &virtualTimer{clock: c, event: e}
+}
+
+// Pending returns the number of events still queued
+func (c *VirtualClock) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.queue)
+}
+
+// next pops the first event due no later than deadline and moves the clock to it
+func (c *VirtualClock) next(deadline time.Time) *event {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for len(c.queue) > 0 && !c.queue[0].when.After(deadline) {
+		e := heap.Pop(&c.queue).(*event)
+		if e.cancelled {
+			continue
+		}
+		c.now = e.when
+		return e
+	}
+	return nil
+}
+
+// Step runs the next event, returns false once the queue is empty
+func (c *VirtualClock) Step() bool {
+	e := c.next(time.Unix(1<<62, 0))
+	if e == nil {
+		return false
+	}
+	e.f()
+	return true
+}
+
+// RunUntil runs every event due up to t, then leaves the clock at t
+func (c *VirtualClock) RunUntil(t time.Time) {
+	for e := c.next(t); e != nil; e = c.next(t) {
+		e.f()
+	}
+	c.lock.Lock()
+	if c.now.Before(t) {
+		c.now = t
+	}
+	c.lock.Unlock()
+}
+
+// RunFor advances the clock by d
+func (c *VirtualClock) RunFor(d time.Duration) {
+	c.RunUntil(c.Now().Add(d))
+}
+
+type virtualTimer struct {
+	clock *VirtualClock
+	event *event
+}
+
+// Stop cancels the callback, returns false if it already ran or was stopped
+func (t *virtualTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	if t.event.cancelled || t.event.index < 0 {
+		return false
+	}
+	t.event.cancelled = true
+	return true
+}
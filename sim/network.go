@@ -0,0 +1,207 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// LinkConfig describes a directed link between two entities
+type LinkConfig struct {
+	Latency   time.Duration // One way propagation delay
+	Bandwidth int           // Bytes per second, 0 is unlimited
+	Loss      float64       // Probability that a message is dropped
+}
+
+// LinkStats counts the traffic offered to a directed link
+type LinkStats struct {
+	Messages int
+	Bytes    int
+	Dropped  int
+}
+
+type link struct {
+	config    LinkConfig
+	rand      *rand.Rand
+	busyUntil time.Time // Messages on a link are serialized, the next one starts sending once this one is out
+	stats     LinkStats
+}
+
+type node struct {
+	id      def.CTngID
+	handler http.Handler
+}
+
+type linkID struct {
+	from def.CTngID
+	to   def.CTngID
+}
+
+// Network delivers the HTTP messages of the entities through the virtual clock.
+// POST requests are acknowledged right away and handed to the receiver once they crossed the link,
+// GET requests are relying party queries and are served immediately.
+type Network struct {
+	Clock       *VirtualClock
+	DefaultLink LinkConfig
+	seed        int64
+	lock        sync.Mutex
+	nodes       map[string]*node // Registered entities, keyed by ip:port
+	links       map[linkID]*link
+	configs     map[linkID]LinkConfig
+}
+
+func NewNetwork(clock *VirtualClock, seed int64) *Network {
+	return &Network{
+		Clock:   clock,
+		seed:    seed,
+		nodes:   make(map[string]*node),
+		links:   make(map[linkID]*link),
+		configs: make(map[linkID]LinkConfig),
+	}
+}
+
+// Register serves the entity at the given ip:port
+func (n *Network) Register(id def.CTngID, host string, handler http.Handler) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.nodes[host] = &node{id: id, handler: handler}
+}
+
+// SetLink overrides the DefaultLink for the messages from one entity to another
+func (n *Network) SetLink(from def.CTngID, to def.CTngID, config LinkConfig) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.configs[linkID{from, to}] = config
+	if l, ok := n.links[linkID{from, to}]; ok {
+		l.config = config
+	}
+}
+
+// Stats returns the traffic counted on the link from one entity to another
+func (n *Network) Stats(from def.CTngID, to def.CTngID) LinkStats {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if l, ok := n.links[linkID{from, to}]; ok {
+		return l.stats
+	}
+	return LinkStats{}
+}
+
+// TotalStats sums the traffic sent by an entity
+func (n *Network) TotalStats(from def.CTngID) LinkStats {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	var total LinkStats
+	for id, l := range n.links {
+		if id.from == from {
+			total.Messages += l.stats.Messages
+			total.Bytes += l.stats.Bytes
+			total.Dropped += l.stats.Dropped
+		}
+	}
+	return total
+}
+
+// getLink must be called with the lock held.
+// Every link draws its losses from its own source, seeded from the network seed and the link, so runs are reproducible.
+func (n *Network) getLink(from def.CTngID, to def.CTngID) *link {
+	id := linkID{from, to}
+	if l, ok := n.links[id]; ok {
+		return l
+	}
+	config, ok := n.configs[id]
+	if !ok {
+		config = n.DefaultLink
+	}
+	seed := n.seed
+	for _, c := range from.String() + "->" + to.String() {
+		seed = seed*31 + int64(c)
+	}
+	l := &link{config: config, rand: rand.New(rand.NewSource(seed))}
+	n.links[id] = l
+	return l
+}
+
+// send computes when a message of the given size reaches the other end of the link, or false if it is lost
+func (n *Network) send(from def.CTngID, to def.CTngID, size int) (time.Time, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	l := n.getLink(from, to)
+	l.stats.Messages++
+	l.stats.Bytes += size
+	if l.config.Loss > 0 && l.rand.Float64() < l.config.Loss {
+		l.stats.Dropped++
+		return time.Time{}, false
+	}
+	start := n.Clock.Now()
+	if l.busyUntil.After(start) {
+		start = l.busyUntil
+	}
+	done := start
+	if l.config.Bandwidth > 0 {
+		done = start.Add(time.Duration(int64(size) * int64(time.Second) / int64(l.config.Bandwidth)))
+	}
+	l.busyUntil = done
+	return done.Add(l.config.Latency), true
+}
+
+// Transport returns the RoundTripper of an entity, every request it makes is attributed to that entity
+func (n *Network) Transport(from def.CTngID) http.RoundTripper {
+	return &roundTripper{network: n, from: from}
+}
+
+type roundTripper struct {
+	network *Network
+	from    def.CTngID
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := t.network
+	n.lock.Lock()
+	dst, ok := n.nodes[req.URL.Host]
+	n.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no entity at %s", req.URL.Host)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	inner := httptest.NewRequest(req.Method, req.URL.String(), bytes.NewReader(body))
+	inner.Header = req.Header.Clone()
+	inner.RemoteAddr = t.from.String()
+
+	if req.Method == http.MethodGet {
+		recorder := httptest.NewRecorder()
+		dst.handler.ServeHTTP(recorder, inner)
+		return recorder.Result(), nil
+	}
+
+	if at, delivered := n.send(t.from, dst.id, len(body)); delivered {
+		n.Clock.Schedule(at, dst.id.String()+"<-"+t.from.String(), func() {
+			dst.handler.ServeHTTP(httptest.NewRecorder(), inner)
+		})
+	}
+	return &http.Response{
+		Status:     "202 Accepted",
+		StatusCode: http.StatusAccepted,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
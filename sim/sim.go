@@ -0,0 +1,104 @@
+// Package sim runs every monitor, Logger and CA of a configuration in one process,
+// over a virtual network driven by a virtual clock, so whole protocol runs fit in a go test.
+package sim
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	ca "github.com/jik18001/CTngV3/ca"
+	def "github.com/jik18001/CTngV3/def"
+	logger "github.com/jik18001/CTngV3/logger"
+	monitor "github.com/jik18001/CTngV3/monitor"
+	transport "github.com/jik18001/CTngV3/transport"
+)
+
+type Simulation struct {
+	Clock    *VirtualClock
+	Network  *Network
+	Settings *def.Settings
+	Crypto   *def.GlobalCrypto
+	Monitors []*monitor.MonitorEEA
+	Loggers  []*logger.Logger
+	CAs      []*ca.CA
+}
+
+// New builds every entity of the settings, the seed drives the random delays, DCRVs and link losses
+func New(settings *def.Settings, crypto *def.GlobalCrypto, seed int64) *Simulation {
+	rand.Seed(seed)
+	clock := NewVirtualClock(Epoch)
+	s := &Simulation{
+		Clock:    clock,
+		Network:  NewNetwork(clock, seed),
+		Settings: settings,
+		Crypto:   crypto,
+	}
+	for _, id := range sortedIDs('M', settings) {
+		m := monitor.InitMonitorEEA(id, crypto, settings, clock)
+		if settings.Distribution_Mode == def.EEA {
+			s.register(id, monitor.NewEEARouter(m, s.client(id)))
+		} else {
+			s.register(id, monitor.NewDefaultRouter(m, s.client(id)))
+		}
+		s.Monitors = append(s.Monitors, m)
+	}
+	for _, id := range sortedIDs('L', settings) {
+		l := logger.InitLogger(id, crypto, settings, clock)
+		l.Transport = transport.NewHTTP(s.client(id))
+		s.register(id, logger.NewLoggerRouter(l))
+		s.Loggers = append(s.Loggers, l)
+	}
+	for _, id := range sortedIDs('C', settings) {
+		c := ca.InitCA(id, crypto, settings, clock)
+		c.Transport = transport.NewHTTP(s.client(id))
+		s.register(id, ca.NewCARouter(c))
+		s.CAs = append(s.CAs, c)
+	}
+	return s
+}
+
+func sortedIDs(prefix byte, settings *def.Settings) []def.CTngID {
+	ids := def.GetIDs(prefix, *settings)
+	sort.Slice(ids, func(i, j int) bool { return def.GetIndex(ids[i]) < def.GetIndex(ids[j]) })
+	return ids
+}
+
+func (s *Simulation) client(id def.CTngID) *http.Client {
+	return &http.Client{Transport: s.Network.Transport(id)}
+}
+
+func (s *Simulation) register(id def.CTngID, handler http.Handler) {
+	s.Network.Register(id, s.Settings.Ipmap[id]+":"+s.Settings.Portmap[id], handler)
+}
+
+// Start queues the first period of every Logger and CA at the current virtual time
+func (s *Simulation) Start() {
+	for _, l := range s.Loggers {
+		s.Clock.AfterFunc(0, l.PeriodicTasks)
+	}
+	for _, c := range s.CAs {
+		s.Clock.AfterFunc(0, c.PeriodicTasks)
+	}
+}
+
+// Run advances the virtual clock by d, running every message and timer due in between
+func (s *Simulation) Run(d time.Duration) {
+	s.Clock.RunFor(d)
+}
+
+// RunPeriods starts the entities and runs the configured number of periods, plus one MUD for the last one to converge
+func (s *Simulation) RunPeriods() {
+	s.Start()
+	s.Run(time.Duration(s.Settings.MUD*(s.Settings.Num_Periods+1)) * time.Second)
+}
+
+// ConvergeTimes gathers the records DumpConvergeTimesToFile would write, for every monitor
+func (s *Simulation) ConvergeTimes() []monitor.ConvergeTimeRecord {
+	var records []monitor.ConvergeTimeRecord
+	for _, m := range s.Monitors {
+		records = append(records, m.ConvergeTimes()...)
+	}
+	return records
+}
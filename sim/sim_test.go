@@ -0,0 +1,241 @@
+package sim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	def "github.com/jik18001/CTngV3/def"
+	monitor "github.com/jik18001/CTngV3/monitor"
+)
+
+// testConfig builds its own keys and settings, the files in def are rewritten by the tests of that package
+func testConfig() (*def.Settings, *def.GlobalCrypto) {
+	crypto := def.CTngKeyGen(2, 2, 4, 3)
+	settings := def.Generate_IP_Json_template(
+		2, 2, 4, 2,
+		"127.0.0.", 10, "127.0.1.", 20, "127.0.2.", 30,
+		8000, 5, 0, 6, 10,
+		30, def.EEA, def.MIN_WT, 10000, 0.002, 2000, 20,
+	)
+	settings.Num_Periods = 2
+	// with 2 of the 4 monitors malicious the CAs cannot use f+1 data shares, only the 2 honest ones are sure to answer
+	settings.Erasure_K = 2
+	return settings, crypto
+}
+
+func TestVirtualClock(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	var order []string
+	clock.AfterFunc(2*time.Second, func() { order = append(order, "b") })
+	clock.Schedule(Epoch.Add(time.Second), "z", func() { order = append(order, "z") })
+	clock.Schedule(Epoch.Add(time.Second), "a", func() {
+		order = append(order, "a")
+		// callbacks may queue more work at the current instant
+		clock.AfterFunc(0, func() { order = append(order, "a2") })
+	})
+	stopped := clock.AfterFunc(time.Second, func() { order = append(order, "stopped") })
+	if !stopped.Stop() {
+		t.Fatal("Stop should cancel a queued callback")
+	}
+	clock.RunFor(1500 * time.Millisecond)
+	// timers have no key, so they run ahead of the deliveries due at the same instant
+	if !reflect.DeepEqual(order, []string{"a", "a2", "z"}) {
+		t.Fatalf("Unexpected order %v", order)
+	}
+	if !clock.Now().Equal(Epoch.Add(1500 * time.Millisecond)) {
+		t.Fatalf("Clock at %v", clock.Now())
+	}
+	clock.RunFor(time.Second)
+	if len(order) != 4 || clock.Pending() != 0 {
+		t.Fatalf("Unexpected order %v", order)
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	settings, crypto := testConfig()
+	run := func() *Simulation {
+		s := New(settings, crypto, 1)
+		s.Network.DefaultLink = LinkConfig{Latency: 20 * time.Millisecond, Bandwidth: 10 * 1024 * 1024}
+		s.RunPeriods()
+		return s
+	}
+	s := run()
+
+	records := convergeTimes(t, s)
+	for _, m := range s.Monitors {
+		for _, period := range m.GetPeriods() {
+			for _, l := range s.Loggers {
+				fsmlogger, err := m.GetFSMLogger(l.CTngID, period)
+				if err != nil || !fsmlogger.IsSignaturePresent() {
+					t.Errorf("%s has no threshold signature on the STH of %s in period %d", m.CTngID, l.CTngID, period)
+				}
+			}
+		}
+	}
+	if traffic := s.Network.TotalStats(s.Loggers[0].CTngID); traffic.Messages != settings.Num_Monitors*settings.Num_Periods {
+		t.Errorf("Logger sent %d messages", traffic.Messages)
+	}
+
+	// the same seed replays the same run
+	if again := run().ConvergeTimes(); !reflect.DeepEqual(records, again) {
+		t.Fatalf("Runs with the same seed differ:\n%v\n%v", records, again)
+	}
+}
+
+func TestSimulationLoss(t *testing.T) {
+	settings, crypto := testConfig()
+	s := New(settings, crypto, 7)
+	s.Network.DefaultLink = LinkConfig{Latency: 20 * time.Millisecond}
+	// every update from L1 to M1 is lost, M1 has to recover its share from the other monitors
+	s.Network.SetLink("L1", "M1", LinkConfig{Loss: 1})
+	s.RunPeriods()
+	if stats := s.Network.Stats("L1", "M1"); stats.Dropped != settings.Num_Periods {
+		t.Fatalf("Expected %d dropped updates, got %d", settings.Num_Periods, stats.Dropped)
+	}
+	fsmlogger, err := s.Monitors[0].GetFSMLogger("L1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fsmlogger.IsSignaturePresent() {
+		t.Fatal("M1 did not converge on L1 without its update")
+	}
+}
+
+// pomTimes maps every monitor to the time it took to convict the entity in the given period, 0 if it did not
+func pomTimes(t *testing.T, s *Simulation, entity def.CTngID, period int) map[def.CTngID]float64 {
+	times := make(map[def.CTngID]float64)
+	for _, record := range s.ConvergeTimes() {
+		if def.CTngID(record.EntityID) == entity && record.Period == period {
+			times[def.CTngID(record.MonitorID)] = record.PoMTime
+		}
+	}
+	if len(times) != s.Settings.Num_Monitors {
+		t.Fatalf("Expected a record of %s in period %d from every monitor, got %v", entity, period, times)
+	}
+	return times
+}
+
+// convergeTimes returns the converge time records of a run, checking there is one per monitor, entity and period and all converged
+func convergeTimes(t *testing.T, s *Simulation) []monitor.ConvergeTimeRecord {
+	records := s.ConvergeTimes()
+	expected := s.Settings.Num_Monitors * (s.Settings.Num_Loggers + s.Settings.Num_CAs) * s.Settings.Num_Periods
+	if len(records) != expected {
+		t.Fatalf("Expected %d converge time records, got %d", expected, len(records))
+	}
+	for _, record := range records {
+		if record.ConvergeTime <= 0 || record.PoMTime != 0 {
+			t.Errorf("%s did not converge on %s in period %d", record.MonitorID, record.EntityID, record.Period)
+		}
+	}
+	return records
+}
+
+func TestFaults(t *testing.T) {
+	run := func(faults ...def.Fault) *Simulation {
+		settings, crypto := testConfig()
+		settings.Faults = faults
+		if err := def.ValidateFaults(*settings); err != nil {
+			t.Fatal(err)
+		}
+		s := New(settings, crypto, 3)
+		s.Network.DefaultLink = LinkConfig{Latency: 20 * time.Millisecond}
+		s.RunPeriods()
+		return s
+	}
+
+	t.Run("equivocate", func(t *testing.T) {
+		s := run(def.Fault{Entity: "L1", Behavior: def.FAULT_EQUIVOCATE, Targets: []def.CTngID{"M1"}, Periods: []int{2}})
+		for id, pomtime := range pomTimes(t, s, "L1", 2) {
+			if pomtime <= 0 {
+				t.Errorf("%s did not convict L1 in period 2", id)
+			}
+		}
+		for id, pomtime := range pomTimes(t, s, "L1", 1) {
+			if pomtime != 0 {
+				t.Errorf("%s convicted L1 in period 1", id)
+			}
+		}
+	})
+
+	t.Run("withhold", func(t *testing.T) {
+		s := run(def.Fault{Entity: "C2", Behavior: def.FAULT_WITHHOLD, Targets: []def.CTngID{"M2", "M3", "M4"}})
+		for _, period := range []int{1, 2} {
+			for id, pomtime := range pomTimes(t, s, "C2", period) {
+				if pomtime <= 0 {
+					t.Errorf("%s did not convict C2 in period %d", id, period)
+				}
+			}
+		}
+	})
+
+	t.Run("silent", func(t *testing.T) {
+		// the timers of a period start with it, a CA that sends nothing at all is accused too
+		s := run(def.Fault{Entity: "C2", Behavior: def.FAULT_WITHHOLD, Periods: []int{2}})
+		for id, pomtime := range pomTimes(t, s, "C2", 2) {
+			if pomtime <= 0 {
+				t.Errorf("%s did not convict the silent C2 in period 2", id)
+			}
+		}
+	})
+
+	t.Run("withhold one share", func(t *testing.T) {
+		// the certificate file and the DCRV are decoded from the other shares, parity shares included
+		s := run(def.Fault{Entity: "L1", Behavior: def.FAULT_WITHHOLD, Targets: []def.CTngID{"M1"}},
+			def.Fault{Entity: "C1", Behavior: def.FAULT_WITHHOLD, Targets: []def.CTngID{"M2"}})
+		convergeTimes(t, s)
+	})
+
+	t.Run("bad encoding", func(t *testing.T) {
+		s := run(def.Fault{Entity: "L1", Behavior: def.FAULT_BAD_ENCODING, Targets: []def.CTngID{"M4"}, Periods: []int{1}},
+			def.Fault{Entity: "C1", Behavior: def.FAULT_BAD_ENCODING, Targets: []def.CTngID{"M1"}, Periods: []int{2}})
+		for entity, period := range map[def.CTngID]int{"L1": 1, "C1": 2} {
+			for id, pomtime := range pomTimes(t, s, entity, period) {
+				if pomtime <= 0 {
+					t.Errorf("%s did not convict %s in period %d", id, entity, period)
+				}
+			}
+		}
+		for id, pomtime := range pomTimes(t, s, "L1", 2) {
+			if pomtime != 0 {
+				t.Errorf("%s convicted L1 in period 2", id)
+			}
+		}
+	})
+
+	t.Run("bogus signature", func(t *testing.T) {
+		s := run(def.Fault{Entity: "M4", Behavior: def.FAULT_BOGUS_SIG})
+		for _, record := range s.ConvergeTimes() {
+			if record.MonitorID != "M4" && record.ConvergeTime <= 0 {
+				t.Errorf("%s did not converge on %s in period %d", record.MonitorID, record.EntityID, record.Period)
+			}
+			if record.PoMTime != 0 {
+				t.Errorf("%s convicted %s in period %d", record.MonitorID, record.EntityID, record.Period)
+			}
+		}
+	})
+}
+
+func TestSignatureSchemes(t *testing.T) {
+	settings, _ := testConfig()
+	settings.DSS_Schemes = map[def.CTngID]string{"L1": def.DSS_ED25519, "C1": def.DSS_ECDSA}
+	crypto := def.CTngKeyGenSchemes(2, 2, 4, 3, settings.DSS_Schemes)
+	s := New(settings, crypto, 1)
+	s.RunPeriods()
+	convergeTimes(t, s)
+}
+
+func TestErasurePolicies(t *testing.T) {
+	for _, tweak := range []func(*def.Settings){
+		func(s *def.Settings) { s.Erasure_Policy = def.EC_F_PLUS_1 },
+		func(s *def.Settings) { s.Erasure_K = 1 },
+		// LT codes default to k=(n-f)/2, a single data share here
+		func(s *def.Settings) { s.Erasure_Code, s.Erasure_K = def.EC_LT, 0 },
+	} {
+		settings, crypto := testConfig()
+		tweak(settings)
+		s := New(settings, crypto, 1)
+		s.RunPeriods()
+		convergeTimes(t, s)
+	}
+}
@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// HTTP posts every message as JSON to http://<address><endpoint>, or https:// once TLS is enabled.
+// The Router serves the registered endpoints, entities add their query endpoints to it as well.
+type HTTP struct {
+	Client *http.Client
+	Router *mux.Router
+	TLS    *tls.Config // Set by EnableTLS
+}
+
+func NewHTTP(client *http.Client) *HTTP {
+	return &HTTP{
+		Client: client,
+		Router: mux.NewRouter().StrictSlash(true),
+	}
+}
+
+// EnableTLS authenticates both ends of every message with the certificates of the config.
+// The client presents its certificate as well unless it was built on a custom RoundTripper.
+func (t *HTTP) EnableTLS(config *tls.Config) {
+	t.TLS = config
+	if t.Client.Transport == nil {
+		t.Client.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	if tr, ok := t.Client.Transport.(*http.Transport); ok {
+		tr.TLSClientConfig = config
+	}
+}
+
+func (t *HTTP) Send(to string, endpoint string, data []byte) error {
+	protocol := def.PROTOCOL
+	if t.TLS != nil {
+		protocol = def.PROTOCOL_TLS
+	}
+	response, err := t.Client.Post(protocol+to+endpoint, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	// Drain the body so the connection is reused
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s%s: %s", to, endpoint, response.Status)
+	}
+	return nil
+}
+
+func (t *HTTP) Broadcast(to []string, endpoint string, data []byte) {
+	broadcast(t, to, endpoint, data)
+}
+
+// Register answers 400 with the error of the handler, so a sender learns its message was rejected.
+// With TLS enabled, messages from peers without a CTng certificate are refused before reaching the handler.
+func (t *HTTP) Register(endpoint string, handler Handler) {
+	t.Router.HandleFunc(endpoint, RequirePeer(t, func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read message", http.StatusBadRequest)
+			return
+		}
+		if err := handler(def.PeerID(r.TLS), data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})).Methods("POST")
+}
+
+// RequirePeer refuses the requests of peers without a CTng certificate when the transport is an HTTP one with TLS enabled
+func RequirePeer(t Transport, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := t.(*HTTP); ok && h.TLS != nil && def.PeerID(r.TLS) == "" {
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// ListenAndServe serves the Router on the port, it only returns on error
+func (t *HTTP) ListenAndServe(port string) error {
+	return ListenAndServe(port, t.Router, t.TLS)
+}
+
+// ListenAndServe serves the handler on the port, over TLS when a config is given
+func ListenAndServe(port string, handler http.Handler, config *tls.Config) error {
+	if config == nil {
+		return http.ListenAndServe(":"+port, handler)
+	}
+	server := &http.Server{Addr: ":" + port, Handler: handler, TLSConfig: config}
+	// the certificate comes from the config
+	return server.ListenAndServeTLS("", "")
+}
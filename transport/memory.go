@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Network connects the in-memory transports of a single process
+type Network struct {
+	lock     sync.RWMutex
+	handlers map[string]map[string]Handler // Handlers of every address, keyed by endpoint
+}
+
+func NewNetwork() *Network {
+	return &Network{handlers: make(map[string]map[string]Handler)}
+}
+
+// Transport returns the transport of the entity at the given address
+func (n *Network) Transport(addr string) *Memory {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if _, ok := n.handlers[addr]; !ok {
+		n.handlers[addr] = make(map[string]Handler)
+	}
+	return &Memory{network: n, addr: addr}
+}
+
+// Memory hands every message to the handler of the receiver on its own goroutine, as an HTTP server would
+type Memory struct {
+	network *Network
+	addr    string
+}
+
+func (t *Memory) Send(to string, endpoint string, data []byte) error {
+	t.network.lock.RLock()
+	handler, ok := t.network.handlers[to][endpoint]
+	t.network.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for %s%s", to, endpoint)
+	}
+	// The sender may reuse its buffer once Send returns
+	data = append([]byte(nil), data...)
+	// every entity of the process is trusted, senders are not authenticated
+	go handler("", data)
+	return nil
+}
+
+func (t *Memory) Broadcast(to []string, endpoint string, data []byte) {
+	broadcast(t, to, endpoint, data)
+}
+
+func (t *Memory) Register(endpoint string, handler Handler) {
+	t.network.lock.Lock()
+	defer t.network.lock.Unlock()
+	t.network.handlers[t.addr][endpoint] = handler
+}
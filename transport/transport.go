@@ -0,0 +1,28 @@
+// Package transport carries the protocol messages between the entities.
+// Entities are addressed by their ip:port and messages by the endpoint they are sent to,
+// so the same handlers run over HTTP, in memory, or any other carrier.
+package transport
+
+import (
+	def "github.com/jik18001/CTngV3/def"
+)
+
+// Handler processes the payload of a message sent to an endpoint.
+// from is the CTngID the transport authenticated the sender as, empty when it does not authenticate its peers.
+type Handler func(from def.CTngID, data []byte) error
+
+type Transport interface {
+	// Send delivers data to the endpoint of the entity at the given address
+	Send(to string, endpoint string, data []byte) error
+	// Broadcast sends data to the endpoint of every address, failed sends are skipped
+	Broadcast(to []string, endpoint string, data []byte)
+	// Register routes the messages sent to an endpoint of this entity to the handler
+	Register(endpoint string, handler Handler)
+}
+
+// broadcast sends to every address one after the other, for transports without a cheaper fan out
+func broadcast(t Transport, to []string, endpoint string, data []byte) {
+	for _, addr := range to {
+		t.Send(addr, endpoint, data)
+	}
+}
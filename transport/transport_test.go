@@ -0,0 +1,146 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	def "github.com/jik18001/CTngV3/def"
+)
+
+func TestHTTP(t *testing.T) {
+	receiver := NewHTTP(&http.Client{})
+	received := make(chan string, 1)
+	receiver.Register("/monitor/echo", func(from def.CTngID, data []byte) error {
+		if string(data) == "bad" {
+			return errors.New("Rejected")
+		}
+		received <- string(data)
+		return nil
+	})
+	server := httptest.NewServer(receiver.Router)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	sender := NewHTTP(&http.Client{})
+	if err := sender.Send(addr, "/monitor/echo", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "hello" {
+		t.Fatalf("Received %q", got)
+	}
+	if err := sender.Send(addr, "/monitor/echo", []byte("bad")); err == nil {
+		t.Fatal("A rejected message should fail the send")
+	}
+	if err := sender.Send(addr, "/monitor/missing", []byte("hello")); err == nil {
+		t.Fatal("An unknown endpoint should fail the send")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	network := NewNetwork()
+	received := make(chan string, 3)
+	for _, addr := range []string{"m1", "m2", "m3"} {
+		addr := addr
+		network.Transport(addr).Register("/monitor/echo", func(from def.CTngID, data []byte) error {
+			received <- addr + ":" + string(data)
+			return nil
+		})
+	}
+	sender := network.Transport("l1")
+	buf := []byte("hello")
+	sender.Broadcast([]string{"m1", "m2", "m3"}, "/monitor/echo", buf)
+	// the payload was copied, the sender may reuse its buffer
+	copy(buf, "world")
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-received:
+			seen[got] = true
+		case <-time.After(time.Second):
+			t.Fatal("Message not delivered")
+		}
+	}
+	if !seen["m1:hello"] || !seen["m2:hello"] || !seen["m3:hello"] {
+		t.Fatalf("Received %v", seen)
+	}
+	if err := sender.Send("m4", "/monitor/echo", buf); err == nil {
+		t.Fatal("An unknown address should fail the send")
+	}
+}
+
+func TestTLS(t *testing.T) {
+	rootPEM, certs, err := def.GenerateTLSCertificates([]def.CTngID{"M1", "M2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crypto := &def.GlobalCrypto{TLS_CA: rootPEM, TLS_cert_map: certs}
+	config := func(id def.CTngID) *tls.Config {
+		config, err := crypto.TLSConfig(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	receiver := NewHTTP(&http.Client{})
+	receiver.EnableTLS(config("M1"))
+	senders := make(chan def.CTngID, 1)
+	receiver.Register("/monitor/echo", func(from def.CTngID, data []byte) error {
+		senders <- from
+		return nil
+	})
+	server := httptest.NewUnstartedServer(receiver.Router)
+	server.TLS = receiver.TLS
+	server.StartTLS()
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "https://")
+
+	sender := NewHTTP(&http.Client{})
+	sender.EnableTLS(config("M2"))
+	if err := sender.Send(addr, "/monitor/echo", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if from := <-senders; from != "M2" {
+		t.Fatalf("Sender authenticated as %q", from)
+	}
+
+	// a relying party reaches the server but may not send protocol messages
+	client, err := crypto.TLSClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous := NewHTTP(&http.Client{Transport: &http.Transport{TLSClientConfig: client}})
+	anonymous.TLS = client
+	if err := anonymous.Send(addr, "/monitor/echo", []byte("hello")); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("A sender without certificate should be refused, got %v", err)
+	}
+	// nor call the submission endpoints of a CA or Logger
+	receiver.Router.HandleFunc("/ca/issue", RequirePeer(receiver, func(w http.ResponseWriter, r *http.Request) {})).Methods("POST")
+	if err := anonymous.Send(addr, "/ca/issue", []byte("{}")); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("A caller without certificate should be refused, got %v", err)
+	}
+	if err := sender.Send(addr, "/ca/issue", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// certificates of another CTng root are not trusted
+	_, otherCerts, err := def.GenerateTLSCertificates([]def.CTngID{"M2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor := NewHTTP(&http.Client{})
+	impostor.EnableTLS(config("M2"))
+	other, err := tls.X509KeyPair(otherCerts["M2"].Cert, otherCerts["M2"].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor.TLS.Certificates = []tls.Certificate{other}
+	if err := impostor.Send(addr, "/monitor/echo", []byte("hello")); err == nil {
+		t.Fatal("A certificate of another root should fail the handshake")
+	}
+}